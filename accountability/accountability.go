@@ -1,8 +1,11 @@
 package accountability
 
 import (
-	"github.com/mikanikos/Fork-Accountability/common"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/common"
 )
 
 // MAIN API methods
@@ -13,6 +16,10 @@ type Accountability struct {
 	heightLogs    *HeightLogs
 	faultySet     *FaultySet
 	asyncMode     bool
+
+	// duration of the phases in the last run of the algorithm
+	preprocessDuration     time.Duration
+	faultDetectionDuration time.Duration
 }
 
 // NewAccountability creates a new Accountability structure
@@ -48,13 +55,13 @@ func (acc *Accountability) Init(numValidators uint64, async bool) {
 // IsCompleted returns true if the algorithm has completed, false otherwise
 func (acc *Accountability) IsCompleted() bool {
 	// if we have at least f + 1 faulty processes, the algorithm has completed
-	return acc.GetNumFaulty() >= acc.getValidityThreshold()
+	return acc.GetNumFaulty() >= acc.GetValidityThreshold()
 }
 
 // CanRun returns true if the algorithm has enough height vote sets to run, false otherwise
 func (acc *Accountability) CanRun() bool {
 	// if we have delivered at least f + 1 message logs, run the monitor algorithm
-	return acc.GetNumLogs() >= acc.getValidityThreshold()
+	return acc.GetNumLogs() >= acc.GetValidityThreshold()
 }
 
 // GetNumLogs returns the number of message logs received so far
@@ -67,16 +74,108 @@ func (acc *Accountability) GetNumFaulty() uint64 {
 	return uint64(acc.faultySet.Length())
 }
 
+// GetFaultyProcesses returns the ids (sorted) of the faulty processes detected in the last run of the algorithm
+func (acc *Accountability) GetFaultyProcesses() []string {
+	return acc.faultySet.Processes()
+}
+
+// GetFaults returns the reasons why the process has been found faulty in the last run of the algorithm, indexed by round
+// reasons not specific to a round (see Faultiness.IsRoundSpecific) are in round 0, together with the faults in round 0
+func (acc *Accountability) GetFaults(processID string) map[uint64][]Faultiness {
+	return acc.faultySet.Faults(processID)
+}
+
+// GetMessageLogs returns a copy of the message logs analyzed in the last run of the algorithm, including the ones inferred from the messages received by the other processes
+func (acc *Accountability) GetMessageLogs() map[string]*common.HeightVoteSet {
+	acc.heightLogs.mutex.RLock()
+	defer acc.heightLogs.mutex.RUnlock()
+
+	logs := make(map[string]*common.HeightVoteSet, len(acc.heightLogs.messageLogs))
+	for processID, hvs := range acc.heightLogs.messageLogs {
+		if hvs != nil {
+			logs[processID] = hvs.Copy()
+		}
+	}
+
+	return logs
+}
+
+// GetReceivedProcesses returns the ids (sorted) of the processes whose message logs have been received
+func (acc *Accountability) GetReceivedProcesses() []string {
+	acc.heightLogs.mutex.RLock()
+	defer acc.heightLogs.mutex.RUnlock()
+
+	processes := make([]string, 0, len(acc.heightLogs.receivedLogsMap))
+	for processID, received := range acc.heightLogs.receivedLogsMap {
+		if received {
+			processes = append(processes, processID)
+		}
+	}
+	sort.Strings(processes)
+
+	return processes
+}
+
+// GetEquivocations returns the equivocations detected in the last run of the algorithm, with the conflicting messages sent, sorted by process, round and type
+func (acc *Accountability) GetEquivocations() []*Equivocation {
+	return acc.collectEquivocations()
+}
+
+// GetPreprocessDuration returns the time spent in the preprocess phase during the last run of the algorithm
+func (acc *Accountability) GetPreprocessDuration() time.Duration {
+	return acc.preprocessDuration
+}
+
+// GetFaultDetectionDuration returns the time spent in the fault detection phase during the last run of the algorithm
+func (acc *Accountability) GetFaultDetectionDuration() time.Duration {
+	return acc.faultDetectionDuration
+}
+
 // StoreHvs returns true if the hvs was added, false if it was already present
 func (acc *Accountability) StoreHvs(processID string, hvs *common.HeightVoteSet) bool {
 	return acc.heightLogs.AddHvs(processID, hvs)
 }
 
-func (acc *Accountability) getValidityThreshold() uint64 {
+// GetValidityThreshold returns the minimum number of message logs needed to run the algorithm and of faulty processes to complete it (f + 1)
+func (acc *Accountability) GetValidityThreshold() uint64 {
 	// lower bound on the number of faulty processes and threshold for starting the algorithm
 	return (acc.numValidators-1)/3 + 1 // f+1
 }
 
+// GetQuorumThreshold returns the number of messages for the same value needed to justify a vote (2f + 1)
+func (acc *Accountability) GetQuorumThreshold() uint64 {
+	return acc.getQuorumThreshold()
+}
+
 func (acc *Accountability) getQuorumThreshold() uint64 {
 	return acc.numValidators - (acc.numValidators-1)/3 // 2f + 1
 }
+
+// MissingJustificationRounds returns the rounds (sorted) referenced by the justifications of the prevotes sent between the decision rounds that are not in the height vote set
+// the algorithm needs these rounds to check the justifications of the prevotes, so they should be requested to the validator
+func MissingJustificationRounds(hvs *common.HeightVoteSet, firstDecisionRound, secondDecisionRound uint64) []uint64 {
+	missingSet := make(map[uint64]bool)
+
+	for round := firstDecisionRound; round <= secondDecisionRound; round++ {
+		vs, loaded := hvs.VoteSetMap[round]
+		if vs == nil || !loaded {
+			continue
+		}
+
+		for _, prevote := range vs.SentPrevoteMessages {
+			for _, justification := range prevote.Justifications {
+				if _, present := hvs.VoteSetMap[justification.Round]; !present {
+					missingSet[justification.Round] = true
+				}
+			}
+		}
+	}
+
+	missing := make([]uint64, 0, len(missingSet))
+	for round := range missingSet {
+		missing = append(missing, round)
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+
+	return missing
+}
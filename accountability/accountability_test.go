@@ -2,6 +2,7 @@ package accountability
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/mikanikos/Fork-Accountability/utils"
@@ -472,3 +473,132 @@ func TestBasicScenario_TestFalseJustifications(t *testing.T) {
 		t.Fatal("Monitor failed to detect faulty processes")
 	}
 }
+
+func TestMissingJustificationRounds(t *testing.T) {
+	justification1 := common.NewMessage(common.Prevote, "2", 1, common.NewValue(5), nil)
+	justification2 := common.NewMessage(common.Prevote, "3", 2, common.NewValue(5), nil)
+
+	hvs := common.NewHeightVoteSet()
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 3, common.NewValue(5), []*common.Message{justification2, justification1}))
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 5, common.NewValue(6), []*common.Message{common.NewMessage(common.Prevote, "2", 0, common.NewValue(6), nil)}))
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 2, common.NewValue(6), nil))
+
+	// round 2 is in the logs and round 5 is out of the decision rounds
+	missing := MissingJustificationRounds(hvs, 3, 4)
+	if !reflect.DeepEqual(missing, []uint64{1}) {
+		t.Fatalf("Wrong missing rounds: %v", missing)
+	}
+}
+
+func TestGetEquivocations(t *testing.T) {
+
+	// create accountability struct
+	acc := NewAccountability()
+	acc.Init(4, false)
+
+	acc.StoreHvs("1", utils.GetHvsForDefaultConfig1WithNoJustifications())
+	acc.StoreHvs("2", utils.GetHvsForDefaultConfig2WithNoJustifications())
+	acc.StoreHvs("3", utils.GetHvsForDefaultConfig3WithNoJustifications())
+	acc.StoreHvs("4", utils.GetHvsForDefaultConfig4WithNoJustifications())
+
+	acc.Run(3, 4)
+
+	if !reflect.DeepEqual(acc.GetFaultyProcesses(), []string{"3", "4"}) {
+		t.Fatalf("Wrong faulty processes: %v", acc.GetFaultyProcesses())
+	}
+
+	equivocations := acc.GetEquivocations()
+	if len(equivocations) != 2 {
+		t.Fatalf("Wrong number of equivocations: %d", len(equivocations))
+	}
+
+	for i, processID := range []string{"3", "4"} {
+		equivocation := equivocations[i]
+		if equivocation.ProcessID != processID || equivocation.Round != 3 || equivocation.Type != common.Prevote || len(equivocation.Messages) != 2 {
+			t.Fatalf("Wrong equivocation detected: %+v", equivocation)
+		}
+
+		if equivocation.Messages[0].Value.Equal(equivocation.Messages[1].Value) {
+			t.Fatal("Equivocation messages should have different values")
+		}
+	}
+}
+
+func TestRunResult(t *testing.T) {
+
+	// create accountability struct
+	acc := NewAccountability()
+	acc.Init(4, false)
+
+	acc.StoreHvs("1", utils.GetHvsForDefaultConfig1WithNoJustifications())
+	// process 2 hvs missing
+	acc.StoreHvs("3", utils.GetHvsForDefaultConfig3WithNoJustifications())
+	acc.StoreHvs("4", utils.GetHvsForDefaultConfig4WithNoJustifications())
+
+	result := acc.Run(3, 4)
+
+	if result.IsAsync() || result.FirstDecisionRound() != 3 || result.SecondDecisionRound() != 4 {
+		t.Fatalf("Wrong parameters in result: %+v", result)
+	}
+
+	if result.NumValidators() != 4 || result.ValidityThreshold() != 2 || result.QuorumThreshold() != 3 || !result.IsCompleted() {
+		t.Fatalf("Wrong thresholds in result: %+v", result)
+	}
+
+	if !reflect.DeepEqual(result.FaultyProcesses(), []string{"2", "3", "4"}) || !reflect.DeepEqual(result.ReceivedProcesses(), []string{"1", "3", "4"}) {
+		t.Fatalf("Wrong processes in result: %v, %v", result.FaultyProcesses(), result.ReceivedProcesses())
+	}
+
+	// the message logs of process 2 are inferred from the messages received by the other processes
+	if !reflect.DeepEqual(result.AnalyzedProcesses(), []string{"1", "2", "3", "4"}) {
+		t.Fatalf("Wrong analyzed processes in result: %v", result.AnalyzedProcesses())
+	}
+
+	expectedFaults := []Fault{
+		{ProcessID: "2", Round: 0, RoundSpecific: false, Reason: faultinessMissingHvs},
+		{ProcessID: "3", Round: 4, RoundSpecific: true, Reason: faultinessMissingQuorumForPrevote},
+		{ProcessID: "4", Round: 4, RoundSpecific: true, Reason: faultinessMissingQuorumForPrevote},
+	}
+	if !reflect.DeepEqual(result.Faults(), expectedFaults) || !reflect.DeepEqual(result.FaultsOf("3"), expectedFaults[1:2]) || len(result.FaultsOf("1")) != 0 {
+		t.Fatalf("Wrong faults in result: %v", result.Faults())
+	}
+
+	if result.Duration() != result.PreprocessDuration()+result.FaultDetectionDuration() {
+		t.Fatal("Wrong duration in result")
+	}
+
+	// changes to the returned slices don't affect the result
+	result.FaultyProcesses()[0] = "1"
+	result.Faults()[0].Round = 3
+	result.ReceivedProcesses()[0] = "2"
+	if result.FaultyProcesses()[0] != "2" || result.Faults()[0].Round != 0 || result.ReceivedProcesses()[0] != "1" {
+		t.Fatal("Result should not be modified")
+	}
+
+	// a new run doesn't affect the result of the previous one
+	acc.StoreHvs("2", utils.GetHvsForDefaultConfig2WithNoJustifications())
+	newResult := acc.Run(3, 4)
+
+	if !reflect.DeepEqual(newResult.ReceivedProcesses(), []string{"1", "2", "3", "4"}) || !reflect.DeepEqual(newResult.FaultyProcesses(), []string{"3", "4"}) {
+		t.Fatalf("Wrong processes in new result: %v, %v", newResult.FaultyProcesses(), newResult.ReceivedProcesses())
+	}
+
+	if !reflect.DeepEqual(result.FaultyProcesses(), []string{"2", "3", "4"}) || !reflect.DeepEqual(result.Faults(), expectedFaults) || len(result.ReceivedProcesses()) != 3 {
+		t.Fatal("Result of the previous run should not be modified")
+	}
+
+	// a fault in round 0 is specific to the round
+	equivocating := common.NewHeightVoteSet()
+	equivocating.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(10), nil))
+	equivocating.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(20), nil))
+
+	acc = NewAccountability()
+	acc.Init(4, true)
+	acc.StoreHvs("1", equivocating)
+	acc.StoreHvs("2", common.NewHeightVoteSet())
+
+	faults := acc.Run(0, 1).Faults()
+	if len(faults) != 1 || faults[0].Round != 0 || !faults[0].RoundSpecific {
+		t.Fatalf("Wrong faults in round 0: %v", faults)
+	}
+}
@@ -1,14 +1,17 @@
 package accountability
 
 import (
-	"github.com/mikanikos/Fork-Accountability/common"
 	"sync"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/common"
 )
 
 // MAIN ALGORITHM MOVED TO ANOTHER FILE FOR BETTER ORGANIZATION
 
 // Run starts the accountability algorithm to detect which processes caused the fork and finds all processes that had bad behavior
-func (acc *Accountability) Run(firstDecisionRound, secondDecisionRound uint64) {
+// it returns the outcome of the run, which is not affected by the following runs
+func (acc *Accountability) Run(firstDecisionRound, secondDecisionRound uint64) *Result {
 
 	// lock logs to prevent other additions during the execution
 	acc.heightLogs.mutex.Lock()
@@ -18,10 +21,16 @@ func (acc *Accountability) Run(firstDecisionRound, secondDecisionRound uint64) {
 	acc.faultySet.Clear()
 
 	// first, preprocess messages by scanning all the received vote sets and add missing messages in the processes which omitted to have sent some messages
+	start := time.Now()
 	acc.preprocessPhase(firstDecisionRound, secondDecisionRound)
+	acc.preprocessDuration = time.Since(start)
 
 	// then, find faulty processes by analyzing their message logs
+	start = time.Now()
 	acc.faultDetectionPhase(firstDecisionRound, secondDecisionRound)
+	acc.faultDetectionDuration = time.Since(start)
+
+	return acc.newResult(firstDecisionRound, secondDecisionRound)
 }
 
 // Preprocess messages by scanning all the received vote sets and add missing messages in the respective votes sets of processes which omitted to have sent some messages
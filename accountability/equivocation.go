@@ -0,0 +1,53 @@
+package accountability
+
+import (
+	"sort"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+)
+
+// Equivocation is a proof that a process sent more than one message of the same type in the same round, with different values
+type Equivocation struct {
+	ProcessID string
+	Round     uint64
+	Type      common.MessageType
+	// conflicting messages sent by the process
+	Messages []*common.Message
+}
+
+// collect the equivocations detected in the last run of the algorithm, sorted by process, round and type (prevotes first)
+func (acc *Accountability) collectEquivocations() []*Equivocation {
+	acc.heightLogs.mutex.RLock()
+	defer acc.heightLogs.mutex.RUnlock()
+
+	equivocations := make([]*Equivocation, 0)
+
+	for _, processID := range acc.faultySet.Processes() {
+		hvs := acc.heightLogs.messageLogs[processID]
+		if hvs == nil {
+			continue
+		}
+
+		for _, round := range acc.faultySet.Rounds(processID, faultinessMultiplePrevotes) {
+			if vs := hvs.VoteSetMap[round]; vs != nil {
+				equivocations = append(equivocations, &Equivocation{ProcessID: processID, Round: round, Type: common.Prevote, Messages: append([]*common.Message{}, vs.SentPrevoteMessages...)})
+			}
+		}
+
+		for _, round := range acc.faultySet.Rounds(processID, faultinessMultiplePrecommits) {
+			if vs := hvs.VoteSetMap[round]; vs != nil {
+				equivocations = append(equivocations, &Equivocation{ProcessID: processID, Round: round, Type: common.Precommit, Messages: append([]*common.Message{}, vs.SentPrecommitMessages...)})
+			}
+		}
+	}
+
+	// processes are already sorted, prevotes come before precommits in the same round
+	sort.SliceStable(equivocations, func(i, j int) bool {
+		if equivocations[i].ProcessID != equivocations[j].ProcessID {
+			return equivocations[i].ProcessID < equivocations[j].ProcessID
+		}
+		return equivocations[i].Round < equivocations[j].Round
+	})
+
+	return equivocations
+}
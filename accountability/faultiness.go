@@ -14,3 +14,26 @@ const (
 	faultinessMissingQuorumForPrevote         = Faultiness("The process had sent PRECOMMIT message, and did not receive 2f + 1 PREVOTE messages for a sent PREVOTE message for another value to be issued")
 	faultinessMissingJustificationsForPrevote = Faultiness("The process had sent PRECOMMIT message, and did not have enough justifications (2f + 1 PREVOTE messages) in the sent PREVOTE message for another value to be issued")
 )
+
+// explanations of the faultiness reasons, for readers not familiar with the consensus algorithm
+var explanations = map[Faultiness]string{
+	faultinessMissingHvs:                      "The process did not send its message logs before the timeout expired, so its behaviour can't be verified. Correct processes always answer the monitor.",
+	faultinessMultiplePrevotes:                "The process voted for more than one value in the same round. A correct process sends at most one PREVOTE message in each round.",
+	faultinessMultiplePrecommits:              "The process committed to more than one value in the same round. A correct process sends at most one PRECOMMIT message in each round.",
+	faultinessMissingQuorumForPrecommit:       "The process sent a PRECOMMIT message for a value without having received PREVOTE messages for that value from a quorum (2f + 1) of processes in the same round, as required by the consensus algorithm.",
+	faultinessMissingQuorumForPrevote:         "The process was locked on a value because of a PRECOMMIT message sent in a previous round, but it voted for another value without having received PREVOTE messages for it from a quorum (2f + 1) of processes in the rounds since the lock.",
+	faultinessMissingJustificationsForPrevote: "The process was locked on a value because of a PRECOMMIT message sent in a previous round, but it voted for another value without attaching as justification PREVOTE messages for it from a quorum (2f + 1) of processes received in the rounds since the lock.",
+}
+
+// IsRoundSpecific returns true if the faultiness reason refers to the messages of a round, false if it concerns the process as a whole (missing message logs)
+func (fr Faultiness) IsRoundSpecific() bool {
+	return fr != faultinessMissingHvs
+}
+
+// Explanation returns a longer description of the faultiness reason
+func (fr Faultiness) Explanation() string {
+	if explanation, loaded := explanations[fr]; loaded {
+		return explanation
+	}
+	return string(fr)
+}
@@ -2,6 +2,7 @@ package accountability
 
 import (
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -102,6 +103,54 @@ func (fs *FaultySet) Length() int {
 	return len(fs.faultinessMap)
 }
 
+// Processes returns the ids of the faulty processes, sorted
+func (fs *FaultySet) Processes() []string {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	processes := make([]string, 0, len(fs.faultinessMap))
+	for processID := range fs.faultinessMap {
+		processes = append(processes, processID)
+	}
+	sort.Strings(processes)
+
+	return processes
+}
+
+// Rounds returns the rounds (sorted) in which the process has been found faulty for the given reason
+func (fs *FaultySet) Rounds(processID string, faultiness Faultiness) []uint64 {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	rounds := make([]uint64, 0)
+	for round, reasonsForRound := range fs.faultinessMap[processID] {
+		if _, loaded := reasonsForRound[faultiness]; loaded {
+			rounds = append(rounds, round)
+		}
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+
+	return rounds
+}
+
+// Faults returns the reasons (sorted) why the process has been found faulty, indexed by round
+func (fs *FaultySet) Faults(processID string) map[uint64][]Faultiness {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	faults := make(map[uint64][]Faultiness)
+	for round, reasonsForRound := range fs.faultinessMap[processID] {
+		reasons := make([]Faultiness, 0, len(reasonsForRound))
+		for reason := range reasonsForRound {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+		faults[round] = reasons
+	}
+
+	return faults
+}
+
 // Clear removes all elements in the FaultySet
 func (fs *FaultySet) Clear() {
 	fs.mutex.Lock()
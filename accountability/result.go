@@ -0,0 +1,170 @@
+package accountability
+
+import (
+	"sort"
+	"time"
+)
+
+// Fault is a reason why a process has been found faulty
+type Fault struct {
+	ProcessID string
+	// round of the fault, meaningful only if the reason is specific to a round
+	Round uint64
+	// false if the reason concerns the process as a whole (missing message logs), so the round is 0 but it's not a fault in round 0
+	RoundSpecific bool
+	Reason        Faultiness
+}
+
+// Result is the outcome of a run of the accountability algorithm
+// it's a snapshot taken at the end of the run: it can't be changed and it's not affected by the following runs
+type Result struct {
+	async               bool
+	firstDecisionRound  uint64
+	secondDecisionRound uint64
+
+	numValidators     uint64
+	validityThreshold uint64
+	quorumThreshold   uint64
+
+	// sorted by process, round and reason
+	faults []Fault
+	// sorted
+	faulty   []string
+	analyzed []string
+	received []string
+
+	preprocessDuration     time.Duration
+	faultDetectionDuration time.Duration
+}
+
+// take a snapshot of the outcome of the last run, the height logs must be locked by the caller
+func (acc *Accountability) newResult(firstDecisionRound, secondDecisionRound uint64) *Result {
+	result := &Result{
+		async:                  acc.asyncMode,
+		firstDecisionRound:     firstDecisionRound,
+		secondDecisionRound:    secondDecisionRound,
+		numValidators:          acc.numValidators,
+		validityThreshold:      acc.GetValidityThreshold(),
+		quorumThreshold:        acc.getQuorumThreshold(),
+		faults:                 make([]Fault, 0),
+		faulty:                 acc.faultySet.Processes(),
+		analyzed:               make([]string, 0, len(acc.heightLogs.messageLogs)),
+		received:               make([]string, 0, len(acc.heightLogs.receivedLogsMap)),
+		preprocessDuration:     acc.preprocessDuration,
+		faultDetectionDuration: acc.faultDetectionDuration,
+	}
+
+	for _, processID := range result.faulty {
+		faults := acc.faultySet.Faults(processID)
+
+		rounds := make([]uint64, 0, len(faults))
+		for round := range faults {
+			rounds = append(rounds, round)
+		}
+		sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+
+		for _, round := range rounds {
+			for _, reason := range faults[round] {
+				result.faults = append(result.faults, Fault{ProcessID: processID, Round: round, RoundSpecific: reason.IsRoundSpecific(), Reason: reason})
+			}
+		}
+	}
+
+	for processID, hvs := range acc.heightLogs.messageLogs {
+		if hvs == nil {
+			continue
+		}
+		result.analyzed = append(result.analyzed, processID)
+	}
+	sort.Strings(result.analyzed)
+
+	for processID, received := range acc.heightLogs.receivedLogsMap {
+		if received {
+			result.received = append(result.received, processID)
+		}
+	}
+	sort.Strings(result.received)
+
+	return result
+}
+
+// IsAsync returns true if the algorithm ran in the asynchronous version
+func (result *Result) IsAsync() bool {
+	return result.async
+}
+
+// FirstDecisionRound returns the round of the first decision given to the algorithm
+func (result *Result) FirstDecisionRound() uint64 {
+	return result.firstDecisionRound
+}
+
+// SecondDecisionRound returns the round of the second decision given to the algorithm
+func (result *Result) SecondDecisionRound() uint64 {
+	return result.secondDecisionRound
+}
+
+// NumValidators returns the number of validators of the height
+func (result *Result) NumValidators() uint64 {
+	return result.numValidators
+}
+
+// ValidityThreshold returns the number of message logs needed to run the algorithm and of faulty processes needed to complete it (f + 1)
+func (result *Result) ValidityThreshold() uint64 {
+	return result.validityThreshold
+}
+
+// QuorumThreshold returns the number of messages for the same value needed to justify a vote (2f + 1)
+func (result *Result) QuorumThreshold() uint64 {
+	return result.quorumThreshold
+}
+
+// IsCompleted returns true if at least f + 1 faulty processes have been found
+func (result *Result) IsCompleted() bool {
+	return uint64(len(result.faulty)) >= result.validityThreshold
+}
+
+// FaultyProcesses returns the ids (sorted) of the faulty processes
+func (result *Result) FaultyProcesses() []string {
+	return append([]string{}, result.faulty...)
+}
+
+// Faults returns the faults of all the processes, sorted by process, round and reason
+func (result *Result) Faults() []Fault {
+	return append([]Fault{}, result.faults...)
+}
+
+// FaultsOf returns the faults of a process, sorted by round and reason
+func (result *Result) FaultsOf(processID string) []Fault {
+	faults := make([]Fault, 0)
+	for _, fault := range result.faults {
+		if fault.ProcessID == processID {
+			faults = append(faults, fault)
+		}
+	}
+	return faults
+}
+
+// AnalyzedProcesses returns the ids (sorted) of the processes analyzed, including the ones whose message logs have been inferred from the messages received by the other processes
+func (result *Result) AnalyzedProcesses() []string {
+	return append([]string{}, result.analyzed...)
+}
+
+// ReceivedProcesses returns the ids (sorted) of the processes whose message logs have been received
+func (result *Result) ReceivedProcesses() []string {
+	return append([]string{}, result.received...)
+}
+
+// PreprocessDuration returns the time spent in the preprocess phase
+func (result *Result) PreprocessDuration() time.Duration {
+	return result.preprocessDuration
+}
+
+// FaultDetectionDuration returns the time spent in the fault detection phase
+func (result *Result) FaultDetectionDuration() time.Duration {
+	return result.faultDetectionDuration
+}
+
+// Duration returns the time spent in the whole run
+func (result *Result) Duration() time.Duration {
+	return result.preprocessDuration + result.faultDetectionDuration
+}
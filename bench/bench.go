@@ -0,0 +1,231 @@
+package bench
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/scenario"
+)
+
+// modes of the accountability algorithm
+const (
+	AsyncMode = "async"
+	SyncMode  = "sync"
+)
+
+// interval between two samples of the heap size while measuring the peak memory
+const heapSampleInterval = time.Millisecond
+
+// header of the CSV file with the results
+var csvHeader = []string{"validators", "rounds", "mode", "iterations", "preprocess_ns", "fault_detection_ns", "total_ns", "allocs_per_run", "bytes_per_run", "peak_heap_bytes"}
+
+// Input contains the message logs of a generated scenario, given to the accountability algorithm on every run
+type Input struct {
+	NumValidators       int
+	NumRounds           int
+	FirstDecisionRound  uint64
+	SecondDecisionRound uint64
+
+	// message logs of the validators answering the monitor
+	logs map[string]*common.HeightVoteSet
+}
+
+// Result contains the measurements of the accountability algorithm for a configuration, averaged over the iterations
+type Result struct {
+	NumValidators int
+	NumRounds     int
+	Mode          string
+	Iterations    int
+
+	PreprocessDuration     time.Duration
+	FaultDetectionDuration time.Duration
+	TotalDuration          time.Duration
+
+	// number of heap allocations and bytes allocated by a run
+	Allocs uint64
+	Bytes  uint64
+	// maximum heap size reached during a run, over the heap size before the run
+	PeakHeap uint64
+}
+
+// NewInput generates the message logs of the benchmark pattern with the given number of validators and rounds
+func NewInput(numValidators, numRounds int) (*Input, error) {
+	s, err := scenario.Pattern(numValidators, numRounds)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := s.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &Input{
+		NumValidators:       numValidators,
+		NumRounds:           numRounds,
+		FirstDecisionRound:  configs.Monitor.FirstDecisionRound,
+		SecondDecisionRound: configs.Monitor.SecondDecisionRound,
+		logs:                make(map[string]*common.HeightVoteSet),
+	}
+
+	for _, validator := range configs.Validators {
+		if validator.Byzantine != nil && validator.Byzantine.Mode == scenario.SilentMode {
+			continue
+		}
+		input.logs[validator.ID] = validator.Messages[configs.Monitor.Height]
+	}
+
+	return input, nil
+}
+
+// Accountability creates the accountability algorithm in the given mode with a copy of the message logs, ready to run
+// the logs are copied because the preprocess phase adds messages to them
+func (input *Input) Accountability(async bool) *accountability.Accountability {
+	acc := accountability.NewAccountability()
+	acc.Init(uint64(input.NumValidators), async)
+
+	for id, hvs := range input.logs {
+		acc.StoreHvs(id, hvs.Copy())
+	}
+
+	return acc
+}
+
+// Run runs the accountability algorithm on the input in the given mode for the given number of iterations and returns the average measurements
+func Run(input *Input, mode string, iterations int) (*Result, error) {
+	if mode != AsyncMode && mode != SyncMode {
+		return nil, fmt.Errorf("unknown mode %s", mode)
+	}
+	if iterations < 1 {
+		return nil, fmt.Errorf("at least one iteration is needed")
+	}
+
+	async := mode == AsyncMode
+	result := &Result{
+		NumValidators: input.NumValidators,
+		NumRounds:     input.NumRounds,
+		Mode:          mode,
+		Iterations:    iterations,
+	}
+
+	var before, after runtime.MemStats
+	for i := 0; i < iterations; i++ {
+		acc := input.Accountability(async)
+
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		accResult := acc.Run(input.FirstDecisionRound, input.SecondDecisionRound)
+		result.TotalDuration += time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		result.PreprocessDuration += accResult.PreprocessDuration()
+		result.FaultDetectionDuration += accResult.FaultDetectionDuration()
+		result.Allocs += after.Mallocs - before.Mallocs
+		result.Bytes += after.TotalAlloc - before.TotalAlloc
+	}
+
+	n := time.Duration(iterations)
+	result.PreprocessDuration /= n
+	result.FaultDetectionDuration /= n
+	result.TotalDuration /= n
+	result.Allocs /= uint64(iterations)
+	result.Bytes /= uint64(iterations)
+
+	// the peak memory is measured in a separate run, so that sampling the heap doesn't affect the other measurements
+	result.PeakHeap = peakHeap(input, async)
+
+	return result, nil
+}
+
+// run the algorithm once while sampling the heap size, and return the maximum heap size reached over the one before the run
+func peakHeap(input *Input, async bool) uint64 {
+	acc := input.Accountability(async)
+
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var peak uint64
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var sample runtime.MemStats
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+
+		for {
+			runtime.ReadMemStats(&sample)
+			if sample.HeapAlloc > peak {
+				peak = sample.HeapAlloc
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	acc.Run(input.FirstDecisionRound, input.SecondDecisionRound)
+	close(done)
+	wg.Wait()
+
+	// the heap size at the end of the run is a lower bound for the peak
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > peak {
+		peak = stats.HeapAlloc
+	}
+
+	if peak < baseline {
+		return 0
+	}
+	return peak - baseline
+}
+
+// WriteCSV writes the results in CSV format, with a header
+func WriteCSV(writer io.Writer, results []*Result) error {
+	w := csv.NewWriter(writer)
+
+	err := w.Write(csvHeader)
+	if err != nil {
+		return fmt.Errorf("error while writing results: %s", err)
+	}
+
+	for _, result := range results {
+		err = w.Write([]string{
+			strconv.Itoa(result.NumValidators),
+			strconv.Itoa(result.NumRounds),
+			result.Mode,
+			strconv.Itoa(result.Iterations),
+			strconv.FormatInt(result.PreprocessDuration.Nanoseconds(), 10),
+			strconv.FormatInt(result.FaultDetectionDuration.Nanoseconds(), 10),
+			strconv.FormatInt(result.TotalDuration.Nanoseconds(), 10),
+			strconv.FormatUint(result.Allocs, 10),
+			strconv.FormatUint(result.Bytes, 10),
+			strconv.FormatUint(result.PeakHeap, 10),
+		})
+		if err != nil {
+			return fmt.Errorf("error while writing results: %s", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("error while writing results: %s", err)
+	}
+
+	return nil
+}
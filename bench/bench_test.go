@@ -0,0 +1,113 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"testing"
+)
+
+// grid of the benchmarks, kept small so that they can run as part of the test suite with -bench
+var benchmarkValidators = []int{4, 10, 50, 100}
+var benchmarkRounds = []int{1, 10, 100}
+
+func TestRun(t *testing.T) {
+
+	input, err := NewInput(10, 5)
+	if err != nil {
+		t.Fatalf("Failed to generate input: %s", err)
+	}
+
+	results := make([]*Result, 0)
+	for _, mode := range []string{AsyncMode, SyncMode} {
+		result, err := Run(input, mode, 3)
+		if err != nil {
+			t.Fatalf("Failed to run benchmark in %s mode: %s", mode, err)
+		}
+
+		if result.NumValidators != 10 || result.NumRounds != 5 || result.Mode != mode || result.Iterations != 3 {
+			t.Fatalf("Wrong configuration in result: %+v", result)
+		}
+		if result.TotalDuration <= 0 || result.Allocs == 0 || result.Bytes == 0 {
+			t.Fatalf("Measurements missing in result: %+v", result)
+		}
+
+		results = append(results, result)
+	}
+
+	// the input must not be changed by the runs
+	acc := input.Accountability(true)
+	acc.Run(input.FirstDecisionRound, input.SecondDecisionRound)
+	if !acc.IsCompleted() {
+		t.Fatal("Algorithm should complete on the input after several runs")
+	}
+
+	var buf bytes.Buffer
+	err = WriteCSV(&buf, results)
+	if err != nil {
+		t.Fatalf("Failed to write results: %s", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read results: %s", err)
+	}
+
+	if len(records) != 3 || len(records[0]) != len(csvHeader) || records[1][2] != AsyncMode || records[2][2] != SyncMode {
+		t.Fatalf("Wrong results written: %v", records)
+	}
+}
+
+func TestRun_Invalid(t *testing.T) {
+
+	input, err := NewInput(4, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate input: %s", err)
+	}
+
+	if _, err := Run(input, "unknown", 1); err == nil {
+		t.Fatal("Unknown mode should be rejected")
+	}
+
+	if _, err := Run(input, AsyncMode, 0); err == nil {
+		t.Fatal("No iterations should be rejected")
+	}
+
+	if _, err := NewInput(3, 1); err == nil {
+		t.Fatal("Less than 4 validators should be rejected")
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, numValidators := range benchmarkValidators {
+		for _, numRounds := range benchmarkRounds {
+			input, err := NewInput(numValidators, numRounds)
+			if err != nil {
+				b.Fatalf("Failed to generate input: %s", err)
+			}
+
+			for _, mode := range []string{AsyncMode, SyncMode} {
+				async := mode == AsyncMode
+
+				b.Run(fmt.Sprintf("n=%d/m=%d/%s", numValidators, numRounds, mode), func(b *testing.B) {
+					b.ReportAllocs()
+
+					var preprocess, faultDetection int64
+					for i := 0; i < b.N; i++ {
+						b.StopTimer()
+						acc := input.Accountability(async)
+						b.StartTimer()
+
+						acc.Run(input.FirstDecisionRound, input.SecondDecisionRound)
+
+						preprocess += acc.GetPreprocessDuration().Nanoseconds()
+						faultDetection += acc.GetFaultDetectionDuration().Nanoseconds()
+					}
+
+					b.ReportMetric(float64(preprocess)/float64(b.N), "preprocess-ns/op")
+					b.ReportMetric(float64(faultDetection)/float64(b.N), "detection-ns/op")
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/mikanikos/Fork-Accountability/bench"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// value of the mode flag running both the asynchronous and the synchronous version
+const bothModes = "both"
+
+func main() {
+
+	// parse arguments
+	validatorsList := flag.String("validators", "4,10,50,100,250,500", "comma-separated numbers of validators of the benchmark pattern")
+	roundsList := flag.String("rounds", "1,10,100,500,1000", "comma-separated numbers of rounds of the benchmark pattern")
+	iterations := flag.Int("iterations", 5, "number of runs of the accountability algorithm for each configuration, the measurements are averaged")
+	mode := flag.String("mode", bothModes, "version of the accountability algorithm to run (async, sync or both)")
+	output := flag.String("output", "benchmarks/benchmark.csv", "path (relative to the project root directory) of the CSV file where the results are written")
+
+	// parse arguments
+	flag.Parse()
+
+	validators, err := parseList(*validatorsList)
+	if err != nil {
+		log.Fatalf("Benchmark exiting: invalid number of validators: %s", err)
+	}
+
+	rounds, err := parseList(*roundsList)
+	if err != nil {
+		log.Fatalf("Benchmark exiting: invalid number of rounds: %s", err)
+	}
+
+	modes := []string{*mode}
+	if *mode == bothModes {
+		modes = []string{bench.AsyncMode, bench.SyncMode}
+	}
+
+	results := make([]*bench.Result, 0, len(validators)*len(rounds)*len(modes))
+	for _, numValidators := range validators {
+		for _, numRounds := range rounds {
+			input, err := bench.NewInput(numValidators, numRounds)
+			if err != nil {
+				log.Fatalf("Benchmark exiting: error while generating message logs with %d validators and %d rounds: %s", numValidators, numRounds, err)
+			}
+
+			for _, m := range modes {
+				result, err := bench.Run(input, m, *iterations)
+				if err != nil {
+					log.Fatalf("Benchmark exiting: %s", err)
+				}
+
+				log.Printf("Benchmark: %d validators, %d rounds, %s mode: preprocess %s, fault detection %s, total %s, %d allocs, %d bytes, peak heap %d bytes",
+					numValidators, numRounds, m, result.PreprocessDuration, result.FaultDetectionDuration, result.TotalDuration, result.Allocs, result.Bytes, result.PeakHeap)
+
+				results = append(results, result)
+			}
+		}
+	}
+
+	file, err := utils.OpenFile(*output)
+	if err != nil {
+		log.Fatalf("Benchmark exiting: error while opening output file: %s", err)
+	}
+	defer file.Close()
+
+	err = bench.WriteCSV(file, results)
+	if err != nil {
+		log.Fatalf("Benchmark exiting: %s", err)
+	}
+
+	log.Printf("Benchmark: results written to %s", *output)
+}
+
+// parse a comma-separated list of positive integers
+func parseList(list string) ([]int, error) {
+	values := make([]int, 0)
+	for _, s := range strings.Split(list, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("%s is not a positive integer", s)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
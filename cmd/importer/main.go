@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mikanikos/Fork-Accountability/cometbft"
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+func main() {
+
+	// parse arguments
+	input := flag.String("input", "", "comma-separated paths (relative to the project root directory) of the consensus WAL files or JSON dumps to import, one for each node")
+	idsFile := flag.String("ids", "", "path (relative to the project root directory) of the file mapping the hex-encoded addresses of the validators to their ids, addresses used as ids if not given")
+	height := flag.Uint64("height", 0, "height of the message logs to import, all the heights (one subdirectory for each) if 0")
+	output := flag.String("output", "cmd/importer/_logs", "path (relative to the project root directory) of the directory where the message logs of each validator are written, in files named after their id")
+	format := flag.String("format", string(common.YAML), "format of the message logs written (yaml, json or protobuf)")
+
+	// parse arguments
+	flag.Parse()
+
+	if *input == "" {
+		log.Fatalf("Importer exiting: no input file given")
+	}
+
+	validators := &cometbft.ValidatorSet{}
+	if *idsFile != "" {
+		err := utils.ParseConfigFile(*idsFile, validators)
+		if err != nil {
+			log.Fatalf("Importer exiting: ids file not parsed correctly: %s", err)
+		}
+	}
+
+	for _, inputFile := range strings.Split(*input, ",") {
+		err := importFile(strings.TrimSpace(inputFile), validators.IDs, *height, *output, common.Format(*format))
+		if err != nil {
+			log.Fatalf("Importer exiting: %s", err)
+		}
+	}
+}
+
+// import the votes of a node and write the message logs of the validator running it
+func importFile(inputFile string, ids map[string]string, height uint64, output string, format common.Format) error {
+	inputPath, err := utils.GetProjectFilePath(inputFile)
+	if err != nil {
+		return err
+	}
+
+	importer := cometbft.NewImporter(ids)
+	err = importer.ImportFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	if importer.OwnerID() == "" {
+		return fmt.Errorf("error while importing %s: no vote cast by the node", inputFile)
+	}
+
+	heights := importer.Heights()
+	if height != 0 {
+		heights = []uint64{height}
+	}
+
+	for _, h := range heights {
+		hvs := importer.HeightVoteSet(h)
+		if hvs == nil {
+			return fmt.Errorf("error while importing %s: no votes for height %d", inputFile, h)
+		}
+
+		directory := output
+		if height == 0 {
+			directory = filepath.Join(output, strconv.FormatUint(h, 10))
+		}
+
+		err = writeLogs(hvs, directory, importer.OwnerID(), format)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Importer: message logs of validator %s for height %d imported from %s", importer.OwnerID(), h, inputFile)
+	}
+
+	return nil
+}
+
+// write the message logs of a validator in the given directory, in a file named after its id
+func writeLogs(hvs *common.HeightVoteSet, directory string, id string, format common.Format) error {
+	data, err := common.EncodeHeightVoteSet(hvs, format)
+	if err != nil {
+		return fmt.Errorf("error while encoding message logs of %s: %s", id, err)
+	}
+
+	directoryPath, err := utils.GetProjectFilePath(directory)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(directoryPath, 0755)
+	if err != nil {
+		return fmt.Errorf("error while creating output directory: %s", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(directoryPath, id+"."+extension(format)), data, 0644)
+	if err != nil {
+		return fmt.Errorf("error while writing message logs of %s: %s", id, err)
+	}
+
+	return nil
+}
+
+// get the extension of the files in the given format
+func extension(format common.Format) string {
+	if format == common.Protobuf {
+		return "pb"
+	}
+	return string(format)
+}
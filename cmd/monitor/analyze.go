@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// Metadata describes the validator set and the fork analyzed offline
+type Metadata struct {
+	Height              uint64   `yaml:"height"`
+	FirstDecisionRound  uint64   `yaml:"firstDecisionRound"`
+	SecondDecisionRound uint64   `yaml:"secondDecisionRound"`
+	Validators          []string `yaml:"validators"`
+}
+
+// create a new monitor for the offline analysis from a metadata file
+func newMonitorFromMetadata(metadataFile string) (*Monitor, error) {
+	metadata := &Metadata{}
+	err := utils.ParseConfigFile(metadataFile, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metadata.Validators) == 0 {
+		return nil, fmt.Errorf("error: no validators given")
+	}
+
+	monitor := NewMonitor()
+	monitor.Height = metadata.Height
+	monitor.FirstDecisionRound = metadata.FirstDecisionRound
+	monitor.SecondDecisionRound = metadata.SecondDecisionRound
+	monitor.Validators = metadata.Validators
+
+	return monitor, nil
+}
+
+// Analyze runs the accountability algorithm on the message logs stored in the given directory instead of requesting them from the validators
+// each file in the directory contains the height vote set of a validator, named after the validator id, in yaml, json or protobuf format
+func (monitor *Monitor) Analyze(logsDirectory string, report string, asyncMode bool) {
+
+	// write logs to file, if desired
+	defer logToReport(report)()
+
+	monitor.started = time.Now()
+	monitor.asyncMode = asyncMode
+
+	if debug {
+		log.Println("Monitor: started offline analysis")
+	}
+
+	err := monitor.loadLogs(logsDirectory)
+	if err != nil {
+		log.Fatalf("Monitor exiting: error loading message logs: %s", err)
+	}
+
+	// initialize accountability
+	monitor.accAlgorithm.Init(uint64(len(monitor.Validators)), asyncMode)
+
+	// run accountability algorithm once with all the message logs available
+	output := monitor.runFinalAccountabilityAlgorithm()
+	monitor.finished = time.Now()
+	monitor.status = output
+
+	if debug {
+		log.Println(output)
+	}
+}
+
+// load the valid message logs of the validators from the given directory
+func (monitor *Monitor) loadLogs(logsDirectory string) error {
+
+	directory, err := utils.GetProjectFilePath(logsDirectory)
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return fmt.Errorf("error while reading logs directory: %s", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		format, err := common.FormatFromPath(file.Name())
+		if err != nil {
+			continue
+		}
+
+		// the file name is the id of the validator
+		id := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+
+		if !monitor.isValidator(id) {
+			if debug {
+				log.Printf("Monitor: ignoring message logs of %s because it's not in the validator set", id)
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return fmt.Errorf("error while reading message logs of %s: %s", id, err)
+		}
+
+		hvs, err := common.DecodeHeightVoteSet(data, format)
+		if err != nil || !hvs.IsValid(id) {
+			logsInvalid.Inc()
+
+			if debug {
+				log.Printf("Monitor: invalid message logs for validator with ID %s", id)
+			}
+			continue
+		}
+
+		if monitor.storeHvs(id, hvs) {
+			logsReceived.Inc()
+
+			if debug {
+				log.Printf("Monitor: loaded height vote set of validator with ID %s. %d message logs have been loaded so far\n", id, monitor.accAlgorithm.GetNumLogs())
+			}
+		} else {
+			logsInvalid.Inc()
+
+			if debug {
+				log.Printf("Monitor: duplicated message logs for validator with ID %s", id)
+			}
+		}
+	}
+
+	logsMissing.Add(uint64(len(monitor.Validators)) - monitor.accAlgorithm.GetNumLogs())
+
+	return nil
+}
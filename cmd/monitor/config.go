@@ -6,6 +6,14 @@ const (
 	configPath = "cmd/monitor/_config/config.yaml"
 	reportPath = "cmd/monitor/_report/report.out"
 
+	// offline analysis
+	analyzeCommand = "analyze"
+	logsPath       = "cmd/monitor/_logs"
+	metadataPath   = "cmd/monitor/_config/metadata.yaml"
+
+	// sample scenario of network faults
+	faultsPath = "cmd/monitor/_config/faults.yaml"
+
 	successfulStatus = "Monitor: Algorithm completed"
 	failStatus       = "Monitor: Algorithm failed because not enough message logs have been received or the message logs received were not sufficient to find at least f+1 faulty processes"
 	timeoutStatus    = "Monitor: Algorithm failed because of timeout expiration"
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/cometbft"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// ExportEvidence writes the CometBFT evidence of the faults detected in the last run of the algorithm to the given file, as a JSON list
+// the validator set maps the ids to the addresses of the validators, the signed votes recorded by the nodes (consensus WAL files or JSON dumps) are used to sign the votes in the evidence
+// the time of the block at the height of the fork is the timestamp of the evidence, as required by the chain
+func (monitor *Monitor) ExportEvidence(evidenceFile string, validatorsFile string, signedVotesFiles []string, blockTime time.Time) error {
+	validators := &cometbft.ValidatorSet{}
+	err := utils.ParseConfigFile(validatorsFile, validators)
+	if err != nil {
+		return fmt.Errorf("error while parsing validator set: %s", err)
+	}
+
+	exporter := cometbft.NewExporter(validators)
+	exporter.BlockTime = blockTime
+
+	for _, signedVotesFile := range signedVotesFiles {
+		signedVotesPath, err := utils.GetProjectFilePath(signedVotesFile)
+		if err != nil {
+			return err
+		}
+
+		importer := cometbft.NewImporter(validators.IDs)
+		err = importer.ImportFile(signedVotesPath)
+		if err != nil {
+			return err
+		}
+		exporter.AddSignedVotes(importer.SignedVotes())
+	}
+
+	evidenceList, err := exporter.Export(monitor.Height, monitor.accAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	for _, equivocation := range exporter.Skipped {
+		log.Printf("Monitor: equivocation of %s in round %d not exported: the signed votes are missing", equivocation.ProcessID, equivocation.Round)
+	}
+
+	data, err := json.MarshalIndent(evidenceList, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while encoding evidence: %s", err)
+	}
+
+	f, err := utils.OpenFile(evidenceFile)
+	if err != nil {
+		return fmt.Errorf("error while opening evidence file: %s", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return fmt.Errorf("error while writing evidence: %s", err)
+	}
+
+	if debug {
+		log.Printf("Monitor: %d pieces of evidence written to %s", len(evidenceList), evidenceFile)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/mikanikos/Fork-Accountability/graph"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// ExportGraph writes the graph of the votes analyzed in the last run of the algorithm, with the faulty processes highlighted, in DOT format and as a self-contained HTML page with an SVG rendering
+// a file is not written if its path is empty, received edges are added to the DOT graph only if showReceived is true
+func (monitor *Monitor) ExportGraph(dotFile string, htmlFile string, showReceived bool) error {
+	g := graph.New(monitor.accAlgorithm, monitor.Height, monitor.FirstDecisionRound, monitor.SecondDecisionRound, showReceived)
+
+	if dotFile != "" {
+		err := writeGraph(dotFile, g.WriteDOT)
+		if err != nil {
+			return err
+		}
+
+		if debug {
+			log.Printf("Monitor: graph of the votes written to %s", dotFile)
+		}
+	}
+
+	if htmlFile != "" {
+		err := writeGraph(htmlFile, g.WriteHTML)
+		if err != nil {
+			return err
+		}
+
+		if debug {
+			log.Printf("Monitor: rendering of the graph of the votes written to %s", htmlFile)
+		}
+	}
+
+	return nil
+}
+
+// write a graph to a file with the given function
+func writeGraph(graphFile string, write func(io.Writer) error) error {
+	f, err := utils.OpenFile(graphFile)
+	if err != nil {
+		return fmt.Errorf("error while opening graph file: %s", err)
+	}
+	defer f.Close()
+
+	return write(f)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/graph"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// headers of the columns of the vote matrix
+var voteMatrixColumns = []string{"PREVOTE sent", "PRECOMMIT sent", "PREVOTE received", "PRECOMMIT received"}
+
+// single-file report, with styles inline and no scripts or external assets
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 20px 40px; color: #333333; }
+h1 { font-size: 22px; }
+h2 { font-size: 18px; margin-top: 32px; border-bottom: 1px solid #dddddd; padding-bottom: 4px; }
+h3 { font-size: 15px; }
+table { border-collapse: collapse; margin-bottom: 16px; }
+th, td { border: 1px solid #dddddd; padding: 4px 10px; text-align: left; vertical-align: top; }
+th { background: #f5f5f5; }
+.status { padding: 8px 12px; border-radius: 4px; display: inline-block; }
+.completed { background: #e5f5e0; }
+.failed { background: #fee0d2; }
+.faulty { color: #de2d26; font-weight: bold; }
+.inferred { font-style: italic; color: #777777; }
+.chip { display: inline-block; padding: 1px 6px; margin: 1px; border-radius: 3px; border: 1px solid #bbbbbb; font-size: 12px; }
+details { margin: 6px 0; }
+summary { cursor: pointer; }
+pre { background: #f7f7f7; padding: 8px; overflow-x: auto; font-size: 12px; }
+.explanation { color: #555555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated on {{.Generated}}</p>
+
+<h2>Summary</h2>
+<p class="status {{if .Completed}}completed{{else}}failed{{end}}">{{.Status}}</p>
+<table>
+{{range .Summary}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{if .Missing}}<h3>Validators without message logs</h3>
+<table>
+<tr><th>Validator</th><th>Reason</th></tr>
+{{range .Missing}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Votes</h2>
+<p>Values: {{range .Legend}}<span class="chip" style="background: {{.Colour}}">{{.Text}}</span> {{end}}</p>
+<p>Messages received are shown as value &times; number of messages. Faulty processes are in red, processes whose message logs have not been received (votes inferred from the messages received by the other processes) in italics.</p>
+{{range .Rounds}}<h3>{{.Label}}</h3>
+<table>
+<tr><th>Process</th>{{range $.Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td class="{{if .Faulty}}faulty{{end}}{{if .Inferred}} inferred{{end}}">{{.ProcessID}}</td>{{range .Cells}}<td>{{range .}}<span class="chip" style="background: {{.Colour}}">{{.Text}}</span>{{end}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+<h2>Faulty processes</h2>
+{{if not .Faulty}}<p>No faulty processes found.</p>
+{{end}}{{range .Faulty}}<details>
+<summary><span class="faulty">Process {{.ProcessID}}</span>: {{.Description}}</summary>
+{{range .General}}<p><b>{{.Reason}}</b></p>
+<p class="explanation">{{.Explanation}}</p>
+{{end}}{{range .Rounds}}<h3>Round {{.Round}}</h3>
+{{range .Reasons}}<p><b>{{.Reason}}</b></p>
+<p class="explanation">{{.Explanation}}</p>
+{{end}}<p>Messages sent in the round:</p>
+<pre>{{.Sent}}</pre>
+<p>PREVOTE messages received in the round ({{$.Quorum}} for the same value needed to justify a vote): {{range .Received}}<span class="chip" style="background: {{.Colour}}">{{.Text}}</span> {{else}}none{{end}}</p>
+{{end}}</details>
+{{end}}
+<h2>Message logs received</h2>
+{{range .Logs}}<details>
+<summary>Validator {{.Name}}</summary>
+<pre>{{.Value}}</pre>
+</details>
+{{else}}<p>No message logs received.</p>
+{{end}}</body>
+</html>
+`))
+
+// data of the report
+type htmlReportPage struct {
+	Title     string
+	Generated string
+	Status    string
+	Completed bool
+	Quorum    uint64
+	Summary   []*reportField
+	Missing   []*reportField
+	Legend    []*reportChip
+	Columns   []string
+	Rounds    []*reportRound
+	Faulty    []*reportFaulty
+	Logs      []*reportField
+}
+
+// named value in the report
+type reportField struct {
+	Name  string
+	Value string
+}
+
+// value coloured in the report
+type reportChip struct {
+	Text   string
+	Colour string
+}
+
+// vote matrix of a round
+type reportRound struct {
+	Label string
+	Rows  []*reportRow
+}
+
+// votes of a process in a round, a cell for each column of the vote matrix
+type reportRow struct {
+	ProcessID string
+	Faulty    bool
+	Inferred  bool
+	Cells     [][]*reportChip
+}
+
+// faults of a process, with the evidence in each round
+type reportFaulty struct {
+	ProcessID   string
+	Description string
+	General     []*reportReason
+	Rounds      []*reportFault
+}
+
+// reason why a process is faulty, with its explanation
+type reportReason struct {
+	Reason      string
+	Explanation string
+}
+
+// faults of a process in a round, with the messages sent and received
+type reportFault struct {
+	Round    uint64
+	Reasons  []*reportReason
+	Sent     string
+	Received []*reportChip
+}
+
+// WriteHTMLReport writes a single-file HTML report of the last run of the algorithm, to be read offline: a summary of the execution, the votes of each round, the faulty processes with the evidence and the message logs received
+func (monitor *Monitor) WriteHTMLReport(reportFile string) error {
+	acc := monitor.accAlgorithm
+	g := graph.New(acc, monitor.Height, monitor.FirstDecisionRound, monitor.SecondDecisionRound, false)
+	logs := acc.GetMessageLogs()
+
+	page := &htmlReportPage{
+		Title:     fmt.Sprintf("Accountability report for height %d", monitor.Height),
+		Generated: time.Now().Format(time.RFC1123),
+		Status:    strings.TrimPrefix(monitor.status, "Monitor: "),
+		Completed: acc.IsCompleted(),
+		Quorum:    acc.GetQuorumThreshold(),
+		Columns:   voteMatrixColumns,
+	}
+
+	if page.Status == "" {
+		page.Status = "Algorithm not run"
+	}
+
+	page.Summary = monitor.reportSummary()
+	page.Missing = monitor.reportMissing()
+
+	for _, value := range append(append([]*common.Value{}, g.Values...), nil) {
+		page.Legend = append(page.Legend, &reportChip{Text: value.String(), Colour: g.Colour(value)})
+	}
+
+	// vote matrix of each round
+	for _, round := range g.Rounds {
+		reportRound := &reportRound{Label: g.RoundLabel(round)}
+
+		for _, processID := range g.Processes {
+			row := &reportRow{ProcessID: processID, Faulty: g.IsFaulty(processID), Inferred: !g.Received[processID], Cells: make([][]*reportChip, len(voteMatrixColumns))}
+
+			if hvs := logs[processID]; hvs != nil {
+				if vs := hvs.VoteSetMap[round]; vs != nil {
+					row.Cells[0] = sentChips(g, vs.SentPrevoteMessages)
+					row.Cells[1] = sentChips(g, vs.SentPrecommitMessages)
+					row.Cells[2] = receivedChips(g, vs.ReceivedPrevoteMessages)
+					row.Cells[3] = receivedChips(g, vs.ReceivedPrecommitMessages)
+				}
+			}
+
+			reportRound.Rows = append(reportRound.Rows, row)
+		}
+
+		page.Rounds = append(page.Rounds, reportRound)
+	}
+
+	// faulty processes with the evidence
+	for _, processID := range acc.GetFaultyProcesses() {
+		faults := acc.GetFaults(processID)
+		faulty := &reportFaulty{ProcessID: processID}
+
+		rounds := make([]uint64, 0, len(faults))
+		for round := range faults {
+			rounds = append(rounds, round)
+		}
+		sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+
+		faultyRounds := make([]string, 0, len(rounds))
+		for _, round := range rounds {
+			reasons := make([]*reportReason, 0, len(faults[round]))
+			for _, faultiness := range faults[round] {
+				reason := &reportReason{Reason: faultiness.FaultinessReason(), Explanation: faultiness.Explanation()}
+
+				// faults not specific to a round
+				if !faultiness.IsRoundSpecific() {
+					faulty.General = append(faulty.General, reason)
+					continue
+				}
+				reasons = append(reasons, reason)
+			}
+
+			if len(reasons) == 0 {
+				continue
+			}
+
+			fault := &reportFault{Round: round, Reasons: reasons, Sent: "none", Received: make([]*reportChip, 0)}
+			if hvs := logs[processID]; hvs != nil {
+				if vs := hvs.VoteSetMap[round]; vs != nil {
+					fault.Sent = messagesToString(append(append([]*common.Message{}, vs.SentPrevoteMessages...), vs.SentPrecommitMessages...))
+					fault.Received = receivedChips(g, vs.ReceivedPrevoteMessages)
+				}
+			}
+
+			faulty.Rounds = append(faulty.Rounds, fault)
+			faultyRounds = append(faultyRounds, strconv.FormatUint(round, 10))
+		}
+
+		numFaults := len(faulty.General)
+		for _, fault := range faulty.Rounds {
+			numFaults += len(fault.Reasons)
+		}
+
+		faulty.Description = fmt.Sprintf("%d faults", numFaults)
+		if len(faultyRounds) > 0 {
+			faulty.Description += " in rounds " + strings.Join(faultyRounds, ", ")
+		}
+
+		page.Faulty = append(page.Faulty, faulty)
+	}
+
+	// message logs as received, before the messages inferred are added
+	ids := make([]string, 0, len(monitor.receivedLogs))
+	for id := range monitor.receivedLogs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		data, err := common.EncodeHeightVoteSet(monitor.receivedLogs[id], common.YAML)
+		if err != nil {
+			return fmt.Errorf("error while encoding message logs of %s: %s", id, err)
+		}
+		page.Logs = append(page.Logs, &reportField{Name: id, Value: string(data)})
+	}
+
+	f, err := utils.OpenFile(reportFile)
+	if err != nil {
+		return fmt.Errorf("error while opening HTML report file: %s", err)
+	}
+	defer f.Close()
+
+	err = htmlReportTemplate.Execute(f, page)
+	if err != nil {
+		return fmt.Errorf("error while writing HTML report: %s", err)
+	}
+
+	if debug {
+		log.Printf("Monitor: HTML report written to %s", reportFile)
+	}
+
+	return nil
+}
+
+// summary of the execution
+func (monitor *Monitor) reportSummary() []*reportField {
+	acc := monitor.accAlgorithm
+
+	mode := "synchronous"
+	if monitor.asyncMode {
+		mode = "asynchronous"
+	}
+
+	summary := []*reportField{
+		{Name: "Height", Value: strconv.FormatUint(monitor.Height, 10)},
+		{Name: "Decision rounds", Value: fmt.Sprintf("%d and %d", monitor.FirstDecisionRound, monitor.SecondDecisionRound)},
+		{Name: "Mode", Value: mode},
+		{Name: "Validators", Value: strconv.Itoa(len(monitor.Validators))},
+		{Name: "Message logs received", Value: fmt.Sprintf("%d (at least %d needed to run the algorithm)", acc.GetNumLogs(), acc.GetValidityThreshold())},
+		{Name: "Faulty processes found", Value: fmt.Sprintf("%d (at least %d needed to complete the algorithm)", acc.GetNumFaulty(), acc.GetValidityThreshold())},
+		{Name: "Quorum", Value: strconv.FormatUint(acc.GetQuorumThreshold(), 10)},
+		{Name: "Preprocess phase", Value: acc.GetPreprocessDuration().String()},
+		{Name: "Fault detection phase", Value: acc.GetFaultDetectionDuration().String()},
+	}
+
+	if !monitor.started.IsZero() && !monitor.finished.IsZero() {
+		summary = append(summary,
+			&reportField{Name: "Started", Value: monitor.started.Format(time.RFC1123)},
+			&reportField{Name: "Total time", Value: monitor.finished.Sub(monitor.started).String()},
+		)
+	}
+
+	return summary
+}
+
+// validators whose message logs have not been received, with the reason given by the validator, if any
+func (monitor *Monitor) reportMissing() []*reportField {
+	missing := make([]*reportField, 0)
+
+	for _, validator := range monitor.Validators {
+		if monitor.collected[validator] || monitor.receivedLogs[validator] != nil {
+			continue
+		}
+
+		reason, loaded := monitor.declaredMissing[validator]
+		if !loaded {
+			reason = "no valid message logs received"
+		}
+		missing = append(missing, &reportField{Name: validator, Value: reason})
+	}
+
+	return missing
+}
+
+// values of the messages sent, coloured
+func sentChips(g *graph.Graph, messages []*common.Message) []*reportChip {
+	chips := make([]*reportChip, 0, len(messages))
+	for _, mes := range messages {
+		chips = append(chips, &reportChip{Text: mes.Value.String(), Colour: g.Colour(mes.Value)})
+	}
+	return chips
+}
+
+// number of messages received for each value, coloured, in the order of the values (nil last)
+func receivedChips(g *graph.Graph, messages []*common.Message) []*reportChip {
+	counts := make(map[string]int)
+	values := make([]*common.Value, 0)
+
+	for _, mes := range messages {
+		key := mes.Value.String()
+		if _, loaded := counts[key]; !loaded {
+			values = append(values, mes.Value)
+		}
+		counts[key]++
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i] == nil || values[j] == nil {
+			return values[j] == nil && values[i] != nil
+		}
+		return values[i].Data < values[j].Data
+	})
+
+	chips := make([]*reportChip, 0, len(values))
+	for _, value := range values {
+		chips = append(chips, &reportChip{Text: fmt.Sprintf("%s × %d", value.String(), counts[value.String()]), Colour: g.Colour(value)})
+	}
+	return chips
+}
+
+// messages, one per line with their justifications
+func messagesToString(messages []*common.Message) string {
+	if len(messages) == 0 {
+		return "none"
+	}
+
+	var sb strings.Builder
+	for _, mes := range messages {
+		sb.WriteString(mes.String())
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -3,18 +3,29 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/mikanikos/Fork-Accountability/metrics"
 	"github.com/mikanikos/Fork-Accountability/utils"
 )
 
 func main() {
 
+	// run the offline analysis from message logs stored in files, if requested
+	if len(os.Args) > 1 && os.Args[1] == analyzeCommand {
+		analyze(os.Args[2:])
+		return
+	}
+
 	// parse arguments
 	configFile := flag.String("config", configPath, "path (relative to the project root directory) of the configuration file for the monitor")
 	report := flag.String("report", "", "path (relative to the project root directory) of the report to generate at the end of the execution instead of printing logs to standard output")
 	asyncMode := flag.Bool("asyncMode", true, "run the accountability algorithm asynchronously")
 	delay := flag.Uint64("delay", 0, "time to wait (in seconds) before start running, use for testing")
+	metricsAddress := flag.String("metrics", "", "address where to expose the /metrics endpoint with the statistics of the execution, disabled if empty")
+	htmlReport := flag.String("htmlReport", "", "path (relative to the project root directory) of the single-file HTML report to generate at the end of the execution, disabled if empty")
 
 	// parse arguments
 	flag.Parse()
@@ -25,10 +36,113 @@ func main() {
 		log.Fatalf("Monitor exiting: config file not parsed correctly: %s", err)
 	}
 
+	// expose statistics, if desired
+	if *metricsAddress != "" {
+		go func() {
+			err := metrics.DefaultRegistry.Serve(*metricsAddress)
+			if err != nil {
+				log.Printf("Monitor: metrics endpoint stopped: %s", err)
+			}
+		}()
+	}
+
 	time.Sleep(time.Duration(*delay) * time.Second)
 
+	// write logs to the report until the exports have finished too
+	closeReport := logToReport(*report)
+	defer closeReport()
+
 	// start monitor execution
-	monitor.Run(*report, *asyncMode)
+	monitor.Run("", *asyncMode)
+
+	// write HTML report, if desired
+	if *htmlReport != "" {
+		err := monitor.WriteHTMLReport(*htmlReport)
+		if err != nil {
+			log.Fatalf("Monitor exiting: error while writing HTML report: %s", err)
+		}
+	}
+}
+
+// run the accountability algorithm on message logs stored in files
+func analyze(args []string) {
+
+	// parse arguments
+	analyzeFlags := flag.NewFlagSet(analyzeCommand, flag.ExitOnError)
+	logsDirectory := analyzeFlags.String("logs", logsPath, "path (relative to the project root directory) of the directory containing the message logs of each validator, one file per validator named after its id")
+	metadataFile := analyzeFlags.String("metadata", metadataPath, "path (relative to the project root directory) of the metadata file describing the validator set and the fork")
+	report := analyzeFlags.String("report", "", "path (relative to the project root directory) of the report to generate at the end of the execution instead of printing logs to standard output")
+	asyncMode := analyzeFlags.Bool("asyncMode", true, "run the accountability algorithm asynchronously")
+	evidenceFile := analyzeFlags.String("evidence", "", "path (relative to the project root directory) of the file where the CometBFT evidence of the faults detected is written, disabled if empty")
+	validatorsFile := analyzeFlags.String("validators", "", "path (relative to the project root directory) of the file describing the CometBFT validator set, required to write evidence")
+	signedVotes := analyzeFlags.String("signedVotes", "", "comma-separated paths (relative to the project root directory) of the consensus WAL files or JSON dumps with the signed votes to put in the evidence, optional")
+	blockTime := analyzeFlags.String("blockTime", "", "time (RFC 3339) of the block at the height of the fork, used as timestamp of the evidence and required to write evidence")
+	dotFile := analyzeFlags.String("dot", "", "path (relative to the project root directory) of the file where the graph of the votes is written in DOT format, disabled if empty")
+	htmlFile := analyzeFlags.String("graph", "", "path (relative to the project root directory) of the HTML file where the graph of the votes is rendered, disabled if empty")
+	showReceived := analyzeFlags.Bool("received", true, "add an edge for the messages received from each process to the graph in DOT format")
+	htmlReport := analyzeFlags.String("htmlReport", "", "path (relative to the project root directory) of the single-file HTML report to generate at the end of the execution, disabled if empty")
+
+	// parse arguments
+	_ = analyzeFlags.Parse(args)
+
+	if *evidenceFile != "" && *validatorsFile == "" {
+		log.Fatalf("Monitor exiting: a validator set is required to write evidence")
+	}
+
+	evidenceTime := time.Time{}
+	if *evidenceFile != "" {
+		if *blockTime == "" {
+			log.Fatalf("Monitor exiting: the time of the block at the height of the fork is required to write evidence")
+		}
+
+		var err error
+		evidenceTime, err = time.Parse(time.RFC3339Nano, *blockTime)
+		if err != nil {
+			log.Fatalf("Monitor exiting: block time not parsed correctly: %s", err)
+		}
+	}
+
+	// parse file
+	monitor, err := newMonitorFromMetadata(*metadataFile)
+	if err != nil {
+		log.Fatalf("Monitor exiting: metadata file not parsed correctly: %s", err)
+	}
+
+	// write logs to the report until the exports have finished too
+	closeReport := logToReport(*report)
+	defer closeReport()
+
+	// start offline analysis
+	monitor.Analyze(*logsDirectory, "", *asyncMode)
+
+	// write evidence, if desired
+	if *evidenceFile != "" {
+		signedVotesFiles := make([]string, 0)
+		if *signedVotes != "" {
+			signedVotesFiles = strings.Split(*signedVotes, ",")
+		}
+
+		err := monitor.ExportEvidence(*evidenceFile, *validatorsFile, signedVotesFiles, evidenceTime)
+		if err != nil {
+			log.Fatalf("Monitor exiting: error while exporting evidence: %s", err)
+		}
+	}
+
+	// write graph of the votes, if desired
+	if *dotFile != "" || *htmlFile != "" {
+		err := monitor.ExportGraph(*dotFile, *htmlFile, *showReceived)
+		if err != nil {
+			log.Fatalf("Monitor exiting: error while exporting graph: %s", err)
+		}
+	}
+
+	// write HTML report, if desired
+	if *htmlReport != "" {
+		err := monitor.WriteHTMLReport(*htmlReport)
+		if err != nil {
+			log.Fatalf("Monitor exiting: error while writing HTML report: %s", err)
+		}
+	}
 }
 
 // create a new monitor from config file
@@ -0,0 +1,20 @@
+package main
+
+import "github.com/mikanikos/Fork-Accountability/metrics"
+
+// monitor statistics, exposed on the metrics endpoint if enabled
+var (
+	validatorsContacted     = metrics.NewCounter("monitor_validators_contacted_total", "Number of validators the monitor connected to and requested message logs from")
+	logsReceived            = metrics.NewCounter("monitor_logs_received_total", "Number of valid message logs received from validators")
+	logsInvalid             = metrics.NewCounter("monitor_logs_invalid_total", "Number of invalid or duplicated message logs received from validators")
+	logsMissing             = metrics.NewCounter("monitor_logs_missing_total", "Number of validators that did not send their message logs")
+	logsDeclaredMissing     = metrics.NewCounter("monitor_logs_declared_missing_total", "Number of validators that answered they can't send their message logs")
+	impersonationAttempts   = metrics.NewCounter("monitor_impersonation_attempts_total", "Number of responses rejected because the validator answered with the id or the signature of another validator")
+	requestRetries          = metrics.NewCounter("monitor_request_retries_total", "Number of requests of message logs repeated to validators")
+	reconnections           = metrics.NewCounter("monitor_reconnections_total", "Number of connections established again with validators after a failure")
+	rangeWidenings          = metrics.NewCounter("monitor_range_widenings_total", "Number of requests repeated with a wider round range to get the rounds needed to check justifications")
+	algorithmRuns           = metrics.NewCounter("monitor_algorithm_runs_total", "Number of executions of the accountability algorithm")
+	preprocessDuration      = metrics.NewSummary("monitor_preprocess_duration_seconds", "Time spent in the preprocess phase of the accountability algorithm")
+	faultDetectionDuration  = metrics.NewSummary("monitor_fault_detection_duration_seconds", "Time spent in the fault detection phase of the accountability algorithm")
+	faultyProcessesDetected = metrics.NewGauge("monitor_faulty_processes_detected", "Number of faulty processes detected in the last execution of the accountability algorithm")
+)
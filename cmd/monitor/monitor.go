@@ -1,14 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"time"
 
 	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/common"
 	"github.com/mikanikos/Fork-Accountability/connection"
 	"github.com/mikanikos/Fork-Accountability/utils"
+	"github.com/mikanikos/Fork-Accountability/wal"
 )
 
 // Monitor struct
@@ -18,19 +22,90 @@ type Monitor struct {
 	SecondDecisionRound uint64   `yaml:"secondDecisionRound"`
 	Timeout             uint64   `yaml:"timeout"`
 	Validators          []string `yaml:"validators"`
+	Wal                 string   `yaml:"wal"`
 
-	// receive channel for incoming packets
-	receiveChannel chan *connection.Packet
+	// maximum size (in bytes) of the frames exchanged with validators, default value used if 0
+	MaxFrameSize uint32 `yaml:"maxFrameSize"`
+	// maximum size (in bytes) of the message logs received from a validator, 16 frames if 0
+	MaxPacketSize uint64 `yaml:"maxPacketSize"`
+
+	// certificates used to connect to the validators with mutual TLS, plain TCP if not given
+	TLS *connection.TLSConfig `yaml:"tls"`
+
+	// transport used to connect to the validators (tcp, unix or memory), tcp if not given
+	Transport string `yaml:"transport"`
+	// scenario file (relative to the project root directory) with the network faults injected in the connections, for testing
+	Faults string `yaml:"faults"`
+
+	// retry policy used for all validators, unless specified in the validator settings
+	Retry             *RetryPolicy                  `yaml:"retry"`
+	ValidatorSettings map[string]*ValidatorSettings `yaml:"validatorSettings"`
+
+	// receive channel for incoming responses
+	receiveChannel chan *validatorResponse
 	// accountability structure
 	accAlgorithm *accountability.Accountability
+	// attempts made to get message logs from each validator
+	attemptHistory *AttemptHistory
+	// closed when the monitor stops waiting for message logs
+	done chan struct{}
+	// write-ahead log of the valid responses received
+	wal *wal.Log
+	// addresses of the validators whose message logs have been stored
+	collected map[string]bool
+	// address bound to each validator id given in the validator settings
+	boundAddresses map[string]string
+	// loaded TLS configuration
+	tlsConfig *tls.Config
+	// transport selected in the config
+	transport connection.Transport
+	// reasons given by the validators that declared their message logs missing, indexed by address
+	declaredMissing map[string]string
+	// copy of the message logs stored, indexed by validator id, before the algorithm adds the messages inferred
+	receivedLogs map[string]*common.HeightVoteSet
+	// version of the algorithm, start and end of the execution and final status, used in the reports
+	asyncMode bool
+	started   time.Time
+	finished  time.Time
+	status    string
+}
+
+// validatorResponse is the packet received from the validator at the given address
+type validatorResponse struct {
+	Address string
+	Packet  *connection.Packet
 }
 
 // NewMonitor creates a new monitor
 func NewMonitor() *Monitor {
 	return &Monitor{
-		Validators:     make([]string, 0),
-		receiveChannel: make(chan *connection.Packet, maxChannelSize),
-		accAlgorithm:   accountability.NewAccountability(),
+		Validators:      make([]string, 0),
+		receiveChannel:  make(chan *validatorResponse, maxChannelSize),
+		accAlgorithm:    accountability.NewAccountability(),
+		collected:       make(map[string]bool),
+		declaredMissing: make(map[string]string),
+		receivedLogs:    make(map[string]*common.HeightVoteSet),
+		attemptHistory:  NewAttemptHistory(),
+		done:            make(chan struct{}),
+	}
+}
+
+// write the logs to the report file, if given, until the function returned is called
+// the logs are written to standard error again after that, so that nothing is written to a closed file
+func logToReport(report string) func() {
+	if report == "" {
+		return func() {}
+	}
+
+	f, err := utils.OpenFile(report)
+	if err != nil {
+		log.Fatalf("Monitor exiting: error opening report file: %s", err)
+	}
+	log.SetOutput(f)
+
+	return func() {
+		log.SetOutput(os.Stderr)
+		_ = f.Close()
 	}
 }
 
@@ -38,33 +113,65 @@ func NewMonitor() *Monitor {
 func (monitor *Monitor) Run(report string, asyncMode bool) {
 
 	// write logs to file, if desired
-	if report != "" {
-		f, err := utils.OpenFile(report)
+	defer logToReport(report)()
+
+	monitor.started = time.Now()
+	monitor.asyncMode = asyncMode
+
+	if debug {
+		log.Println("Monitor: started running")
+	}
+
+	// check validator settings
+	err := monitor.loadValidatorSettings()
+	if err != nil {
+		log.Fatalf("Monitor exiting: invalid validator settings: %s", err)
+	}
+
+	// load certificates, if given
+	if monitor.TLS != nil {
+		monitor.tlsConfig, err = monitor.TLS.ClientConfig()
 		if err != nil {
-			log.Fatalf("Monitor exiting: error opening report file: %s", err)
+			log.Fatalf("Monitor exiting: invalid tls config: %s", err)
 		}
-		defer f.Close()
-		log.SetOutput(f)
 	}
 
-	if debug {
-		log.Println("Monitor: started running")
+	// restore message logs received in a previous execution, if any
+	if monitor.Wal != "" {
+		err := monitor.restoreFromWal()
+		if err != nil {
+			log.Fatalf("Monitor exiting: error restoring message logs from write-ahead log: %s", err)
+		}
+		defer monitor.wal.Close()
 	}
 
 	// connect to validators and make request for hvs
-	err := monitor.connectToValidators()
+	err = monitor.connectToValidators()
 	if err != nil {
-		log.Fatalf("Monitor exiting: couldn't connect to all validators: %s", err)
+		log.Fatalf("Monitor exiting: couldn't connect to validators: %s", err)
 	}
 
 	if debug {
-		log.Println("Monitor: successfully connected to all validators and requested message logs")
+		log.Println("Monitor: started requesting message logs from validators")
 	}
 
 	// run accountability algorithm
 	output := monitor.runMonitorAlgorithm(asyncMode)
+	monitor.finished = time.Now()
+	monitor.status = output
+
+	// stop requesting message logs from validators that haven't answered yet
+	close(monitor.done)
 
 	if debug {
+		log.Println(monitor.attemptHistory.String(monitor.Validators))
+
+		for _, address := range monitor.Validators {
+			if reason, loaded := monitor.declaredMissing[address]; loaded {
+				log.Printf("Monitor: validator at %s declared its message logs missing: %s", address, reason)
+			}
+		}
+
 		log.Println(output)
 	}
 }
@@ -74,11 +181,23 @@ func (monitor *Monitor) runMonitorAlgorithm(async bool) string {
 
 	// count the number of responses (regardless of validity) from different validators
 	numValidators := len(monitor.Validators)
-	responseCount := 0
+	responseCount := len(monitor.collected)
 
 	// initialize accountability
 	monitor.accAlgorithm.Init(uint64(numValidators), async)
 
+	// check if the message logs restored are already enough
+	if responseCount == numValidators {
+		return monitor.runFinalAccountabilityAlgorithm()
+	}
+
+	if async && monitor.accAlgorithm.CanRun() {
+		monitor.runAccountabilityAlgorithm()
+		if monitor.accAlgorithm.IsCompleted() {
+			return successfulStatus
+		}
+	}
+
 	// wait until the specified timer expires
 	timer := time.NewTicker(time.Duration(monitor.Timeout) * time.Second)
 	defer timer.Stop()
@@ -95,10 +214,14 @@ loop:
 				break loop
 			}
 
-		case packet := <-monitor.receiveChannel:
+		case response := <-monitor.receiveChannel:
+
+			packet := response.Packet
 
 			// check if new packet has been received and store it in case
-			if monitor.checkResponseValidity(packet) && monitor.accAlgorithm.StoreHvs(packet.ID, packet.Hvs) {
+			if monitor.storeResponse(response) {
+				logsReceived.Inc()
+
 				if debug {
 					log.Printf("Monitor: received height vote set from validator with ID %s. %d message logs have been delivered so far\n", packet.ID, monitor.accAlgorithm.GetNumLogs())
 				}
@@ -118,7 +241,19 @@ loop:
 					}
 				}
 
+			} else if packet.Code == connection.HvsMissing {
+				logsMissing.Inc()
+			} else if packet.Code == connection.Error {
+				logsMissing.Inc()
+				logsDeclaredMissing.Inc()
+				monitor.declaredMissing[response.Address] = packet.ErrorString()
+
+				if debug {
+					log.Printf("Monitor: validator with ID %s declared its message logs missing: %s\n", packet.ID, packet.ErrorString())
+				}
 			} else {
+				logsInvalid.Inc()
+
 				if debug {
 					log.Printf("Monitor: received invalid packet from validator with ID %s\n", packet.ID)
 				}
@@ -126,6 +261,13 @@ loop:
 
 			// increment the number of responses from validators
 			responseCount++
+
+			// fail because not enough hvs can be delivered anymore to run the algorithm
+			missingCount := responseCount - len(monitor.collected)
+			if uint64(numValidators-missingCount) < monitor.accAlgorithm.GetValidityThreshold() {
+				return failStatus
+			}
+
 			if responseCount == numValidators {
 				if async {
 					// fail because no new hvs will arrive and the success condition was not met
@@ -138,6 +280,12 @@ loop:
 		}
 	}
 
+	return monitor.runFinalAccountabilityAlgorithm()
+}
+
+// run the accountability algorithm for the last time and return the final status
+func (monitor *Monitor) runFinalAccountabilityAlgorithm() string {
+
 	// run algorithm
 	monitor.runAccountabilityAlgorithm()
 
@@ -149,6 +297,51 @@ loop:
 	return failStatus
 }
 
+// store the message logs received in the write-ahead log, if enabled, and in the accountability structure
+// return true if the message logs are valid and new, false otherwise
+func (monitor *Monitor) storeResponse(response *validatorResponse) bool {
+	packet := response.Packet
+
+	if monitor.collected[response.Address] || !monitor.checkResponseValidity(packet) {
+		return false
+	}
+
+	// message logs of the same validator already received from another address, one of them is pretending to be someone else
+	if _, loaded := monitor.receivedLogs[packet.ID]; loaded {
+		impersonationAttempts.Inc()
+
+		log.Printf("Monitor: impersonation attempt detected: validator at %s answered with id %s, whose message logs have already been received from another address", response.Address, packet.ID)
+
+		return false
+	}
+
+	// persist the response before processing it, the monitor can't go on if it can't be restored after a crash
+	if monitor.wal != nil {
+		err := monitor.appendToWal(response)
+		if err != nil {
+			log.Fatalf("Monitor exiting: error while writing message logs from %s to write-ahead log: %s", response.Address, err)
+		}
+	}
+
+	if !monitor.storeHvs(packet.ID, packet.Hvs) {
+		return false
+	}
+
+	monitor.collected[response.Address] = true
+	return true
+}
+
+// store the message logs of a validator in the accountability structure, keeping a copy for the reports
+// return true if the message logs are new, false otherwise
+func (monitor *Monitor) storeHvs(id string, hvs *common.HeightVoteSet) bool {
+	if !monitor.accAlgorithm.StoreHvs(id, hvs) {
+		return false
+	}
+
+	monitor.receivedLogs[id] = hvs.Copy()
+	return true
+}
+
 // run accountability algorithm
 func (monitor *Monitor) runAccountabilityAlgorithm() {
 
@@ -159,77 +352,307 @@ func (monitor *Monitor) runAccountabilityAlgorithm() {
 	start := time.Now()
 
 	// run monitor and get faulty processes
-	monitor.accAlgorithm.Run(monitor.FirstDecisionRound, monitor.SecondDecisionRound)
+	result := monitor.accAlgorithm.Run(monitor.FirstDecisionRound, monitor.SecondDecisionRound)
 
 	elapsedTime := time.Since(start)
 
+	// update statistics of the execution
+	algorithmRuns.Inc()
+	preprocessDuration.Observe(result.PreprocessDuration().Seconds())
+	faultDetectionDuration.Observe(result.FaultDetectionDuration().Seconds())
+	faultyProcessesDetected.Set(float64(len(result.FaultyProcesses())))
+
 	log.Println("Monitor: algorithm completed in " + elapsedTime.String())
 
 	if debug {
 		// print result of the execution
 		log.Println(monitor.accAlgorithm.String())
-		log.Printf("Monitor: detected %d faulty processes\n", monitor.accAlgorithm.GetNumFaulty())
+		log.Printf("Monitor: detected %d faulty processes\n", len(result.FaultyProcesses()))
 	}
 }
 
-// request message logs for a specific height to all validators, return error if it can't connect to some validator
+// request message logs for a specific height to all validators in the background, return error if no validators are given
+// validators that are not reachable are contacted again according to their retry policy
 func (monitor *Monitor) connectToValidators() error {
 
 	if monitor.Validators == nil || len(monitor.Validators) == 0 {
 		return fmt.Errorf("error: no validators given")
 	}
 
+	// use the transport given in the config, unless already set
+	if monitor.transport == nil {
+		transport, err := connection.GetTransport(monitor.Transport)
+		if err != nil {
+			return err
+		}
+
+		if monitor.Faults != "" {
+			transport, err = connection.LoadFaultScenario(monitor.Faults, transport)
+			if err != nil {
+				return err
+			}
+		}
+
+		monitor.transport = transport
+	}
+
 	// resolve validator addresses given and connect to them
 	for _, val := range monitor.Validators {
-		conn, err := connection.Connect(val)
-		if err != nil {
-			return fmt.Errorf("error while connecting to one of the validators given: %s", err)
+
+		// skip validators whose message logs have been restored
+		if monitor.collected[val] {
+			continue
 		}
 
-		// start goroutines to send message and wait for reply from each validator
-		go monitor.receiveHvsFromValidator(conn)
+		// start goroutines to connect, send message and wait for reply from each validator
+		go monitor.receiveHvsFromValidator(val)
 	}
 
 	return nil
 }
 
-// receive hvs from validator on a given connection
-func (monitor *Monitor) receiveHvsFromValidator(conn *connection.Connection) {
+// receive hvs from validator at the given address, repeating the request (and reconnecting if needed) according to the retry policy of the validator
+// connection attempts are repeated in the background until the validator is reachable, the deadline of the policy expires or the monitor stops
+func (monitor *Monitor) receiveHvsFromValidator(address string) {
 
-	// prepare packet to send
-	packetToSend := &connection.Packet{Code: connection.HvsRequest, Height: monitor.Height}
+	policy := monitor.getRetryPolicy(address)
+	deadline := time.Now().Add(time.Duration(policy.Deadline) * time.Second)
 
-	if debug {
-		log.Printf("Monitor: sending packet to %s", conn.Conn.RemoteAddr().String())
+	// notify that will not receive any hvs from the validator if all the attempts fail
+	response := &validatorResponse{Address: address, Packet: &connection.Packet{Code: connection.HvsMissing}}
+
+	// requests are multiplexed on the connection, so that stale responses to previous attempts are discarded
+	var mux *connection.Multiplexer
+	connected := false
+	requestsSent := uint64(0)
+
+	for attemptNumber := uint64(1); ; attemptNumber++ {
+
+		var packet *connection.Packet
+		var outcome string
+
+		// establish the connection, if needed
+		if mux == nil {
+			conn, err := connection.Dial(monitor.transport, address, monitor.tlsConfig)
+			if err != nil {
+				outcome = "connection failed: " + err.Error()
+
+				if debug {
+					log.Printf("Monitor: error while connecting to %s: %s", address, err)
+				}
+			} else if reason := monitor.checkConnectionFeatures(address, conn); reason != "" {
+				conn.Close()
+				outcome = "connection refused: " + reason
+
+				if debug {
+					log.Printf("Monitor: refused connection to %s: %s", address, reason)
+				}
+			} else {
+				conn.MaxFrameSize = monitor.MaxFrameSize
+				conn.MaxPacketSize = monitor.MaxPacketSize
+				mux = connection.NewMultiplexer(conn)
+
+				if connected {
+					reconnections.Inc()
+				} else {
+					connected = true
+					validatorsContacted.Inc()
+				}
+			}
+		}
+
+		if mux != nil {
+			// wait for the response until the attempt timeout or the overall deadline, whichever comes first
+			receiveDeadline := time.Now().Add(time.Duration(policy.AttemptTimeout) * time.Second)
+			if deadline.Before(receiveDeadline) {
+				receiveDeadline = deadline
+			}
+
+			requestsSent++
+			packet, outcome = monitor.requestHvs(address, mux, receiveDeadline)
+		}
+
+		monitor.attemptHistory.Add(address, attemptNumber, outcome)
+
+		if packet != nil {
+			response.Packet = packet
+			break
+		}
+
+		// close the connection in case of errors, it will be established again in the next attempt
+		if mux != nil && outcome != invalidResponseOutcome {
+			mux.Close()
+			mux = nil
+		}
+
+		// failed connection attempts don't count as requests, they are bounded by the deadline
+		backoff := policy.backoff(attemptNumber)
+		if requestsSent >= policy.MaxAttempts || time.Now().Add(backoff).After(deadline) {
+			if debug {
+				log.Printf("Monitor: giving up requesting message logs from %s after %d attempts", address, attemptNumber)
+			}
+			break
+		}
+
+		// wait before the next attempt, unless the monitor stopped in the meantime
+		select {
+		case <-monitor.done:
+			if mux != nil {
+				mux.Close()
+			}
+			return
+		case <-time.After(backoff):
+		}
+
+		requestRetries.Inc()
 	}
 
-	// sending packet to validator
-	err := conn.Send(packetToSend)
-	if err != nil && debug {
-		log.Printf("Monitor: error while sending request to %s: %s", conn.Conn.RemoteAddr().String(), err)
+	// close connection with validator
+	if mux != nil {
+		mux.Close()
 	}
 
-	// wait to receive packet from validator
-	packet, err := conn.Receive()
+	// send packet to main thread, unless it stopped waiting for it
+	select {
+	case monitor.receiveChannel <- response:
+	case <-monitor.done:
+	}
+}
+
+// request the hvs on a given connection and wait for a valid response
+// only the rounds analyzed by the algorithm are requested, the range is widened if the justifications refer to earlier rounds
+// return the packet received if valid, nil and the outcome of the attempt otherwise
+func (monitor *Monitor) requestHvs(address string, mux *connection.Multiplexer, deadline time.Time) (*connection.Packet, string) {
+
+	filter := &connection.Filter{FromRound: monitor.FirstDecisionRound, ToRound: monitor.SecondDecisionRound}
+
+	var previous *connection.Packet
+
+	for {
+		packet, outcome := monitor.requestRounds(address, mux, deadline, filter)
+		if packet == nil {
+			return nil, outcome
+		}
+
+		if packet.Code == connection.Error {
+			// the earlier rounds are not available anymore, the justifications can't be checked with the response received before
+			if previous != nil && packet.Reason == connection.ReasonRoundsPruned {
+				return previous, receivedOutcome
+			}
+
+			// some of the rounds needed have been discarded, request the ones still stored
+			if filter != nil && packet.Reason == connection.ReasonRoundsPruned {
+				if debug {
+					log.Printf("Monitor: requesting all the message logs stored by %s, some of the rounds needed have been pruned", address)
+				}
+
+				filter = nil
+				continue
+			}
+			return packet, outcome
+		}
+
+		// the response is complete if it contains all the rounds stored, or if no earlier round is needed (or the validator doesn't have them)
+		if filter == nil {
+			return packet, outcome
+		}
+
+		missing := accountability.MissingJustificationRounds(packet.Hvs, monitor.FirstDecisionRound, monitor.SecondDecisionRound)
+		if len(missing) == 0 || missing[0] >= filter.FromRound {
+			return packet, outcome
+		}
+
+		previous = packet
+		rangeWidenings.Inc()
+
+		if debug {
+			log.Printf("Monitor: widening the request to %s from round %d to check justifications", address, missing[0])
+		}
+
+		// request the whole range again, so that the response is self-contained and its signature can be verified
+		filter = &connection.Filter{FromRound: missing[0], ToRound: filter.ToRound}
+	}
+}
+
+// request the given rounds on a given connection and wait for a valid response
+func (monitor *Monitor) requestRounds(address string, mux *connection.Multiplexer, deadline time.Time, filter *connection.Filter) (*connection.Packet, string) {
+
+	// prepare packet to send
+	packetToSend := &connection.Packet{Code: connection.HvsRequest, Height: monitor.Height, Filter: filter}
+
+	if debug {
+		log.Printf("Monitor: sending packet to %s", address)
+	}
+
+	// send the request and wait for the response to it
+	packet, err := mux.Request(packetToSend, deadline)
 	if err != nil {
 		// if connection is closed or there's an error, exit
+		if err == io.EOF {
+			if debug {
+				log.Printf("Monitor: connection has been closed by validator on address %s", address)
+			}
+			return nil, "connection closed by validator"
+		}
+
 		if debug {
-			if err == io.EOF {
-				log.Printf("Monitor: connection has been closed by validator on address %s", conn.Conn.RemoteAddr())
-			} else {
-				log.Printf("Monitor: error while trying to receive packet from %s: %s", conn.Conn.RemoteAddr(), err)
+			log.Printf("Monitor: error while requesting packet from %s: %s", address, err)
+		}
+		return nil, "response not received: " + err.Error()
+	}
+
+	// the response must refer to the rounds requested, signed responses to other requests can't be replayed
+	if !filter.Equal(packet.Filter) {
+		logsInvalid.Inc()
+
+		if debug {
+			log.Printf("Monitor: received response for other rounds from validator with ID %s\n", packet.ID)
+		}
+		return nil, invalidResponseOutcome
+	}
+
+	if packet.Code == connection.Error {
+		// the validator could not serve the request (e.g. because it's overloaded), try again later
+		if packet.Retryable() {
+			if debug {
+				log.Printf("Monitor: request refused by validator on address %s: %s", address, packet.ErrorString())
 			}
+			return nil, refusedOutcome + ": " + packet.ErrorString()
 		}
 
-		// notify that will not receive any hvs from the validator on this connection
-		packet = &connection.Packet{Code: connection.HvsMissing}
+		// the validator won't send its message logs, stop requesting them if it's really the validator declaring it
+		reason := monitor.checkSenderIdentity(address, packet)
+		if reason != "" {
+			impersonationAttempts.Inc()
+
+			log.Printf("Monitor: impersonation attempt detected: %s", reason)
+
+			return nil, impersonationOutcome + ": " + reason
+		}
+
+		return packet, declaredMissingOutcome + ": " + packet.ErrorString()
 	}
 
-	// send packet to main thread
-	monitor.receiveChannel <- packet
+	if !monitor.checkResponseValidity(packet) {
+		logsInvalid.Inc()
 
-	// close connection with validator
-	conn.Close()
+		if debug {
+			log.Printf("Monitor: received invalid packet from validator with ID %s\n", packet.ID)
+		}
+		return nil, invalidResponseOutcome
+	}
+
+	// reject responses from validators pretending to be someone else
+	reason := monitor.checkSenderIdentity(address, packet)
+	if reason != "" {
+		impersonationAttempts.Inc()
+
+		log.Printf("Monitor: impersonation attempt detected: %s", reason)
+
+		return nil, impersonationOutcome + ": " + reason
+	}
+
+	return packet, receivedOutcome
 }
 
 // check that the packet received is valid and contains correct information
@@ -2,35 +2,68 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/mikanikos/Fork-Accountability/cometbft"
 	"github.com/mikanikos/Fork-Accountability/common"
 	"github.com/mikanikos/Fork-Accountability/connection"
 	"github.com/mikanikos/Fork-Accountability/utils"
 )
 
+// validators and monitors in the tests are connected in-process, without ports
+var testTransport = connection.NewMemoryTransport()
+
+// counter used to give unique addresses to the validators
+var addressCounter uint64
+
 func createTestMonitor() *Monitor {
 	monitorTest := NewMonitor()
 	monitorTest.Height = 1
 	monitorTest.Timeout = 60
 	monitorTest.FirstDecisionRound = 3
 	monitorTest.SecondDecisionRound = 4
+	monitorTest.transport = testTransport
 
-	addresses, err := utils.GetFreeAddresses(4)
-	if err != nil {
-		return nil
+	for i := 0; i < 4; i++ {
+		monitorTest.Validators = append(monitorTest.Validators, newTestAddress())
 	}
 
-	monitorTest.Validators = append(monitorTest.Validators, addresses...)
 	return monitorTest
 }
 
+// get a new address on the in-memory transport
+func newTestAddress() string {
+	return "validator-" + strconv.FormatUint(atomic.AddUint64(&addressCounter, 1), 10)
+}
+
+// listen on the in-memory transport and serve the connections in the background
+// the validator is reachable as soon as the function returns
+func listenInMemory(server *connection.Server, address string) {
+	server.Transport = testTransport
+
+	listener, err := testTransport.Listen(address)
+	if err != nil {
+		log.Printf("Failed while start listening: %s", err)
+		return
+	}
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+}
+
 func TestMonitor_CorrectConfigParsing(t *testing.T) {
 
 	monitorTest := createTestMonitor()
@@ -42,9 +75,12 @@ func TestMonitor_CorrectConfigParsing(t *testing.T) {
 
 	monitorConfig.receiveChannel = nil
 	monitorConfig.accAlgorithm = nil
+	monitorConfig.done = nil
 
 	monitorTest.receiveChannel = nil
 	monitorTest.accAlgorithm = nil
+	monitorTest.done = nil
+	monitorTest.transport = nil
 
 	monitorTest.Validators = []string{"127.0.0.1:8080", "127.0.0.1:8081", "127.0.0.1:8082", "127.0.0.1:8083"}
 
@@ -80,22 +116,17 @@ func validatorMock(id string, address string, delay uint64, hvs *common.HeightVo
 		}
 	}()
 
-	err := server.Listen(address)
-	if err != nil {
-		log.Printf("Failed while start listening: %s", err)
-	}
+	listenInMemory(server, address)
 }
 
 func TestMonitor_ConnectToValidatorsSuccessfully(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 0, common.NewHeightVoteSet())
-	go validatorMock("2", testMonitor.Validators[1], 0, common.NewHeightVoteSet())
-	go validatorMock("3", testMonitor.Validators[2], 0, common.NewHeightVoteSet())
-	go validatorMock("4", testMonitor.Validators[3], 0, common.NewHeightVoteSet())
-
-	time.Sleep(time.Second * time.Duration(1))
+	validatorMock("1", testMonitor.Validators[0], 0, common.NewHeightVoteSet())
+	validatorMock("2", testMonitor.Validators[1], 0, common.NewHeightVoteSet())
+	validatorMock("3", testMonitor.Validators[2], 0, common.NewHeightVoteSet())
+	validatorMock("4", testMonitor.Validators[3], 0, common.NewHeightVoteSet())
 
 	err := testMonitor.connectToValidators()
 
@@ -106,8 +137,6 @@ func TestMonitor_ConnectToValidatorsSuccessfully(t *testing.T) {
 
 func TestMonitor_ConnectToValidatorsNoValidatorsGiven(t *testing.T) {
 
-	time.Sleep(time.Second * time.Duration(1))
-
 	testMonitor := createTestMonitor()
 	testMonitor.Validators = nil
 
@@ -118,19 +147,74 @@ func TestMonitor_ConnectToValidatorsNoValidatorsGiven(t *testing.T) {
 	}
 }
 
-func TestMonitor_ConnectToValidators_Fail(t *testing.T) {
+func TestMonitor_ConnectToValidatorsWithUnreachableValidators(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 0, common.NewHeightVoteSet())
-	go validatorMock("3", testMonitor.Validators[3], 0, common.NewHeightVoteSet())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 0, common.NewHeightVoteSet())
+	validatorMock("3", testMonitor.Validators[3], 0, common.NewHeightVoteSet())
 
 	err := testMonitor.connectToValidators()
+	close(testMonitor.done)
 
-	if err == nil {
-		t.Fatal("Should have failed because one or more validators are not listening")
+	if err != nil {
+		t.Fatal("Should not have failed because some validators are not listening")
+	}
+}
+
+func TestMonitor_RunWithUnreachableValidators_SyncVersion(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Timeout = 3
+
+	// validators 3 and 4 are not reachable, their message logs are missing
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2WithNoJustifications())
+
+	output := captureOutput(testMonitor.Run, false)
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+	if !strings.Contains(output, "connection failed") {
+		t.Fatal("Failed connection attempts were not written in the report")
+	}
+}
+
+func TestMonitor_RunWithUnreachableValidatorsStartingLate(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Retry = &RetryPolicy{InitialBackoff: 100, MaxBackoff: 500}
+
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1())
+
+	// validator 2 starts listening after the monitor started
+	go func() {
+		time.Sleep(time.Second * time.Duration(2))
+		validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2())
+	}()
+
+	output := captureOutput(testMonitor.Run, true)
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+}
+
+func TestMonitor_RunFailsWhenNotEnoughLogsCanBeCollected(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+
+	// validators 2, 3 and 4 are not reachable and the monitor gives up before the timeout
+	testMonitor.Retry = &RetryPolicy{InitialBackoff: 100, MaxBackoff: 200, Deadline: 1}
+
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1())
+
+	start := time.Now()
+	output := captureOutput(testMonitor.Run, true)
+	if !strings.Contains(output, failStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+	if time.Since(start) > time.Duration(testMonitor.Timeout)*time.Second/2 {
+		t.Fatal("Monitor should have failed before the timeout")
 	}
 }
 
@@ -146,12 +230,10 @@ func TestMonitor_RunFailed(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 0, common.NewHeightVoteSet())
-	go validatorMock("2", testMonitor.Validators[1], 0, common.NewHeightVoteSet())
-	go validatorMock("3", testMonitor.Validators[2], 0, common.NewHeightVoteSet())
-	go validatorMock("4", testMonitor.Validators[3], 0, common.NewHeightVoteSet())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 0, common.NewHeightVoteSet())
+	validatorMock("2", testMonitor.Validators[1], 0, common.NewHeightVoteSet())
+	validatorMock("3", testMonitor.Validators[2], 0, common.NewHeightVoteSet())
+	validatorMock("4", testMonitor.Validators[3], 0, common.NewHeightVoteSet())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, failStatus) {
@@ -166,12 +248,10 @@ func TestMonitor_RunTimeout(t *testing.T) {
 	testMonitor.Timeout = 3
 	delay := testMonitor.Timeout + 2
 
-	go validatorMock("1", testMonitor.Validators[0], delay, common.NewHeightVoteSet())
-	go validatorMock("2", testMonitor.Validators[1], delay, common.NewHeightVoteSet())
-	go validatorMock("3", testMonitor.Validators[2], delay, common.NewHeightVoteSet())
-	go validatorMock("4", testMonitor.Validators[3], delay, common.NewHeightVoteSet())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], delay, common.NewHeightVoteSet())
+	validatorMock("2", testMonitor.Validators[1], delay, common.NewHeightVoteSet())
+	validatorMock("3", testMonitor.Validators[2], delay, common.NewHeightVoteSet())
+	validatorMock("4", testMonitor.Validators[3], delay, common.NewHeightVoteSet())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, timeoutStatus) {
@@ -185,12 +265,10 @@ func TestMonitor_RunSuccessful_SyncVersion(t *testing.T) {
 
 	testMonitor.Timeout = 3
 
-	go validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1WithNoJustifications())
-	go validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2WithNoJustifications())
-	go validatorMock("3", testMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3WithNoJustifications())
-	go validatorMock("4", testMonitor.Validators[3], 0, utils.GetHvsForDefaultConfig4WithNoJustifications())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2WithNoJustifications())
+	validatorMock("3", testMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3WithNoJustifications())
+	validatorMock("4", testMonitor.Validators[3], 0, utils.GetHvsForDefaultConfig4WithNoJustifications())
 
 	output := captureOutput(testMonitor.Run, false)
 	if !strings.Contains(output, successfulStatus) {
@@ -205,12 +283,10 @@ func TestMonitor_RunFailed_SyncVersion(t *testing.T) {
 	testMonitor.Timeout = 3
 	delay := testMonitor.Timeout + 2
 
-	go validatorMock("1", testMonitor.Validators[0], delay, utils.GetHvsForDefaultConfig1WithNoJustifications())
-	go validatorMock("2", testMonitor.Validators[1], delay, utils.GetHvsForDefaultConfig2WithNoJustifications())
-	go validatorMock("3", testMonitor.Validators[2], delay, utils.GetHvsForDefaultConfig3WithNoJustifications())
-	go validatorMock("4", testMonitor.Validators[3], delay, utils.GetHvsForDefaultConfig4WithNoJustifications())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], delay, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	validatorMock("2", testMonitor.Validators[1], delay, utils.GetHvsForDefaultConfig2WithNoJustifications())
+	validatorMock("3", testMonitor.Validators[2], delay, utils.GetHvsForDefaultConfig3WithNoJustifications())
+	validatorMock("4", testMonitor.Validators[3], delay, utils.GetHvsForDefaultConfig4WithNoJustifications())
 
 	output := captureOutput(testMonitor.Run, false)
 	if !strings.Contains(output, failStatus) {
@@ -222,12 +298,10 @@ func TestMonitor_RunSuccessful(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1())
-	go validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2())
-	go validatorMock("3", testMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3())
-	go validatorMock("4", testMonitor.Validators[3], 0, utils.GetHvsForDefaultConfig4())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 0, utils.GetHvsForDefaultConfig4())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, successfulStatus) {
@@ -239,12 +313,10 @@ func TestMonitor_RunSuccessfulWithDelays(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
-	go validatorMock("2", testMonitor.Validators[1], 4, utils.GetHvsForDefaultConfig2())
-	go validatorMock("3", testMonitor.Validators[2], 3, utils.GetHvsForDefaultConfig3())
-	go validatorMock("4", testMonitor.Validators[3], 6, utils.GetHvsForDefaultConfig4())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 4, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 3, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 6, utils.GetHvsForDefaultConfig4())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, successfulStatus) {
@@ -256,12 +328,10 @@ func TestMonitor_RunSuccessfulWithAllFaultyFirst(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 3, utils.GetHvsForDefaultConfig1())
-	go validatorMock("2", testMonitor.Validators[1], 3, utils.GetHvsForDefaultConfig2())
-	go validatorMock("3", testMonitor.Validators[2], 1, utils.GetHvsForDefaultConfig3())
-	go validatorMock("4", testMonitor.Validators[3], 1, utils.GetHvsForDefaultConfig4())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 3, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 3, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 1, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 1, utils.GetHvsForDefaultConfig4())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, successfulStatus) {
@@ -273,12 +343,10 @@ func TestMonitor_RunSuccessfulWithAllFaultyLast(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
-	go validatorMock("2", testMonitor.Validators[1], 1, utils.GetHvsForDefaultConfig2())
-	go validatorMock("3", testMonitor.Validators[2], 3, utils.GetHvsForDefaultConfig3())
-	go validatorMock("4", testMonitor.Validators[3], 3, utils.GetHvsForDefaultConfig4())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 1, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 3, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 3, utils.GetHvsForDefaultConfig4())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, successfulStatus) {
@@ -290,12 +358,10 @@ func TestMonitor_RunSuccessfulWithLateReply(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
-	go validatorMock("2", testMonitor.Validators[1], 4, utils.GetHvsForDefaultConfig2())
-	go validatorMock("3", testMonitor.Validators[2], 1, utils.GetHvsForDefaultConfig3())
-	go validatorMock("4", testMonitor.Validators[3], 4, utils.GetHvsForDefaultConfig4())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 4, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 1, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 4, utils.GetHvsForDefaultConfig4())
 
 	output := captureOutput(testMonitor.Run, true)
 	if !strings.Contains(output, successfulStatus) {
@@ -307,12 +373,10 @@ func TestMonitor_WriteReport(t *testing.T) {
 
 	testMonitor := createTestMonitor()
 
-	go validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
-	go validatorMock("2", testMonitor.Validators[1], 4, utils.GetHvsForDefaultConfig2())
-	go validatorMock("3", testMonitor.Validators[2], 3, utils.GetHvsForDefaultConfig3())
-	go validatorMock("4", testMonitor.Validators[3], 6, utils.GetHvsForDefaultConfig4())
-
-	time.Sleep(time.Second * time.Duration(2))
+	validatorMock("1", testMonitor.Validators[0], 1, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 4, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 3, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 6, utils.GetHvsForDefaultConfig4())
 
 	directory := "_report"
 	report := "report.out"
@@ -329,3 +393,978 @@ func TestMonitor_WriteReport(t *testing.T) {
 		t.Fatal("Monitor didn't generate report")
 	}
 }
+
+func TestMonitor_LogToReport(t *testing.T) {
+
+	directory := "_report"
+	localPath := path.Join(directory, "report.out")
+
+	defer os.RemoveAll(directory)
+
+	_ = os.Mkdir(directory, 0777)
+
+	closeReport := logToReport(reportPath)
+	log.Print("written after the algorithm")
+	closeReport()
+
+	data, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatalf("Report not generated: %s", err)
+	}
+
+	if !strings.Contains(string(data), "written after the algorithm") {
+		t.Fatal("Logs were not written in the report while it was open")
+	}
+
+	if log.Writer() != os.Stderr {
+		t.Fatal("Logs were not written to standard error again after closing the report")
+	}
+}
+
+func TestMonitor_RestoreFromWal(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Timeout = 3
+
+	directory := "_wal"
+	testMonitor.Wal = path.Join("cmd/monitor", directory, "monitor.wal")
+
+	defer os.RemoveAll(directory)
+	_ = os.Mkdir(directory, 0777)
+
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2WithNoJustifications())
+
+	// store the message logs of the first two validators, then "crash" before receiving the others
+	err := testMonitor.restoreFromWal()
+	if err != nil {
+		t.Fatalf("Failed to open write-ahead log: %s", err)
+	}
+	testMonitor.Validators = testMonitor.Validators[:2]
+	err = testMonitor.connectToValidators()
+	if err != nil {
+		t.Fatalf("Failed to connect to validators: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		testMonitor.storeResponse(<-testMonitor.receiveChannel)
+	}
+	_ = testMonitor.wal.Close()
+
+	// restart with all validators, the message logs of the first two must not be requested again
+	restartedMonitor := createTestMonitor()
+	restartedMonitor.Timeout = 3
+	restartedMonitor.Wal = testMonitor.Wal
+	restartedMonitor.Validators = append(testMonitor.Validators, restartedMonitor.Validators[2:]...)
+
+	validatorMock("3", restartedMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3WithNoJustifications())
+	validatorMock("4", restartedMonitor.Validators[3], 0, utils.GetHvsForDefaultConfig4WithNoJustifications())
+
+	output := captureOutput(restartedMonitor.Run, false)
+	if !strings.Contains(output, "restored message logs of 2 validators") {
+		t.Fatal("Monitor didn't restore message logs from write-ahead log")
+	}
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+}
+
+func TestMonitor_StoreResponse(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+
+	directory := "_walstore"
+	testMonitor.Wal = path.Join("cmd/monitor", directory, "monitor.wal")
+
+	defer os.RemoveAll(directory)
+	_ = os.Mkdir(directory, 0777)
+
+	err := testMonitor.restoreFromWal()
+	if err != nil {
+		t.Fatalf("Failed to open write-ahead log: %s", err)
+	}
+
+	response := func(address, id string, hvs *common.HeightVoteSet) *validatorResponse {
+		return &validatorResponse{Address: address, Packet: &connection.Packet{Code: connection.HvsResponse, Height: testMonitor.Height, ID: id, Hvs: hvs}}
+	}
+
+	if !testMonitor.storeResponse(response(testMonitor.Validators[0], "1", utils.GetHvsForDefaultConfig1())) {
+		t.Fatal("Monitor should have stored the message logs")
+	}
+
+	// same id from another address, invalid height and second response from the same address
+	invalid := response(testMonitor.Validators[2], "3", utils.GetHvsForDefaultConfig3())
+	invalid.Packet.Height++
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	for _, rejected := range []*validatorResponse{
+		response(testMonitor.Validators[1], "1", utils.GetHvsForDefaultConfig1()),
+		invalid,
+		response(testMonitor.Validators[0], "2", utils.GetHvsForDefaultConfig2()),
+	} {
+		if testMonitor.storeResponse(rejected) {
+			t.Fatalf("Monitor should not have stored the message logs from %s", rejected.Address)
+		}
+	}
+	log.SetOutput(os.Stderr)
+
+	// the same id from another address is reported
+	if strings.Count(buf.String(), "impersonation attempt detected") != 1 {
+		t.Fatal("Monitor didn't detect impersonation attempt")
+	}
+
+	// only the message logs stored have been persisted
+	entries := 0
+	err = testMonitor.wal.Replay(func(data []byte) error {
+		entries++
+		return nil
+	})
+	_ = testMonitor.wal.Close()
+	if err != nil || entries != 1 {
+		t.Fatalf("Wrong entries in write-ahead log: %d (%v)", entries, err)
+	}
+}
+
+func TestMonitor_AnalyzeSampleLogs(t *testing.T) {
+
+	testMonitor, err := newMonitorFromMetadata(metadataPath)
+	if err != nil {
+		t.Fatalf("Metadata file not parsed correctly: %s", err)
+	}
+
+	output := captureOutput(func(report string, async bool) {
+		testMonitor.Analyze(logsPath, report, async)
+	}, true)
+
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+}
+
+func TestMonitor_ExportEvidence(t *testing.T) {
+
+	testMonitor, err := newMonitorFromMetadata(metadataPath)
+	if err != nil {
+		t.Fatalf("Metadata file not parsed correctly: %s", err)
+	}
+
+	captureOutput(func(report string, async bool) {
+		testMonitor.Analyze(logsPath, report, async)
+	}, false)
+
+	evidenceFile := "cmd/monitor/_evidence.json"
+	evidencePath, err := utils.GetProjectFilePath(evidenceFile)
+	if err != nil {
+		t.Fatalf("Failed to get evidence path: %s", err)
+	}
+	defer os.Remove(evidencePath)
+
+	// the time of the block is required
+	err = testMonitor.ExportEvidence(evidenceFile, "cmd/monitor/_config/validators.yaml", nil, time.Time{})
+	if err == nil {
+		t.Fatal("Exporting evidence without the time of the block should fail")
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	err = testMonitor.ExportEvidence(evidenceFile, "cmd/monitor/_config/validators.yaml", nil, time.Now())
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		t.Fatalf("Failed to export evidence: %s", err)
+	}
+
+	// processes 3 and 4 sent two prevotes in round 3, but the signed votes are not given
+	if strings.Count(buf.String(), "not exported: the signed votes are missing") != 2 {
+		t.Fatalf("Equivocations without signed votes should have been reported: %s", buf.String())
+	}
+
+	data, err := ioutil.ReadFile(evidencePath)
+	if err != nil {
+		t.Fatalf("Failed to read evidence: %s", err)
+	}
+
+	evidenceList := make([]*cometbft.Evidence, 0)
+	err = json.Unmarshal(data, &evidenceList)
+	if err != nil {
+		t.Fatalf("Failed to decode evidence: %s", err)
+	}
+
+	if len(evidenceList) != 1 || evidenceList[0].Type != cometbft.LightClientAttackEvidenceType {
+		t.Fatalf("Wrong evidence exported: %s", data)
+	}
+
+	err = testMonitor.ExportEvidence(evidenceFile, "cmd/monitor/_config/missing.yaml", nil, time.Now())
+	if err == nil {
+		t.Fatal("Exporting evidence without validator set should fail")
+	}
+}
+
+func TestMonitor_ExportGraph(t *testing.T) {
+
+	testMonitor, err := newMonitorFromMetadata(metadataPath)
+	if err != nil {
+		t.Fatalf("Metadata file not parsed correctly: %s", err)
+	}
+
+	// the report stays open until the graph is exported, as in the analyze command
+	directory := "_report"
+	defer os.RemoveAll(directory)
+	_ = os.Mkdir(directory, 0777)
+
+	closeReport := logToReport(reportPath)
+	testMonitor.Analyze(logsPath, "", false)
+
+	dotFile := "cmd/monitor/_graph.dot"
+	htmlFile := "cmd/monitor/_graph.html"
+	for _, graphFile := range []string{dotFile, htmlFile} {
+		graphPath, err := utils.GetProjectFilePath(graphFile)
+		if err != nil {
+			t.Fatalf("Failed to get graph path: %s", err)
+		}
+		defer os.Remove(graphPath)
+	}
+
+	err = testMonitor.ExportGraph(dotFile, htmlFile, true)
+	closeReport()
+	if err != nil {
+		t.Fatalf("Failed to export graph: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(directory, "report.out"))
+	if err != nil {
+		t.Fatalf("Failed to read report: %s", err)
+	}
+
+	if !strings.Contains(string(data), "graph of the votes written to "+dotFile) {
+		t.Fatalf("Export of the graph was not written in the report: %s", data)
+	}
+
+	for graphFile, expected := range map[string]string{dotFile: "digraph fork {", htmlFile: "<svg"} {
+		graphPath, _ := utils.GetProjectFilePath(graphFile)
+		data, err := ioutil.ReadFile(graphPath)
+		if err != nil {
+			t.Fatalf("Failed to read graph: %s", err)
+		}
+
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("Wrong graph exported to %s: %s", graphFile, data)
+		}
+	}
+}
+
+func TestMonitor_WriteHTMLReport(t *testing.T) {
+
+	// message logs of validator 4 missing
+	directory := "_htmlreport"
+	defer os.RemoveAll(directory)
+	_ = os.Mkdir(directory, 0777)
+
+	files := map[string]*common.HeightVoteSet{
+		"1.yaml": utils.GetHvsForDefaultConfig1(),
+		"2.yaml": utils.GetHvsForDefaultConfig2(),
+		"3.yaml": utils.GetHvsForDefaultConfig3(),
+	}
+
+	for name, hvs := range files {
+		data, err := common.EncodeHeightVoteSet(hvs, common.YAML)
+		if err != nil {
+			t.Fatalf("Failed to encode height vote set: %s", err)
+		}
+		_ = ioutil.WriteFile(path.Join(directory, name), data, 0644)
+	}
+
+	testMonitor, err := newMonitorFromMetadata(metadataPath)
+	if err != nil {
+		t.Fatalf("Metadata file not parsed correctly: %s", err)
+	}
+
+	captureOutput(func(report string, async bool) {
+		testMonitor.Analyze(path.Join("cmd/monitor", directory), report, async)
+	}, true)
+
+	reportFile := "cmd/monitor/_report.html"
+	reportPath, err := utils.GetProjectFilePath(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to get report path: %s", err)
+	}
+	defer os.Remove(reportPath)
+
+	err = testMonitor.WriteHTMLReport(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to write HTML report: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read HTML report: %s", err)
+	}
+	report := string(data)
+
+	for _, expected := range []string{
+		"Accountability report for height 1",
+		"<th>Mode</th><td>asynchronous</td>",
+		"Validators without message logs",
+		"<tr><td>4</td><td>no valid message logs received</td></tr>",
+		"Round 3 (first decision)",
+		`<td class="faulty inferred">4</td>`,
+		`<span class="faulty">Process 3</span>`,
+		"The process sent more than one PREVOTE message in a round",
+		"<summary>Validator 3</summary>",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("HTML report doesn't contain %s:\n%s", expected, report)
+		}
+	}
+
+	// the message logs of validator 4 are inferred, not received
+	if strings.Contains(report, "<summary>Validator 4</summary>") {
+		t.Fatal("Message logs inferred should not be shown as received")
+	}
+
+	// the message logs received are shown without the messages inferred by the algorithm
+	if len(testMonitor.receivedLogs["1"].VoteSetMap[3].SentPrevoteMessages) != 1 {
+		t.Fatal("Message logs received should not be changed by the algorithm")
+	}
+
+	if strings.Contains(report, "ZgotmplZ") || strings.Contains(report, "src=") || strings.Contains(report, "href=") {
+		t.Fatal("HTML report should be self-contained")
+	}
+}
+
+func TestMonitor_WriteHTMLReportFaultsInRoundZero(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.FirstDecisionRound = 0
+	testMonitor.SecondDecisionRound = 1
+
+	// process 1 prevoted two values in round 0, process 3 didn't send its message logs
+	equivocating := common.NewHeightVoteSet()
+	equivocating.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(10), nil))
+	equivocating.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(20), nil))
+	correct := common.NewHeightVoteSet()
+	correct.AddMessage(common.NewMessage(common.Prevote, "2", 0, common.NewValue(10), nil))
+	correct.AddReceivedMessage(common.NewMessage(common.Prevote, "3", 0, common.NewValue(10), nil))
+
+	testMonitor.accAlgorithm.Init(4, false)
+	testMonitor.accAlgorithm.StoreHvs("1", equivocating)
+	testMonitor.accAlgorithm.StoreHvs("2", correct)
+	testMonitor.accAlgorithm.Run(0, 1)
+
+	reportFile := "cmd/monitor/_report_round0.html"
+	reportPath, err := utils.GetProjectFilePath(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to get report path: %s", err)
+	}
+	defer os.Remove(reportPath)
+
+	err = testMonitor.WriteHTMLReport(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to write HTML report: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read HTML report: %s", err)
+	}
+	report := string(data)
+
+	// the equivocation is shown with the evidence of round 0, the missing message logs for the whole process
+	for _, expected := range []string{
+		"<span class=\"faulty\">Process 1</span>: 1 faults in rounds 0</summary>",
+		"<span class=\"faulty\">Process 3</span>: 1 faults</summary>",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("HTML report doesn't contain %s:\n%s", expected, report)
+		}
+	}
+	if strings.Count(report, "<h3>Round 0</h3>") != 1 {
+		t.Fatalf("Only the equivocation should be shown in round 0:\n%s", report)
+	}
+}
+
+func TestMonitor_AnalyzeLogsInDifferentFormats(t *testing.T) {
+
+	directory := "_analyze"
+	defer os.RemoveAll(directory)
+	_ = os.Mkdir(directory, 0777)
+
+	files := map[string]*common.HeightVoteSet{
+		"1.yaml": utils.GetHvsForDefaultConfig1WithNoJustifications(),
+		"2.json": utils.GetHvsForDefaultConfig2WithNoJustifications(),
+		"3.pb":   utils.GetHvsForDefaultConfig3WithNoJustifications(),
+		"4.json": utils.GetHvsForDefaultConfig4WithNoJustifications(),
+	}
+
+	for name, hvs := range files {
+		format, _ := common.FormatFromPath(name)
+		data, err := common.EncodeHeightVoteSet(hvs, format)
+		if err != nil {
+			t.Fatalf("Failed to encode height vote set: %s", err)
+		}
+		_ = ioutil.WriteFile(path.Join(directory, name), data, 0644)
+	}
+
+	testMonitor := createTestMonitor()
+	testMonitor.Validators = []string{"1", "2", "3", "4"}
+
+	output := captureOutput(func(report string, async bool) {
+		testMonitor.Analyze(path.Join("cmd/monitor", directory), report, async)
+	}, false)
+
+	if !strings.Contains(output, "4 message logs have been loaded so far") {
+		t.Fatal("Monitor didn't load all the message logs")
+	}
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+}
+
+// validator mock that fails the first requests by sending an invalid response or by closing the connection
+func flakyValidatorMock(id string, address string, failures int, closeConnection bool, hvs *common.HeightVoteSet) {
+	server := connection.NewServer()
+
+	go func() {
+		requests := 0
+		for clientData := range server.ReceiveChannel {
+
+			packet := clientData.Packet
+			if packet == nil || packet.Code != connection.HvsRequest {
+				continue
+			}
+
+			requests++
+			if requests <= failures && closeConnection {
+				clientData.Connection.Close()
+				continue
+			}
+
+			// prepare packet, with a wrong height if it must fail
+			packet.Code = connection.HvsResponse
+			packet.Hvs = hvs
+			packet.ID = id
+			if requests <= failures {
+				packet.Height++
+			}
+
+			err := clientData.Connection.Send(packet)
+			if err != nil {
+				log.Printf("Error while sending packet back to monitor: %s", err)
+			}
+		}
+	}()
+
+	listenInMemory(server, address)
+}
+
+func TestMonitor_RetryPolicyBackoff(t *testing.T) {
+
+	policy := (&RetryPolicy{InitialBackoff: 100, MaxBackoff: 500, Multiplier: 2}).withDefaults()
+
+	expected := []time.Duration{100, 200, 400, 500, 500}
+	for i, backoff := range expected {
+		if policy.backoff(uint64(i+1)) != backoff*time.Millisecond {
+			t.Fatalf("Unexpected backoff for attempt %d: %s", i+1, policy.backoff(uint64(i+1)))
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(1)
+		if backoff < 50*time.Millisecond || backoff > 100*time.Millisecond {
+			t.Fatalf("Backoff with jitter out of bounds: %s", backoff)
+		}
+	}
+
+	if (*RetryPolicy)(nil).withDefaults().MaxAttempts != defaultMaxAttempts || (*RetryPolicy)(nil).withDefaults().Deadline != defaultDeadline {
+		t.Fatal("Default retry policy not applied")
+	}
+
+	// the default deadline leaves enough time for all the requests
+	if (&RetryPolicy{MaxAttempts: 4, AttemptTimeout: 10}).withDefaults().Deadline != 40 {
+		t.Fatal("Default deadline too short for the requests")
+	}
+}
+
+func TestMonitor_UnreachableValidatorAfterMonitorStopped(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Retry = &RetryPolicy{InitialBackoff: 100, MaxBackoff: 200, Deadline: 1}
+
+	// nobody reads the responses
+	testMonitor.receiveChannel = make(chan *validatorResponse)
+
+	returned := make(chan struct{})
+	go func() {
+		testMonitor.receiveHvsFromValidator(testMonitor.Validators[0])
+		close(returned)
+	}()
+
+	// the monitor stops after the deadline expired, while the missing message logs are being notified
+	time.Sleep(2 * time.Second)
+	close(testMonitor.done)
+
+	select {
+	case <-returned:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Request of message logs should have stopped after the monitor stopped")
+	}
+}
+
+func TestMonitor_RetryAfterInvalidResponsesAndDroppedConnections(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Timeout = 10
+	testMonitor.Retry = &RetryPolicy{MaxAttempts: 3, InitialBackoff: 100}
+	testMonitor.ValidatorSettings = map[string]*ValidatorSettings{
+		// validator 4 fails more than the default policy allows
+		testMonitor.Validators[3]: {Retry: &RetryPolicy{MaxAttempts: 4, InitialBackoff: 100}},
+	}
+
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	flakyValidatorMock("2", testMonitor.Validators[1], 2, false, utils.GetHvsForDefaultConfig2WithNoJustifications())
+	flakyValidatorMock("3", testMonitor.Validators[2], 2, true, utils.GetHvsForDefaultConfig3WithNoJustifications())
+	flakyValidatorMock("4", testMonitor.Validators[3], 3, true, utils.GetHvsForDefaultConfig4WithNoJustifications())
+
+	output := captureOutput(testMonitor.Run, false)
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+
+	expectedAttempts := []int{1, 3, 3, 4}
+	for i, address := range testMonitor.Validators {
+		if testMonitor.attemptHistory.Length(address) != expectedAttempts[i] {
+			t.Fatalf("Unexpected number of attempts for validator %s: %d", address, testMonitor.attemptHistory.Length(address))
+		}
+	}
+
+	if !strings.Contains(output, invalidResponseOutcome) || !strings.Contains(output, "connection closed by validator") {
+		t.Fatal("Attempt history was not written in the report")
+	}
+}
+
+// validator mock that answers with the given id and signs the response with the given key, if any
+func signingValidatorMock(id string, address string, privateKey ed25519.PrivateKey, hvs *common.HeightVoteSet) {
+	server := connection.NewServer()
+
+	go func() {
+		for clientData := range server.ReceiveChannel {
+
+			packet := clientData.Packet
+			if packet == nil || packet.Code != connection.HvsRequest {
+				continue
+			}
+
+			packet.Code = connection.HvsResponse
+			packet.Hvs = hvs
+			packet.ID = id
+			if privateKey != nil {
+				_ = packet.Sign(privateKey)
+			}
+
+			err := clientData.Connection.Send(packet)
+			if err != nil {
+				log.Printf("Error while sending packet back to monitor: %s", err)
+			}
+		}
+	}()
+
+	listenInMemory(server, address)
+}
+
+func TestMonitor_RejectImpersonationAttempts(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Timeout = 3
+
+	publicKey3, privateKey3, _ := ed25519.GenerateKey(nil)
+	publicKey4, _, _ := ed25519.GenerateKey(nil)
+	_, wrongPrivateKey4, _ := ed25519.GenerateKey(nil)
+
+	testMonitor.ValidatorSettings = map[string]*ValidatorSettings{
+		testMonitor.Validators[0]: {ID: "1"},
+		testMonitor.Validators[1]: {ID: "2"},
+		testMonitor.Validators[2]: {ID: "3", PublicKey: hex.EncodeToString(publicKey3)},
+		testMonitor.Validators[3]: {ID: "4", PublicKey: hex.EncodeToString(publicKey4)},
+	}
+
+	// validator 2 answers as validator 1, validator 4 signs with the wrong key
+	signingValidatorMock("1", testMonitor.Validators[0], nil, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	signingValidatorMock("1", testMonitor.Validators[1], nil, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	signingValidatorMock("3", testMonitor.Validators[2], privateKey3, utils.GetHvsForDefaultConfig3WithNoJustifications())
+	signingValidatorMock("4", testMonitor.Validators[3], wrongPrivateKey4, utils.GetHvsForDefaultConfig4WithNoJustifications())
+
+	output := captureOutput(testMonitor.Run, false)
+
+	if strings.Count(output, "impersonation attempt detected") != 2 {
+		t.Fatal("Monitor didn't detect impersonation attempts")
+	}
+	if testMonitor.accAlgorithm.GetNumLogs() != 2 || !testMonitor.collected[testMonitor.Validators[0]] || !testMonitor.collected[testMonitor.Validators[2]] {
+		t.Fatal("Monitor stored message logs from impersonators")
+	}
+}
+
+func TestMonitor_RejectConnectionsWithoutSignatures(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Retry = &RetryPolicy{InitialBackoff: 100, MaxBackoff: 200, Deadline: 1}
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	testMonitor.ValidatorSettings = map[string]*ValidatorSettings{
+		testMonitor.Validators[0]: {ID: "1", PublicKey: hex.EncodeToString(publicKey)},
+		testMonitor.Validators[1]: {ID: "2"},
+		testMonitor.Validators[2]: {ID: "3"},
+		testMonitor.Validators[3]: {ID: "4"},
+	}
+	err := testMonitor.loadValidatorSettings()
+	if err != nil {
+		t.Fatalf("Failed to load validator settings: %s", err)
+	}
+
+	// the validator doesn't support signatures, so its responses can't be authenticated
+	defer func(supported []string) {
+		connection.SupportedFeatures = supported
+	}(connection.SupportedFeatures)
+	connection.SupportedFeatures = []string{connection.FeatureStreaming}
+
+	signingValidatorMock("1", testMonitor.Validators[0], privateKey, utils.GetHvsForDefaultConfig1WithNoJustifications())
+
+	captureOutput(func(string, bool) {
+		testMonitor.receiveHvsFromValidator(testMonitor.Validators[0])
+	}, false)
+
+	response := <-testMonitor.receiveChannel
+	if response.Packet.Code != connection.HvsMissing {
+		t.Fatal("Monitor should not have accepted message logs without signatures")
+	}
+	if !strings.Contains(testMonitor.attemptHistory.String(testMonitor.Validators), "doesn't support signatures") {
+		t.Fatal("Refused connections were not written in the attempt history")
+	}
+}
+
+func TestMonitor_ValidatorSettingsWithDuplicatedIDs(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.ValidatorSettings = map[string]*ValidatorSettings{
+		testMonitor.Validators[0]: {ID: "1"},
+		testMonitor.Validators[1]: {ID: "1"},
+	}
+
+	if testMonitor.loadValidatorSettings() == nil {
+		t.Fatal("Should have failed because two validators are bound to the same id")
+	}
+
+	testMonitor.ValidatorSettings = map[string]*ValidatorSettings{
+		testMonitor.Validators[0]: {ID: "1", PublicKey: "not a key"},
+	}
+
+	if testMonitor.loadValidatorSettings() == nil {
+		t.Fatal("Should have failed because the public key is not valid")
+	}
+
+	// with authentication, a validator without binding could take the id of another one
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	testMonitor.ValidatorSettings = map[string]*ValidatorSettings{
+		testMonitor.Validators[0]: {ID: "1", PublicKey: hex.EncodeToString(publicKey)},
+		testMonitor.Validators[1]: {ID: "2"},
+		testMonitor.Validators[2]: {ID: "3"},
+	}
+
+	if testMonitor.loadValidatorSettings() == nil {
+		t.Fatal("Should have failed because a validator is not bound to an id while public keys are given")
+	}
+}
+
+func TestMonitor_RequestRoundRangeAndWiden(t *testing.T) {
+
+	address := newTestAddress()
+
+	testMonitor := NewMonitor()
+	testMonitor.Height = 1
+	testMonitor.FirstDecisionRound = 3
+	testMonitor.SecondDecisionRound = 4
+	testMonitor.Validators = []string{address}
+
+	// the prevote sent in round 3 is justified by a prevote received in round 1
+	justification := common.NewMessage(common.Prevote, "2", 1, common.NewValue(5), nil)
+	hvs := common.NewHeightVoteSet()
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(1), nil))
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 3, common.NewValue(5), []*common.Message{justification}))
+	hvs.AddMessage(common.NewMessage(common.Precommit, "1", 4, common.NewValue(5), nil))
+	hvs.VoteSetMap[1] = common.NewVoteSet()
+	hvs.VoteSetMap[1].ReceivedPrevoteMessages = append(hvs.VoteSetMap[1].ReceivedPrevoteMessages, justification)
+
+	// validator answering only with the rounds requested
+	server := connection.NewServer()
+	filters := make(chan *connection.Filter, 10)
+
+	go func() {
+		for clientData := range server.ReceiveChannel {
+			packet := clientData.Packet
+			filters <- packet.Filter
+
+			packet.Code = connection.HvsResponse
+			packet.ID = "1"
+			packet.Hvs = hvs.Filter(packet.Filter.FromRound, packet.Filter.ToRound, packet.Filter.Types)
+			_ = clientData.Connection.Send(packet)
+		}
+	}()
+
+	listenInMemory(server, address)
+
+	conn, err := connection.Dial(testTransport, address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	mux := connection.NewMultiplexer(conn)
+	defer mux.Close()
+
+	packet, outcome := testMonitor.requestHvs(address, mux, time.Now().Add(5*time.Second))
+	if packet == nil {
+		t.Fatalf("Failed to get message logs: %s", outcome)
+	}
+
+	// first the decision rounds, then the range is widened to the round of the justification
+	first, second := <-filters, <-filters
+	if first.FromRound != 3 || first.ToRound != 4 || second.FromRound != 1 || second.ToRound != 4 || len(filters) != 0 {
+		t.Fatal("Wrong round ranges requested")
+	}
+
+	if len(packet.Hvs.VoteSetMap) != 3 || packet.Hvs.VoteSetMap[0] != nil || packet.Hvs.VoteSetMap[1] == nil {
+		t.Fatal("Wrong rounds received")
+	}
+}
+
+func TestMonitor_WidenToPrunedRounds(t *testing.T) {
+
+	address := newTestAddress()
+
+	testMonitor := NewMonitor()
+	testMonitor.Height = 1
+	testMonitor.FirstDecisionRound = 3
+	testMonitor.SecondDecisionRound = 4
+	testMonitor.Validators = []string{address}
+
+	// the prevote sent in round 3 is justified by a prevote received in round 1, which has been pruned
+	justification := common.NewMessage(common.Prevote, "2", 1, common.NewValue(5), nil)
+	hvs := common.NewHeightVoteSet()
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 2, common.NewValue(1), nil))
+	hvs.AddMessage(common.NewMessage(common.Prevote, "1", 3, common.NewValue(5), []*common.Message{justification}))
+	hvs.AddMessage(common.NewMessage(common.Precommit, "1", 4, common.NewValue(5), nil))
+
+	// validator refusing the requests of rounds older than the ones stored
+	server := connection.NewServer()
+	filters := make(chan *connection.Filter, 10)
+
+	go func() {
+		for clientData := range server.ReceiveChannel {
+			packet := clientData.Packet
+			filters <- packet.Filter
+
+			response := &connection.Packet{Code: connection.HvsResponse, ID: "1", Height: packet.Height, RequestID: packet.RequestID, Filter: packet.Filter}
+			if packet.Filter.FromRound < 2 {
+				response = connection.NewErrorPacket(packet, connection.ReasonRoundsPruned, "message logs available from round 2")
+				response.ID = "1"
+			} else {
+				response.Hvs = hvs.Filter(packet.Filter.FromRound, packet.Filter.ToRound, packet.Filter.Types)
+			}
+			_ = clientData.Connection.Send(response)
+		}
+	}()
+
+	listenInMemory(server, address)
+
+	conn, err := connection.Dial(testTransport, address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	mux := connection.NewMultiplexer(conn)
+	defer mux.Close()
+
+	packet, outcome := testMonitor.requestHvs(address, mux, time.Now().Add(5*time.Second))
+	if packet == nil || outcome != receivedOutcome {
+		t.Fatalf("Failed to get message logs: %s", outcome)
+	}
+
+	// the range is widened, then the response to the decision rounds is used because the earlier rounds are not available anymore
+	first, second := <-filters, <-filters
+	if first.FromRound != 3 || second.FromRound != 1 || len(filters) != 0 {
+		t.Fatal("Wrong round ranges requested")
+	}
+
+	if packet.Code != connection.HvsResponse || len(packet.Hvs.VoteSetMap) != 2 || packet.Hvs.VoteSetMap[3] == nil {
+		t.Fatal("Wrong rounds received")
+	}
+}
+
+func TestMonitor_RequestRoundsStoredWhenPruned(t *testing.T) {
+
+	address := newTestAddress()
+
+	testMonitor := NewMonitor()
+	testMonitor.Height = 1
+	testMonitor.FirstDecisionRound = 3
+	testMonitor.SecondDecisionRound = 4
+	testMonitor.Validators = []string{address}
+
+	// the messages of round 3 have been pruned, only round 4 is stored
+	hvs := common.NewHeightVoteSet()
+	hvs.AddMessage(common.NewMessage(common.Precommit, "1", 4, common.NewValue(5), nil))
+
+	// validator refusing the requests of rounds pruned and sending all the rounds stored otherwise
+	server := connection.NewServer()
+	filters := make(chan *connection.Filter, 10)
+
+	go func() {
+		for clientData := range server.ReceiveChannel {
+			packet := clientData.Packet
+			filters <- packet.Filter
+
+			response := &connection.Packet{Code: connection.HvsResponse, ID: "1", Height: packet.Height, RequestID: packet.RequestID, Hvs: hvs, Filter: packet.Filter}
+			if packet.Filter != nil && packet.Filter.FromRound < 4 {
+				response = connection.NewErrorPacket(packet, connection.ReasonRoundsPruned, "message logs available from round 4")
+				response.ID = "1"
+			}
+			_ = clientData.Connection.Send(response)
+		}
+	}()
+
+	listenInMemory(server, address)
+
+	conn, err := connection.Dial(testTransport, address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	mux := connection.NewMultiplexer(conn)
+	defer mux.Close()
+
+	packet, outcome := testMonitor.requestHvs(address, mux, time.Now().Add(5*time.Second))
+	if packet == nil || outcome != receivedOutcome {
+		t.Fatalf("Failed to get message logs: %s", outcome)
+	}
+
+	// the decision rounds are requested first, then all the rounds stored
+	first, second := <-filters, <-filters
+	if first == nil || first.FromRound != 3 || second != nil || len(filters) != 0 {
+		t.Fatal("Wrong round ranges requested")
+	}
+
+	if packet.Code != connection.HvsResponse || len(packet.Hvs.VoteSetMap) != 1 || packet.Hvs.VoteSetMap[4] == nil {
+		t.Fatal("Wrong rounds received")
+	}
+}
+
+func TestMonitor_RunWithNetworkFaults(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Retry = &RetryPolicy{MaxAttempts: 10, InitialBackoff: 100, MaxBackoff: 200, AttemptTimeout: 1}
+
+	// slow validator 1, validator 2 isolated for a while, malicious validator 3 on the wire, validator 4 isolated
+	transport := connection.NewFaultyTransport(testTransport,
+		&connection.FaultRule{Address: testMonitor.Validators[0], Latency: 200, Jitter: 100},
+		&connection.FaultRule{Address: testMonitor.Validators[1], Partitioned: true},
+		&connection.FaultRule{Address: testMonitor.Validators[2], CorruptRate: 1},
+		&connection.FaultRule{Address: testMonitor.Validators[3], Partitioned: true},
+	)
+	testMonitor.transport = transport
+
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1())
+	validatorMock("2", testMonitor.Validators[1], 0, utils.GetHvsForDefaultConfig2())
+	validatorMock("3", testMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3())
+	validatorMock("4", testMonitor.Validators[3], 0, utils.GetHvsForDefaultConfig4())
+
+	go func() {
+		time.Sleep(time.Second)
+		transport.Heal(testMonitor.Validators[1])
+	}()
+
+	output := captureOutput(testMonitor.Run, true)
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+	if !strings.Contains(output, "network partition") {
+		t.Fatal("Partition was not written in the report")
+	}
+}
+
+func TestMonitor_LoadFaultScenario(t *testing.T) {
+
+	transport, err := connection.LoadFaultScenario(faultsPath, testTransport)
+	if err != nil {
+		t.Fatalf("Fault scenario not parsed correctly: %s", err)
+	}
+
+	// the isolated validator of the scenario can't be reached
+	if _, err := transport.Dial("127.0.0.1:8083"); err == nil || !strings.Contains(err.Error(), "network partition") {
+		t.Fatal("Validator should have been isolated")
+	}
+}
+
+// validator mock that answers the first requests with error responses for the given reasons, then with the message logs
+func errorValidatorMock(id string, address string, reasons []uint32, hvs *common.HeightVoteSet) {
+	server := connection.NewServer()
+
+	go func() {
+		requests := 0
+		for clientData := range server.ReceiveChannel {
+
+			packet := clientData.Packet
+			if packet == nil || packet.Code != connection.HvsRequest {
+				continue
+			}
+
+			response := &connection.Packet{Code: connection.HvsResponse, ID: id, Height: packet.Height, RequestID: packet.RequestID, Hvs: hvs, Filter: packet.Filter}
+			if requests < len(reasons) {
+				response = connection.NewErrorPacket(packet, reasons[requests], "test error")
+				response.ID = id
+			}
+			requests++
+
+			_ = clientData.Connection.Send(response)
+		}
+	}()
+
+	listenInMemory(server, address)
+}
+
+func TestMonitor_ErrorResponses(t *testing.T) {
+
+	testMonitor := createTestMonitor()
+	testMonitor.Retry = &RetryPolicy{MaxAttempts: 3, InitialBackoff: 100}
+
+	validatorMock("1", testMonitor.Validators[0], 0, utils.GetHvsForDefaultConfig1WithNoJustifications())
+	// overloaded, the request is repeated later
+	errorValidatorMock("2", testMonitor.Validators[1], []uint32{connection.ReasonRateLimited, connection.ReasonInternalError}, utils.GetHvsForDefaultConfig2WithNoJustifications())
+	validatorMock("3", testMonitor.Validators[2], 0, utils.GetHvsForDefaultConfig3WithNoJustifications())
+	// no message logs for the height, the validator is not contacted again
+	errorValidatorMock("4", testMonitor.Validators[3], []uint32{connection.ReasonUnknownHeight}, nil)
+
+	start := time.Now()
+	output := captureOutput(testMonitor.Run, false)
+
+	if !strings.Contains(output, successfulStatus) {
+		t.Fatal("Output of the algorithm was not expected")
+	}
+
+	// the monitor doesn't wait for the timeout
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Monitor waited too long for the validator that declared its message logs missing")
+	}
+
+	expectedAttempts := []int{1, 3, 1, 1}
+	for i, address := range testMonitor.Validators {
+		if testMonitor.attemptHistory.Length(address) != expectedAttempts[i] {
+			t.Fatalf("Unexpected number of attempts for validator %s: %d", address, testMonitor.attemptHistory.Length(address))
+		}
+	}
+
+	if !testMonitor.collected[testMonitor.Validators[1]] {
+		t.Fatal("Message logs of the overloaded validator not received")
+	}
+
+	if len(testMonitor.declaredMissing) != 1 || testMonitor.declaredMissing[testMonitor.Validators[3]] == "" {
+		t.Fatal("Validator that declared its message logs missing not reported")
+	}
+
+	if !strings.Contains(output, refusedOutcome+": rate limited") || !strings.Contains(output, "declared its message logs missing: unknown height") {
+		t.Fatal("Error responses were not written in the report")
+	}
+}
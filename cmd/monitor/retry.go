@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default retry policy: a single request, as long as the connection read deadline, and connection attempts for at least 30 seconds
+const (
+	defaultMaxAttempts    = 1
+	defaultInitialBackoff = 500
+	defaultMaxBackoff     = 10000
+	defaultMultiplier     = 2
+	defaultAttemptTimeout = 20
+	defaultDeadline       = 30
+)
+
+// outcomes of an attempt
+const (
+	receivedOutcome        = "message logs received"
+	invalidResponseOutcome = "invalid response received"
+	impersonationOutcome   = "impersonation attempt"
+	refusedOutcome         = "request refused by validator"
+	declaredMissingOutcome = "message logs declared missing by validator"
+)
+
+// RetryPolicy defines how the monitor repeats the request of message logs to a validator
+type RetryPolicy struct {
+	// maximum number of requests (and connection attempts) to the validator
+	MaxAttempts uint64 `yaml:"maxAttempts"`
+	// time to wait (in milliseconds) before the first retry
+	InitialBackoff uint64 `yaml:"initialBackoff"`
+	// maximum time to wait (in milliseconds) between two attempts
+	MaxBackoff uint64 `yaml:"maxBackoff"`
+	// factor applied to the backoff after each attempt
+	Multiplier float64 `yaml:"multiplier"`
+	// fraction of the backoff randomly removed to avoid synchronized retries, between 0 and 1
+	Jitter float64 `yaml:"jitter"`
+	// time to wait (in seconds) for the response of a single attempt
+	AttemptTimeout uint64 `yaml:"attemptTimeout"`
+	// overall time (in seconds) after which the monitor stops retrying, it also bounds the connection attempts to unreachable validators
+	Deadline uint64 `yaml:"deadline"`
+}
+
+// return a copy of the policy with default values for the parameters not given
+func (policy *RetryPolicy) withDefaults() *RetryPolicy {
+	complete := &RetryPolicy{}
+	if policy != nil {
+		*complete = *policy
+	}
+
+	if complete.MaxAttempts == 0 {
+		complete.MaxAttempts = defaultMaxAttempts
+	}
+	if complete.InitialBackoff == 0 {
+		complete.InitialBackoff = defaultInitialBackoff
+	}
+	if complete.MaxBackoff == 0 {
+		complete.MaxBackoff = defaultMaxBackoff
+	}
+	if complete.Multiplier < 1 {
+		complete.Multiplier = defaultMultiplier
+	}
+	if complete.AttemptTimeout == 0 {
+		complete.AttemptTimeout = defaultAttemptTimeout
+	}
+	if complete.Deadline == 0 {
+		// leave enough time for all the requests
+		complete.Deadline = uint64(math.Max(defaultDeadline, float64(complete.MaxAttempts*complete.AttemptTimeout)))
+	}
+	complete.Jitter = math.Max(0, math.Min(1, complete.Jitter))
+
+	return complete
+}
+
+// backoff returns the time to wait after the given (failed) attempt, starting from 1
+func (policy *RetryPolicy) backoff(attempt uint64) time.Duration {
+	backoff := float64(policy.InitialBackoff) * math.Pow(policy.Multiplier, float64(attempt-1))
+	backoff = math.Min(backoff, float64(policy.MaxBackoff))
+
+	// remove a random fraction of the backoff
+	backoff -= backoff * policy.Jitter * rand.Float64()
+
+	return time.Duration(backoff) * time.Millisecond
+}
+
+// get the retry policy to use for the validator at the given address
+func (monitor *Monitor) getRetryPolicy(address string) *RetryPolicy {
+	settings, loaded := monitor.ValidatorSettings[address]
+	if loaded && settings != nil && settings.Retry != nil {
+		return settings.Retry.withDefaults()
+	}
+	return monitor.Retry.withDefaults()
+}
+
+// attempt made by the monitor to get the message logs of a validator
+type attempt struct {
+	number  uint64
+	time    time.Time
+	outcome string
+}
+
+// AttemptHistory stores the attempts made to get message logs from each validator
+type AttemptHistory struct {
+	attempts map[string][]*attempt
+	mutex    sync.RWMutex
+}
+
+// NewAttemptHistory creates a new AttemptHistory structure
+func NewAttemptHistory() *AttemptHistory {
+	return &AttemptHistory{
+		attempts: make(map[string][]*attempt),
+	}
+}
+
+// Add records the outcome of an attempt for the validator at the given address
+func (ah *AttemptHistory) Add(address string, number uint64, outcome string) {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	ah.attempts[address] = append(ah.attempts[address], &attempt{number: number, time: time.Now(), outcome: outcome})
+}
+
+// Length returns the number of attempts recorded for the validator at the given address
+func (ah *AttemptHistory) Length(address string) int {
+	ah.mutex.RLock()
+	defer ah.mutex.RUnlock()
+	return len(ah.attempts[address])
+}
+
+// string representation of the attempt history, in the order of the validators given
+func (ah *AttemptHistory) String(addresses []string) string {
+	ah.mutex.RLock()
+	defer ah.mutex.RUnlock()
+
+	var sb strings.Builder
+
+	sb.WriteString("ATTEMPT HISTORY\n\n")
+
+	for _, address := range addresses {
+		sb.WriteString("*** Validator ")
+		sb.WriteString(address)
+		sb.WriteString(" ***\n")
+
+		if len(ah.attempts[address]) == 0 {
+			sb.WriteString("\tNo attempts\n")
+		}
+
+		for _, att := range ah.attempts[address] {
+			sb.WriteString("\tAttempt ")
+			sb.WriteString(strconv.FormatUint(att.number, 10))
+			sb.WriteString(" at ")
+			sb.WriteString(att.time.Format(time.RFC3339Nano))
+			sb.WriteString(": ")
+			sb.WriteString(att.outcome)
+			sb.WriteString("\n")
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/mikanikos/Fork-Accountability/connection"
+)
+
+// ValidatorSettings contains the settings specific to a validator
+type ValidatorSettings struct {
+	// expected id of the validator answering at the address
+	ID string `yaml:"id"`
+	// hex-encoded ed25519 public key used to verify the responses of the validator
+	PublicKey string `yaml:"publicKey"`
+	// retry policy, it replaces the default one
+	Retry *RetryPolicy `yaml:"retry"`
+
+	publicKey ed25519.PublicKey
+}
+
+// check that the settings of the validators are consistent and parse their public keys
+// each validator id must be bound to a single address, which is the only source of its message logs
+// if the responses of some validators are authenticated, all the validators must be bound to their id
+func (monitor *Monitor) loadValidatorSettings() error {
+	monitor.boundAddresses = make(map[string]string)
+	authenticated := false
+
+	for address, settings := range monitor.ValidatorSettings {
+		if settings == nil {
+			continue
+		}
+
+		if !monitor.isValidator(address) {
+			return fmt.Errorf("error: settings given for unknown validator %s", address)
+		}
+
+		if settings.PublicKey != "" {
+			if settings.ID == "" {
+				return fmt.Errorf("error: public key given without id for validator %s", address)
+			}
+
+			publicKey, err := connection.ParsePublicKey(settings.PublicKey)
+			if err != nil {
+				return fmt.Errorf("error in the settings of validator %s: %s", address, err)
+			}
+			settings.publicKey = publicKey
+			authenticated = true
+		}
+
+		if settings.ID != "" {
+			other, loaded := monitor.boundAddresses[settings.ID]
+			if loaded {
+				return fmt.Errorf("error: validators %s and %s are both bound to id %s", other, address, settings.ID)
+			}
+			monitor.boundAddresses[settings.ID] = address
+		}
+	}
+
+	// otherwise, an unbound validator could answer first with the id of another one and take its place
+	if authenticated {
+		for _, address := range monitor.Validators {
+			settings, loaded := monitor.ValidatorSettings[address]
+			if !loaded || settings == nil || settings.ID == "" {
+				return fmt.Errorf("error: validator %s is not bound to an id, which is required when public keys are given", address)
+			}
+		}
+	}
+
+	return nil
+}
+
+// check that the validator at the given address is allowed to send message logs with the id and signature of the packet
+// return an empty string if the sender is authentic, the reason of the rejection otherwise
+func (monitor *Monitor) checkSenderIdentity(address string, packet *connection.Packet) string {
+
+	// the id can only be used by the address it is bound to
+	boundAddress, loaded := monitor.boundAddresses[packet.ID]
+	if loaded && boundAddress != address {
+		return fmt.Sprintf("validator at %s answered with id %s, which is bound to %s", address, packet.ID, boundAddress)
+	}
+
+	settings, loaded := monitor.ValidatorSettings[address]
+	if !loaded || settings == nil {
+		return ""
+	}
+
+	if settings.ID != "" && settings.ID != packet.ID {
+		return fmt.Sprintf("validator at %s answered with id %s instead of %s", address, packet.ID, settings.ID)
+	}
+
+	if settings.publicKey != nil && !packet.VerifySignature(settings.publicKey) {
+		return fmt.Sprintf("validator at %s answered with an invalid signature for id %s", address, packet.ID)
+	}
+
+	return ""
+}
+
+// check that the features needed to authenticate the validator at the given address have been negotiated on the connection
+// return an empty string if the connection can be used, the reason of the rejection otherwise
+func (monitor *Monitor) checkConnectionFeatures(address string, conn *connection.Connection) string {
+	settings, loaded := monitor.ValidatorSettings[address]
+	if loaded && settings != nil && settings.publicKey != nil && !conn.HasFeature(connection.FeatureSignatures) {
+		return fmt.Sprintf("validator at %s doesn't support signatures, its responses can't be authenticated", address)
+	}
+	return ""
+}
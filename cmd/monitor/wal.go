@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/mikanikos/Fork-Accountability/utils"
+	"github.com/mikanikos/Fork-Accountability/wal"
+	"go.dedis.ch/protobuf"
+)
+
+// open the write-ahead log and restore the valid message logs received in a previous execution
+func (monitor *Monitor) restoreFromWal() error {
+
+	walPath, err := utils.GetProjectFilePath(monitor.Wal)
+	if err != nil {
+		return err
+	}
+
+	monitor.wal, err = wal.Open(walPath)
+	if err != nil {
+		return err
+	}
+
+	err = monitor.wal.Replay(func(data []byte) error {
+		response := &validatorResponse{}
+		err := protobuf.Decode(data, response)
+		if err != nil {
+			return fmt.Errorf("error while deserializing write-ahead log entry: %s", err)
+		}
+
+		// ignore responses for other heights, from validators not given in the config or not authentic
+		if !monitor.isValidator(response.Address) || !monitor.checkResponseValidity(response.Packet) ||
+			monitor.checkSenderIdentity(response.Address, response.Packet) != "" {
+			return nil
+		}
+
+		if !monitor.collected[response.Address] && monitor.storeHvs(response.Packet.ID, response.Packet.Hvs) {
+			monitor.collected[response.Address] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		_ = monitor.wal.Close()
+		return err
+	}
+
+	if debug && len(monitor.collected) > 0 {
+		log.Printf("Monitor: restored message logs of %d validators from write-ahead log", len(monitor.collected))
+	}
+
+	return nil
+}
+
+// append a response to the write-ahead log and wait until it is on disk
+func (monitor *Monitor) appendToWal(response *validatorResponse) error {
+	data, err := protobuf.Encode(response)
+	if err != nil {
+		return fmt.Errorf("error while serializing write-ahead log entry: %s", err)
+	}
+
+	return monitor.wal.Append(data)
+}
+
+// check if an address belongs to the validators given in the config
+func (monitor *Monitor) isValidator(address string) bool {
+	for _, val := range monitor.Validators {
+		if val == address {
+			return true
+		}
+	}
+	return false
+}
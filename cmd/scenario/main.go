@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/mikanikos/Fork-Accountability/scenario"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+func main() {
+
+	// parse arguments
+	scenarioFile := flag.String("scenario", "", "path (relative to the project root directory) of the scenario file to generate the config files from")
+	numValidators := flag.Int("validators", 0, "number of validators of the benchmark pattern, used if no scenario file is given")
+	numRounds := flag.Int("rounds", 0, "number of rounds of the benchmark pattern, used if no scenario file is given")
+	output := flag.String("output", "scripts", "path (relative to the project root directory) of the directory where the config files of the validators and of the monitor and the expected results are written")
+
+	// parse arguments
+	flag.Parse()
+
+	var s *scenario.Scenario
+	var err error
+	if *scenarioFile != "" {
+		s, err = scenario.Load(*scenarioFile)
+	} else {
+		s, err = scenario.Pattern(*numValidators, *numRounds)
+	}
+	if err != nil {
+		log.Fatalf("Scenario generator exiting: scenario not parsed correctly: %s", err)
+	}
+
+	configs, err := s.Generate()
+	if err != nil {
+		log.Fatalf("Scenario generator exiting: %s", err)
+	}
+
+	outputPath, err := utils.GetProjectFilePath(*output)
+	if err != nil {
+		log.Fatalf("Scenario generator exiting: %s", err)
+	}
+
+	err = configs.Write(outputPath)
+	if err != nil {
+		log.Fatalf("Scenario generator exiting: %s", err)
+	}
+
+	log.Printf("Scenario generator: config files of scenario %s written to %s", s.Name, *output)
+}
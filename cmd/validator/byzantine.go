@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/connection"
+	"go.dedis.ch/protobuf"
+)
+
+// byzantine modes of the validator toward the monitor, for testing
+const (
+	// never answer
+	silentMode = "silent"
+	// answer only the monitors given
+	selectiveMode = "selective"
+	// answer without the messages sent by the validator, hiding the votes that could incriminate it
+	tamperMode = "tamper"
+	// answer with received messages fabricated to justify the messages sent
+	fabricateMode = "fabricate"
+	// answer with different message logs on each request (honest, tampered, fabricated)
+	inconsistentMode = "inconsistent"
+	// answer with a frame larger than the maximum frame size
+	oversizedMode = "oversized"
+	// answer with a frame that can't be decoded
+	malformedMode = "malformed"
+	// close the connection in the middle of the response
+	disconnectMode = "disconnect"
+)
+
+// length declared in the frames sent in oversized mode, larger than any maximum frame size
+const oversizedFrameLength = 1 << 31
+
+// default number of senders of the messages fabricated
+const defaultFabricatedSenders = 3
+
+// time (in seconds) to wait for a corrupted frame to be written
+const rawWriteDeadline = 5
+
+// ByzantineBehaviour describes how the validator misbehaves toward the monitor
+type ByzantineBehaviour struct {
+	Mode string `yaml:"mode"`
+
+	// monitors answered in selective mode, identified by the common name of their certificate with TLS, or by their host otherwise
+	Monitors []string `yaml:"monitors"`
+
+	// maximum number of senders of each message fabricated in fabricate mode, default value used if 0
+	FabricatedSenders int `yaml:"fabricatedSenders"`
+
+	// number of requests answered so far, used in inconsistent mode
+	requests uint64
+}
+
+// check that the mode is known
+func (bb *ByzantineBehaviour) validate() error {
+	switch bb.Mode {
+	case silentMode, selectiveMode, tamperMode, fabricateMode, inconsistentMode, oversizedMode, malformedMode, disconnectMode:
+		return nil
+	}
+	return fmt.Errorf("unknown byzantine mode %s", bb.Mode)
+}
+
+// return true if the request on the given connection must be answered
+func (bb *ByzantineBehaviour) answers(conn *connection.Connection) bool {
+	switch bb.Mode {
+	case silentMode:
+		return false
+	case selectiveMode:
+		identity := monitorIdentity(conn)
+		for _, monitor := range bb.Monitors {
+			if monitor == identity {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// change the message logs in the response, before it's signed
+func (bb *ByzantineBehaviour) alter(response *connection.Packet) {
+	if response.Code != connection.HvsResponse || response.Hvs == nil {
+		return
+	}
+
+	mode := bb.Mode
+	if mode == inconsistentMode {
+		switch atomic.AddUint64(&bb.requests, 1) % 3 {
+		case 1:
+			return
+		case 2:
+			mode = tamperMode
+		case 0:
+			mode = fabricateMode
+		}
+	}
+
+	switch mode {
+	case tamperMode:
+		response.Hvs = tamper(response.Hvs)
+	case fabricateMode:
+		senders := bb.FabricatedSenders
+		if senders <= 0 {
+			senders = defaultFabricatedSenders
+		}
+		response.Hvs = fabricate(response.Hvs, response.ID, senders)
+	}
+}
+
+// send a corrupted response, if required by the mode, and return true if the response has been sent
+// corrupted frames can't be produced by the connection, so they are written directly on the underlying connection (see writeRaw)
+func (bb *ByzantineBehaviour) sendCorrupted(conn *connection.Connection, response *connection.Packet) (bool, error) {
+	switch bb.Mode {
+	case oversizedMode:
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, oversizedFrameLength)
+		return true, writeRaw(conn, append(header, []byte("oversized")...))
+
+	case malformedMode:
+		garbage := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, uint32(len(garbage)))
+		return true, writeRaw(conn, append(header, garbage...))
+
+	case disconnectMode:
+		encoded, err := protobuf.Encode(response)
+		if err != nil {
+			return true, err
+		}
+
+		// declare the whole packet, send only half of it
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, uint32(len(encoded)))
+		err = writeRaw(conn, append(header, encoded[:len(encoded)/2]...))
+		conn.Close()
+		return true, err
+	}
+
+	return false, nil
+}
+
+// write data directly on the underlying connection, bypassing the send path of the connection
+// there's no send lock, so the data could be interleaved with other frames sent concurrently on the same connection, and no fault injection or byte count
+// this is acceptable only because the response is corrupted anyway
+func writeRaw(conn *connection.Connection, data []byte) error {
+	err := conn.Conn.SetWriteDeadline(time.Now().Add(rawWriteDeadline * time.Second))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Conn.Write(data)
+	return err
+}
+
+// copy of the message logs without the messages sent
+func tamper(hvs *common.HeightVoteSet) *common.HeightVoteSet {
+	tampered := common.NewHeightVoteSet()
+
+	for round, vs := range hvs.VoteSetMap {
+		if vs == nil {
+			continue
+		}
+
+		tampered.VoteSetMap[round] = &common.VoteSet{
+			ReceivedPrevoteMessages:   vs.ReceivedPrevoteMessages,
+			ReceivedPrecommitMessages: vs.ReceivedPrecommitMessages,
+			SentPrevoteMessages:       make([]*common.Message, 0),
+			SentPrecommitMessages:     make([]*common.Message, 0),
+		}
+	}
+
+	return tampered
+}
+
+// copy of the message logs where every message sent appears to be received from up to the given number of other validators too
+// senders are chosen among the validators appearing in the message logs that didn't already send the same message, so the monitor only analyzes real processes
+func fabricate(hvs *common.HeightVoteSet, ID string, senders int) *common.HeightVoteSet {
+	fabricated := common.NewHeightVoteSet()
+	fabricated.Merge(hvs)
+
+	validators := otherValidators(hvs, ID)
+
+	for _, vs := range hvs.VoteSetMap {
+		if vs == nil {
+			continue
+		}
+
+		sent := append(append([]*common.Message{}, vs.SentPrevoteMessages...), vs.SentPrecommitMessages...)
+		for _, mes := range sent {
+			received := vs.ReceivedPrevoteMessages
+			if mes.Type == common.Precommit {
+				received = vs.ReceivedPrecommitMessages
+			}
+
+			added := 0
+			for _, validator := range validators {
+				if added == senders {
+					break
+				}
+				if hasSent(received, validator, mes.Value) {
+					continue
+				}
+				fabricated.AddReceivedMessage(common.NewMessage(mes.Type, validator, mes.Round, mes.Value, nil))
+				added++
+			}
+		}
+	}
+
+	return fabricated
+}
+
+// get the validators, other than the one with the given id, that sent at least one of the messages received, sorted
+func otherValidators(hvs *common.HeightVoteSet, ID string) []string {
+	found := make(map[string]bool)
+
+	for _, vs := range hvs.VoteSetMap {
+		if vs == nil {
+			continue
+		}
+		for _, mes := range append(append([]*common.Message{}, vs.ReceivedPrevoteMessages...), vs.ReceivedPrecommitMessages...) {
+			if mes.SenderID != ID {
+				found[mes.SenderID] = true
+			}
+		}
+	}
+
+	validators := make([]string, 0, len(found))
+	for validator := range found {
+		validators = append(validators, validator)
+	}
+	sort.Strings(validators)
+
+	return validators
+}
+
+// check if the messages contain one from the given sender for the given value
+func hasSent(messages []*common.Message, sender string, value *common.Value) bool {
+	for _, mes := range messages {
+		if mes.SenderID == sender && mes.Value.Equal(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// identity of the monitor on the other side of the connection: the common name of its certificate with TLS, its host otherwise
+func monitorIdentity(conn *connection.Connection) string {
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		certificates := tlsConn.ConnectionState().PeerCertificates
+		if len(certificates) > 0 {
+			return certificates[0].Subject.CommonName
+		}
+	}
+
+	address := conn.Conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
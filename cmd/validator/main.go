@@ -1,29 +1,83 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/mikanikos/Fork-Accountability/metrics"
 	"github.com/mikanikos/Fork-Accountability/utils"
 )
 
-const configDirectory = "/cmd/validator/_config/"
+const (
+	configDirectory = "/cmd/validator/_config/"
+
+	// time (in seconds) to answer the requests in flight when the validator is stopped
+	shutdownTimeout = 10
+)
 
 func main() {
 
 	// parse arguments
 	configFile := flag.String("config", configDirectory+"config_1.yaml", "path (relative to the project root directory) of the configuration file for the validator")
 	delay := flag.Uint64("delay", 0, "time to wait (in seconds) before replying back to the monitor, use for testing")
+	metricsAddress := flag.String("metrics", "", "address where to expose the /metrics endpoint with the statistics of the execution, disabled if empty")
+	generateKey := flag.Bool("genkey", false, "generate a new key pair to sign the responses to the monitor, print it and exit")
 
 	// parse arguments
 	flag.Parse()
 
+	// generate keys, if requested
+	if *generateKey {
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			log.Fatalf("Validator exiting: error while generating key pair: %s", err)
+		}
+
+		fmt.Printf("privateKey: %s\npublicKey: %s\n", hex.EncodeToString(privateKey.Seed()), hex.EncodeToString(publicKey))
+		return
+	}
+
 	// parse file
 	validator, err := newValidatorFromConfig(*configFile)
 	if err != nil {
 		log.Fatalf("Validator exiting: config file not parsed correctly: %s", err)
 	}
 
+	// expose statistics, if desired
+	if *metricsAddress != "" {
+		go func() {
+			err := metrics.DefaultRegistry.Serve(*metricsAddress)
+			if err != nil {
+				log.Printf("Validator %s: metrics endpoint stopped: %s", validator.ID, err)
+			}
+		}()
+	}
+
+	// stop gracefully on SIGTERM or interrupt
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+
+		log.Printf("Validator %s: received %s, shutting down", validator.ID, sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout)*time.Second)
+		defer cancel()
+
+		err := validator.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Validator %s: requests in flight not completed before shutdown: %s", validator.ID, err)
+		}
+	}()
+
 	// start validator execution
 	validator.Run(*delay)
 }
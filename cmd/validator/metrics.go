@@ -0,0 +1,14 @@
+package main
+
+import "github.com/mikanikos/Fork-Accountability/metrics"
+
+// validator statistics, exposed on the metrics endpoint if enabled
+var (
+	requestsReceived = metrics.NewCounter("validator_requests_received_total", "Number of message logs requests received from monitors")
+	responsesSent    = metrics.NewCounter("validator_responses_sent_total", "Number of message logs sent back to monitors")
+	logsMissing      = metrics.NewCounter("validator_logs_missing_total", "Number of requests for heights the validator has no message logs for")
+	errorsSent       = metrics.NewCounter("validator_errors_sent_total", "Number of error responses sent back to monitors")
+	requestsDropped  = metrics.NewCounter("validator_requests_dropped_total", "Number of requests dropped because the queue was full")
+	queueDepth       = metrics.NewGauge("validator_queue_depth", "Number of requests waiting to be handled")
+	recordsStored    = metrics.NewCounter("validator_records_stored_total", "Number of packets of messages recorded for consensus nodes")
+)
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
 	"log"
+	"net"
 	"time"
 
 	"github.com/mikanikos/Fork-Accountability/common"
 	"github.com/mikanikos/Fork-Accountability/connection"
+	"github.com/mikanikos/Fork-Accountability/store"
+	"github.com/mikanikos/Fork-Accountability/utils"
 )
 
 const debug = true
@@ -16,8 +22,50 @@ type Validator struct {
 	Address  string                           `yaml:"address"`
 	Messages map[uint64]*common.HeightVoteSet `yaml:"messages"`
 
+	// hex-encoded ed25519 private key used to sign the responses, optional
+	PrivateKey string `yaml:"privateKey"`
+
+	// maximum size (in bytes) of the frames exchanged with the monitor, default value used if 0
+	MaxFrameSize uint32 `yaml:"maxFrameSize"`
+	// maximum size (in bytes) of the message logs sent to the monitor, 16 frames if 0
+	MaxPacketSize uint64 `yaml:"maxPacketSize"`
+
+	// certificates used to accept only mutual TLS connections from the monitor, plain TCP if not given
+	TLS *connection.TLSConfig `yaml:"tls"`
+
+	// transport used to listen for requests (tcp, unix or memory), tcp if not given
+	Transport string `yaml:"transport"`
+	// scenario file (relative to the project root directory) with the network faults injected in the connections, for testing
+	Faults string `yaml:"faults"`
+
+	// maximum number of concurrent connections, no limit if 0
+	MaxConnections int `yaml:"maxConnections"`
+	// time (in seconds) after which a connection without requests is closed, default read deadline if 0
+	IdleTimeout uint64 `yaml:"idleTimeout"`
+
+	// policy applied when the queue of requests is full (block, drop or fair) and maximum number of requests queued
+	QueuePolicy string `yaml:"queuePolicy"`
+	QueueSize   int    `yaml:"queueSize"`
+
+	// append-only file (relative to the project root directory) where the message logs are stored, in memory if not given
+	// the messages given in the config are imported for the heights without message logs in the store
+	Store string `yaml:"store"`
+	// maximum number of heights whose message logs are kept in memory when they are stored in a file, default value used if 0
+	StoreCache int `yaml:"storeCache"`
+	// maximum number of rounds kept for each height, the messages of the older rounds are discarded, all kept if 0
+	RetainRounds uint64 `yaml:"retainRounds"`
+
+	// misbehaviour toward the monitor, for testing, honest if not given
+	Byzantine *ByzantineBehaviour `yaml:"byzantine"`
+
 	// server
 	server *connection.Server
+	// parsed private key
+	privateKey ed25519.PrivateKey
+	// closed when all the requests received have been handled after the shutdown
+	stopped chan struct{}
+	// message logs of the validator
+	store store.Store
 }
 
 // NewValidator creates a new validator
@@ -34,18 +82,135 @@ func (validator *Validator) Run(delay uint64) {
 		log.Printf("Validator %s at %s: start listening for incoming requests", validator.ID, validator.Address)
 	}
 
-	// handle incoming data from clients
-	go validator.handleIncomingClientData(delay)
+	listener, err := validator.start(delay)
+	if err != nil {
+		log.Fatalf("Validator %s at %s exiting: %s", validator.ID, validator.Address, err)
+	}
+
+	// accept incoming connections from monitor
+	err = validator.server.Serve(listener)
+	if err != connection.ErrServerClosed {
+		log.Fatalf("Validator %s at %s exiting: %s", validator.ID, validator.Address, err)
+	}
+
+	// wait for the requests in flight
+	<-validator.stopped
+
+	err = validator.store.Close()
+	if err != nil {
+		log.Printf("Validator %s at %s: error while closing the message logs store: %s", validator.ID, validator.Address, err)
+	}
+
+	if debug {
+		log.Printf("Validator %s at %s: stopped", validator.ID, validator.Address)
+	}
+}
+
+// RecordSent records a message sent by the validator at the given height, so that it can be sent to the monitor
+// the validator doesn't run the consensus protocol, it's called for the messages in the record packets of the consensus node
+func (validator *Validator) RecordSent(height uint64, message *common.Message) error {
+	err := validator.store.RecordSent(height, message)
+	if err != nil {
+		return err
+	}
+	return validator.pruneRounds(height)
+}
+
+// RecordReceived records a message received by the validator at the given height, so that it can be sent to the monitor
+// the validator doesn't run the consensus protocol, it's called for the messages in the record packets of the consensus node
+func (validator *Validator) RecordReceived(height uint64, message *common.Message) error {
+	err := validator.store.RecordReceived(height, message)
+	if err != nil {
+		return err
+	}
+	return validator.pruneRounds(height)
+}
+
+// Shutdown stops the validator after answering the requests already received, or when the context expires
+func (validator *Validator) Shutdown(ctx context.Context) error {
+	return validator.server.Shutdown(ctx)
+}
+
+// load the settings of the validator, start handling incoming data and listening on the validator address
+// the listener returned is ready to accept connections from the monitor
+func (validator *Validator) start(delay uint64) (net.Listener, error) {
+
+	// load the key to sign responses, if given
+	if validator.PrivateKey != "" {
+		privateKey, err := connection.ParsePrivateKey(validator.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %s", err)
+		}
+		validator.privateKey = privateKey
+	}
+
+	if validator.Byzantine != nil {
+		err := validator.Byzantine.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// open the message logs and import the ones given in the config
+	err := validator.openStore()
+	if err != nil {
+		return nil, err
+	}
+
+	validator.server.MaxFrameSize = validator.MaxFrameSize
+	validator.server.MaxPacketSize = validator.MaxPacketSize
+	validator.server.MaxConnections = validator.MaxConnections
+	validator.server.IdleTimeout = time.Duration(validator.IdleTimeout) * time.Second
+	switch validator.QueuePolicy {
+	case "", connection.BlockPolicy, connection.DropPolicy, connection.FairPolicy:
+		validator.server.QueuePolicy = validator.QueuePolicy
+	default:
+		return nil, fmt.Errorf("unknown queue policy %s", validator.QueuePolicy)
+	}
+	validator.server.QueueSize = validator.QueueSize
+	validator.server.QueueDepthGauge = queueDepth
+	validator.server.DroppedCounter = requestsDropped
+
+	// load certificates, if given
+	if validator.TLS != nil {
+		tlsConfig, err := validator.TLS.ServerConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls config: %s", err)
+		}
+		validator.server.TLSConfig = tlsConfig
+	}
+
+	transport, err := connection.GetTransport(validator.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	if validator.Faults != "" {
+		transport, err = connection.LoadFaultScenario(validator.Faults, transport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	validator.server.Transport = transport
 
 	// start listening for incoming connection from monitor
-	err := validator.server.Listen(validator.Address)
+	listener, err := transport.Listen(validator.Address)
 	if err != nil {
-		log.Fatalf("Validator %s at %s exiting: cannot listen on given address: %s", validator.ID, validator.Address, err)
+		return nil, fmt.Errorf("cannot listen on given address: %s", err)
 	}
+
+	// handle incoming data from clients
+	validator.stopped = make(chan struct{})
+	go validator.handleIncomingClientData(delay)
+
+	return listener, nil
 }
 
 // process packet from client (monitor)
 func (validator *Validator) handleIncomingClientData(delay uint64) {
+	// the channel is closed on shutdown
+	defer close(validator.stopped)
+
 	// process client data from server channel
 	for clientData := range validator.server.ReceiveChannel {
 
@@ -56,38 +221,268 @@ func (validator *Validator) handleIncomingClientData(delay uint64) {
 		packet := clientData.Packet
 		conn := clientData.Connection
 
-		// if it's a request packet, send the response back
+		// if it's a request packet, send the response (or the reason why it can't be served) back
 		if packet != nil && packet.Code == connection.HvsRequest {
 
+			requestsReceived.Inc()
+
 			if debug {
 				log.Printf("Validator %s at %s: received request for height vote set for height %d", validator.ID, validator.Address, packet.Height)
 			}
 
-			// load height vote set
-			hvs, loaded := validator.Messages[packet.Height]
+			validator.answer(packet, conn)
+		}
+
+		// if it's a record packet, store the messages of the consensus node and acknowledge them
+		if packet != nil && packet.Code == connection.HvsRecord {
+			validator.acknowledge(packet, conn)
+		}
 
-			// if validator does not have any message log for requested height, send error message
-			if hvs != nil && loaded {
+		clientData.Done()
+	}
+}
 
-				// prepare packet
-				packet.ID = validator.ID
-				packet.Code = connection.HvsResponse
-				packet.Hvs = hvs
+// send the response to a request back to the monitor, misbehaving if configured
+func (validator *Validator) answer(packet *connection.Packet, conn *connection.Connection) {
 
-				if debug {
-					log.Printf("Validator %s at %s: sending height vote set requested for height %d to monitor", validator.ID, validator.Address, packet.Height)
-				}
+	byzantine := validator.Byzantine
+	if byzantine != nil && !byzantine.answers(conn) {
+		if debug {
+			log.Printf("Validator %s at %s: not answering the request from %s (byzantine mode %s)", validator.ID, validator.Address, conn.Conn.RemoteAddr(), byzantine.Mode)
+		}
+		return
+	}
+
+	response := validator.handleRequest(packet, conn)
+	response.ID = validator.ID
+
+	if byzantine != nil {
+		byzantine.alter(response)
+	}
+
+	// sign response, if possible and if the monitor can verify it
+	if validator.privateKey != nil && conn.HasFeature(connection.FeatureSignatures) {
+		err := response.Sign(validator.privateKey)
+		if err != nil {
+			if debug {
+				log.Printf("Validator %s at %s: error while signing packet: %s", validator.ID, validator.Address, err)
+			}
+			response = connection.NewErrorPacket(packet, connection.ReasonInternalError, "response could not be signed")
+			response.ID = validator.ID
+		}
+	}
+
+	if byzantine != nil {
+		sent, err := byzantine.sendCorrupted(conn, response)
+		if sent {
+			if err != nil && debug {
+				log.Printf("Validator %s at %s: error while sending corrupted packet to monitor: %s", validator.ID, validator.Address, err)
+			}
+			return
+		}
+	}
 
-				// send response
-				err := conn.Send(packet)
-				if err != nil && debug {
-					log.Printf("Validator %s at %s: error while sending packet back to monitor: %s", validator.ID, validator.Address, err)
+	// send response
+	err := conn.Send(response)
+	if err != nil {
+		if debug {
+			log.Printf("Validator %s at %s: error while sending packet back to monitor: %s", validator.ID, validator.Address, err)
+		}
+	} else if response.Code == connection.HvsResponse {
+		responsesSent.Inc()
+	} else {
+		errorsSent.Inc()
+	}
+}
+
+// record the messages sent and received by the consensus node and tell it when they are stored
+func (validator *Validator) acknowledge(packet *connection.Packet, conn *connection.Connection) {
+
+	response := validator.record(packet, conn)
+	response.ID = validator.ID
+
+	err := conn.Send(response)
+	if err != nil && debug {
+		log.Printf("Validator %s at %s: error while sending acknowledgement back to consensus node: %s", validator.ID, validator.Address, err)
+	}
+}
+
+// record the messages in the packet, return the acknowledgement or an error response explaining why they can't be recorded
+func (validator *Validator) record(packet *connection.Packet, conn *connection.Connection) *connection.Packet {
+
+	// only the consensus node can record messages
+	if conn.PeerRole() != connection.RoleNode {
+		return connection.NewErrorPacket(packet, connection.ReasonUnauthorized, "only consensus nodes can record messages")
+	}
+
+	if packet.Hvs != nil {
+		for _, vs := range packet.Hvs.VoteSetMap {
+			if vs == nil {
+				continue
+			}
+
+			for _, messages := range [][]*common.Message{vs.SentPrevoteMessages, vs.SentPrecommitMessages} {
+				for _, message := range messages {
+					err := validator.RecordSent(packet.Height, message)
+					if err != nil {
+						return validator.recordError(packet, err)
+					}
 				}
-			} else {
-				if debug {
-					log.Printf("Validator %s at %s does not have any message logs for height %d", validator.ID, validator.Address, packet.Height)
+			}
+
+			for _, messages := range [][]*common.Message{vs.ReceivedPrevoteMessages, vs.ReceivedPrecommitMessages} {
+				for _, message := range messages {
+					err := validator.RecordReceived(packet.Height, message)
+					if err != nil {
+						return validator.recordError(packet, err)
+					}
 				}
 			}
 		}
 	}
+
+	recordsStored.Inc()
+
+	return &connection.Packet{Code: connection.HvsRecord, Height: packet.Height, RequestID: packet.RequestID}
+}
+
+// error response for messages that could not be recorded
+func (validator *Validator) recordError(packet *connection.Packet, err error) *connection.Packet {
+	if debug {
+		log.Printf("Validator %s at %s: error while recording messages for height %d: %s", validator.ID, validator.Address, packet.Height, err)
+	}
+
+	return connection.NewErrorPacket(packet, connection.ReasonInternalError, "messages could not be recorded")
+}
+
+// get the message logs requested, or an error response explaining why they can't be sent
+func (validator *Validator) handleRequest(packet *connection.Packet, conn *connection.Connection) *connection.Packet {
+
+	// only monitors can request message logs
+	if conn.PeerRole() != connection.RoleMonitor {
+		return connection.NewErrorPacket(packet, connection.ReasonUnauthorized, "only monitors can request message logs")
+	}
+
+	// load height vote set
+	hvs, err := validator.store.Get(packet.Height)
+	if err != nil {
+		if debug {
+			log.Printf("Validator %s at %s: error while loading message logs for height %d: %s", validator.ID, validator.Address, packet.Height, err)
+		}
+
+		return connection.NewErrorPacket(packet, connection.ReasonInternalError, "message logs could not be loaded")
+	}
+
+	if hvs == nil {
+		logsMissing.Inc()
+
+		if debug {
+			log.Printf("Validator %s at %s does not have any message logs for height %d", validator.ID, validator.Address, packet.Height)
+		}
+
+		return connection.NewErrorPacket(packet, connection.ReasonUnknownHeight, fmt.Sprintf("no message logs for height %d", packet.Height))
+	}
+
+	response := &connection.Packet{Code: connection.HvsResponse, Height: packet.Height, RequestID: packet.RequestID, Hvs: hvs, Filter: packet.Filter}
+
+	// send only the rounds and message types requested
+	if packet.Filter != nil {
+		prunedBelow, err := validator.store.PrunedBelow(packet.Height)
+		if err != nil {
+			if debug {
+				log.Printf("Validator %s at %s: error while loading message logs for height %d: %s", validator.ID, validator.Address, packet.Height, err)
+			}
+
+			return connection.NewErrorPacket(packet, connection.ReasonInternalError, "message logs could not be loaded")
+		}
+
+		// some of the rounds requested have been discarded, the message logs sent would be incomplete
+		if packet.Filter.FromRound < prunedBelow {
+			return connection.NewErrorPacket(packet, connection.ReasonRoundsPruned, fmt.Sprintf("message logs available from round %d", prunedBelow))
+		}
+
+		response.Hvs = hvs.Filter(packet.Filter.FromRound, packet.Filter.ToRound, packet.Filter.Types)
+	}
+
+	if debug {
+		log.Printf("Validator %s at %s: sending height vote set requested for height %d to monitor", validator.ID, validator.Address, packet.Height)
+	}
+
+	return response
+}
+
+// open the store of the message logs and import the messages given in the config for the heights not stored yet
+func (validator *Validator) openStore() error {
+	if validator.Store == "" {
+		validator.store = store.NewMemoryStore()
+	} else {
+		storePath, err := utils.GetProjectFilePath(validator.Store)
+		if err != nil {
+			return err
+		}
+
+		fileStore, err := store.OpenFileStore(storePath)
+		if err != nil {
+			return fmt.Errorf("error while opening message logs store: %s", err)
+		}
+		fileStore.CacheSize = validator.StoreCache
+		validator.store = fileStore
+	}
+
+	for height, hvs := range validator.Messages {
+		stored, err := validator.store.Get(height)
+		if err != nil {
+			return fmt.Errorf("error while loading message logs for height %d: %s", height, err)
+		}
+
+		if stored != nil || hvs == nil {
+			continue
+		}
+
+		err = validator.store.Import(height, hvs)
+		if err != nil {
+			return fmt.Errorf("error while importing message logs for height %d: %s", height, err)
+		}
+
+		err = validator.pruneRounds(height)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discard the messages of the oldest rounds of the given height, if more rounds than the ones to retain are stored
+func (validator *Validator) pruneRounds(height uint64) error {
+	if validator.RetainRounds == 0 {
+		return nil
+	}
+
+	hvs, err := validator.store.Get(height)
+	if err != nil || hvs == nil || len(hvs.VoteSetMap) == 0 {
+		return err
+	}
+
+	highest := highestRound(hvs)
+	if highest < validator.RetainRounds {
+		return nil
+	}
+
+	err = validator.store.Prune(height, highest-validator.RetainRounds+1)
+	if err != nil {
+		return fmt.Errorf("error while pruning message logs for height %d: %s", height, err)
+	}
+	return nil
+}
+
+// get the highest round of the height vote set
+func highestRound(hvs *common.HeightVoteSet) uint64 {
+	highest := uint64(0)
+	for round := range hvs.VoteSetMap {
+		if round > highest {
+			highest = round
+		}
+	}
+	return highest
 }
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mikanikos/Fork-Accountability/common"
 	"github.com/mikanikos/Fork-Accountability/connection"
 	"github.com/mikanikos/Fork-Accountability/utils"
 )
@@ -165,3 +170,444 @@ func Test_ValidatorRun(t *testing.T) {
 		t.Fatal("Validator exited unexpectedly")
 	}
 }
+
+func Test_ValidatorInMemory(t *testing.T) {
+
+	validatorTest := NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = "validator-1"
+	validatorTest.Transport = connection.Memory
+	validatorTest.Messages[1] = utils.GetHvsForDefaultConfig1()
+
+	listener, err := validatorTest.start(0)
+	if err != nil {
+		t.Fatalf("Failed to start validator: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		_ = validatorTest.server.Serve(listener)
+	}()
+
+	// the validator is reachable as soon as it started
+	connClient, err := connection.Dial(connection.DefaultMemoryTransport, validatorTest.Address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	defer connClient.Close()
+
+	err = connClient.Send(&connection.Packet{Code: connection.HvsRequest, Height: 1, Filter: &connection.Filter{FromRound: 3, ToRound: 3}})
+	if err != nil {
+		t.Fatalf("Failed to send packet on client: %s", err)
+	}
+
+	packet, err := connClient.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive packet: %s", err)
+	}
+
+	// only the round requested is sent
+	if packet.Code != connection.HvsResponse || packet.ID != "1" || len(packet.Hvs.VoteSetMap) != 1 || packet.Hvs.VoteSetMap[3] == nil {
+		t.Fatal("Validator did not answer with the message logs requested")
+	}
+}
+
+func Test_ValidatorShutdown(t *testing.T) {
+
+	validatorTest := NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = "validator-shutdown"
+	validatorTest.Transport = connection.Memory
+	validatorTest.Messages[1] = utils.GetHvsForDefaultConfig1()
+
+	stopped := make(chan struct{})
+	go func() {
+		validatorTest.Run(0)
+		close(stopped)
+	}()
+
+	// wait until the validator is reachable
+	var connClient *connection.Connection
+	var err error
+	for i := 0; i < 100; i++ {
+		connClient, err = connection.Dial(connection.DefaultMemoryTransport, validatorTest.Address, nil)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	defer connClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = validatorTest.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Failed to shut down validator: %s", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Validator still running after shutdown")
+	}
+
+	// the connection has been closed by the validator
+	_, err = connClient.Receive()
+	if err == nil {
+		t.Fatal("Connection still open after shutdown")
+	}
+}
+
+func Test_ValidatorErrorResponses(t *testing.T) {
+
+	validatorTest := NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = "validator-errors"
+	validatorTest.Transport = connection.Memory
+	validatorTest.Messages[1] = utils.GetHvsForDefaultConfig1()
+
+	// only the last two rounds are kept, the messages of the rounds before 2 are discarded
+	validatorTest.RetainRounds = 2
+
+	listener, err := validatorTest.start(0)
+	if err != nil {
+		t.Fatalf("Failed to start validator: %s", err)
+	}
+	defer validatorTest.Shutdown(context.Background())
+
+	go func() {
+		_ = validatorTest.server.Serve(listener)
+	}()
+
+	connClient, err := connection.Dial(connection.DefaultMemoryTransport, validatorTest.Address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	defer connClient.Close()
+
+	requests := []struct {
+		reason  uint32
+		request *connection.Packet
+	}{
+		{connection.ReasonUnknownHeight, &connection.Packet{Code: connection.HvsRequest, Height: 2}},
+		{connection.ReasonRoundsPruned, &connection.Packet{Code: connection.HvsRequest, Height: 1, Filter: &connection.Filter{FromRound: 0, ToRound: 1}}},
+		// the rounds stored can't be sent without the ones pruned
+		{connection.ReasonRoundsPruned, &connection.Packet{Code: connection.HvsRequest, Height: 1, Filter: &connection.Filter{FromRound: 1, ToRound: 4}}},
+	}
+
+	for _, test := range requests {
+		reason, request := test.reason, test.request
+		err = connClient.Send(request)
+		if err != nil {
+			t.Fatalf("Failed to send packet on client: %s", err)
+		}
+
+		packet, err := connClient.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive packet: %s", err)
+		}
+
+		if packet.Code != connection.Error || packet.Reason != reason || packet.ID != "1" {
+			t.Fatalf("Validator did not answer with the error %s", connection.ReasonString(reason))
+		}
+	}
+
+	// the rounds not discarded are sent even if the message logs start later
+	request := &connection.Packet{Code: connection.HvsRequest, Height: 1, Filter: &connection.Filter{FromRound: 2, ToRound: 4}}
+	err = connClient.Send(request)
+	if err != nil {
+		t.Fatalf("Failed to send packet on client: %s", err)
+	}
+
+	packet, err := connClient.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive packet: %s", err)
+	}
+
+	if packet.Code != connection.HvsResponse || !reflect.DeepEqual(packet.Hvs, utils.GetHvsForDefaultConfig1()) {
+		t.Fatal("Validator did not send the rounds stored")
+	}
+}
+
+func Test_ValidatorSignatures(t *testing.T) {
+
+	validatorTest := NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = "validator-signatures"
+	validatorTest.Transport = connection.Memory
+	validatorTest.PrivateKey = strings.Repeat("01", ed25519.SeedSize)
+	validatorTest.Messages[1] = utils.GetHvsForDefaultConfig1()
+
+	listener, err := validatorTest.start(0)
+	if err != nil {
+		t.Fatalf("Failed to start validator: %s", err)
+	}
+	defer validatorTest.Shutdown(context.Background())
+
+	go func() {
+		_ = validatorTest.server.Serve(listener)
+	}()
+
+	defer func(supported []string) {
+		connection.SupportedFeatures = supported
+	}(connection.SupportedFeatures)
+
+	// responses are signed only if signatures have been negotiated
+	for _, signatures := range []bool{true, false} {
+		connection.SupportedFeatures = []string{connection.FeatureStreaming}
+		if signatures {
+			connection.SupportedFeatures = append(connection.SupportedFeatures, connection.FeatureSignatures)
+		}
+
+		connClient, err := connection.Dial(connection.DefaultMemoryTransport, validatorTest.Address, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect to validator: %s", err)
+		}
+
+		packet, err := requestWithTimeout(connClient)
+		connClient.Close()
+		if err != nil {
+			t.Fatalf("Failed to receive packet: %s", err)
+		}
+
+		if packet.VerifySignature(validatorTest.privateKey.Public().(ed25519.PublicKey)) != signatures {
+			t.Fatalf("Wrong signature of the response when signatures are negotiated: %t", signatures)
+		}
+	}
+}
+
+func Test_ValidatorStore(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	// the store path is relative to the project root
+	storePath, err := filepath.Rel(projectRoot(t), filepath.Join(directory, "validator.store"))
+	if err != nil {
+		t.Fatalf("Failed to get store path: %s", err)
+	}
+
+	validatorTest := NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = "validator-store"
+	validatorTest.Transport = connection.Memory
+	validatorTest.Store = storePath
+	validatorTest.Messages[1] = utils.GetHvsForDefaultConfig1()
+
+	listener, err := validatorTest.start(0)
+	if err != nil {
+		t.Fatalf("Failed to start validator: %s", err)
+	}
+
+	go func() {
+		_ = validatorTest.server.Serve(listener)
+	}()
+
+	// the consensus node records the messages of a new height while running
+	records := common.NewHeightVoteSet()
+	records.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(1), nil))
+	records.AddReceivedMessage(common.NewMessage(common.Prevote, "2", 0, common.NewValue(1), nil))
+
+	for role, code := range map[string]uint32{connection.RoleMonitor: connection.Error, connection.RoleNode: connection.HvsRecord} {
+		connNode, err := connection.DialAs(connection.DefaultMemoryTransport, validatorTest.Address, nil, role)
+		if err != nil {
+			t.Fatalf("Failed to connect to validator: %s", err)
+		}
+
+		_ = connNode.Send(&connection.Packet{Code: connection.HvsRecord, Height: 2, Hvs: records})
+
+		packet, err := connNode.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive packet: %s", err)
+		}
+		connNode.Close()
+
+		// only consensus nodes can record messages
+		if packet.Code != code {
+			t.Fatalf("Wrong answer to the messages recorded by a %s: %d", role, packet.Code)
+		}
+	}
+
+	_ = validatorTest.Shutdown(context.Background())
+	_ = validatorTest.store.Close()
+
+	// after a restart, the message logs of both heights are served from the store, without importing the config again
+	validatorTest = NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = "validator-store"
+	validatorTest.Transport = connection.Memory
+	validatorTest.Store = storePath
+
+	listener, err = validatorTest.start(0)
+	if err != nil {
+		t.Fatalf("Failed to restart validator: %s", err)
+	}
+	defer validatorTest.Shutdown(context.Background())
+
+	go func() {
+		_ = validatorTest.server.Serve(listener)
+	}()
+
+	connClient, err := connection.Dial(connection.DefaultMemoryTransport, validatorTest.Address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	defer connClient.Close()
+
+	for height, rounds := range map[uint64]int{1: len(utils.GetHvsForDefaultConfig1().VoteSetMap), 2: 1} {
+		_ = connClient.Send(&connection.Packet{Code: connection.HvsRequest, Height: height})
+
+		packet, err := connClient.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive packet: %s", err)
+		}
+
+		if packet.Code != connection.HvsResponse || len(packet.Hvs.VoteSetMap) != rounds {
+			t.Fatalf("Message logs for height %d not restored", height)
+		}
+	}
+}
+
+// get the absolute path of the project root directory
+func projectRoot(t *testing.T) string {
+	root, err := utils.GetProjectFilePath("")
+	if err != nil {
+		t.Fatalf("Failed to get project root: %s", err)
+	}
+	return root
+}
+
+// start a validator with the given byzantine behaviour on the memory transport and connect to it
+func startByzantineValidator(t *testing.T, address string, behaviour *ByzantineBehaviour) *connection.Connection {
+	validatorTest := NewValidator()
+	validatorTest.ID = "1"
+	validatorTest.Address = address
+	validatorTest.Transport = connection.Memory
+	validatorTest.Messages[1] = utils.GetHvsForDefaultConfig1()
+	validatorTest.Byzantine = behaviour
+
+	listener, err := validatorTest.start(0)
+	if err != nil {
+		t.Fatalf("Failed to start validator: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = validatorTest.Shutdown(context.Background())
+	})
+
+	go func() {
+		_ = validatorTest.server.Serve(listener)
+	}()
+
+	connClient, err := connection.Dial(connection.DefaultMemoryTransport, address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to validator: %s", err)
+	}
+	t.Cleanup(connClient.Close)
+
+	return connClient
+}
+
+// request the message logs for height 1 and wait for the response for a short time
+func requestWithTimeout(connClient *connection.Connection) (*connection.Packet, error) {
+	err := connClient.Send(&connection.Packet{Code: connection.HvsRequest, Height: 1})
+	if err != nil {
+		return nil, err
+	}
+	return connClient.ReceiveWithDeadline(time.Now().Add(300 * time.Millisecond))
+}
+
+// count the messages sent and received in a height vote set
+func countMessages(hvs *common.HeightVoteSet) (int, int) {
+	sent, received := 0, 0
+	for _, vs := range hvs.VoteSetMap {
+		sent += len(vs.SentPrevoteMessages) + len(vs.SentPrecommitMessages)
+		received += len(vs.ReceivedPrevoteMessages) + len(vs.ReceivedPrecommitMessages)
+	}
+	return sent, received
+}
+
+func Test_ValidatorByzantineModes(t *testing.T) {
+
+	honestSent, honestReceived := countMessages(utils.GetHvsForDefaultConfig1())
+
+	// no response
+	for mode, behaviour := range map[string]*ByzantineBehaviour{
+		silentMode:    {Mode: silentMode},
+		selectiveMode: {Mode: selectiveMode, Monitors: []string{"another monitor"}},
+	} {
+		connClient := startByzantineValidator(t, "validator-byzantine-"+mode, behaviour)
+		if _, err := requestWithTimeout(connClient); err == nil {
+			t.Fatalf("Validator in %s mode should not answer", mode)
+		}
+	}
+
+	// the monitors given are answered in selective mode (clients of the memory transport have host "client")
+	connClient := startByzantineValidator(t, "validator-byzantine-answered", &ByzantineBehaviour{Mode: selectiveMode, Monitors: []string{"client"}})
+	if packet, err := requestWithTimeout(connClient); err != nil || packet.Code != connection.HvsResponse {
+		t.Fatal("Validator in selective mode should answer the monitors given")
+	}
+
+	// tampered logs
+	connClient = startByzantineValidator(t, "validator-byzantine-tamper", &ByzantineBehaviour{Mode: tamperMode})
+	packet, err := requestWithTimeout(connClient)
+	if err != nil {
+		t.Fatalf("Failed to receive response: %s", err)
+	}
+	if sent, received := countMessages(packet.Hvs); sent != 0 || received != honestReceived || !packet.Hvs.IsValid("1") {
+		t.Fatal("Messages sent should have been removed from the message logs")
+	}
+
+	// fabricated logs
+	connClient = startByzantineValidator(t, "validator-byzantine-fabricate", &ByzantineBehaviour{Mode: fabricateMode, FabricatedSenders: 2})
+	packet, err = requestWithTimeout(connClient)
+	if err != nil {
+		t.Fatalf("Failed to receive response: %s", err)
+	}
+	if sent, received := countMessages(packet.Hvs); sent != honestSent || received <= honestReceived || !packet.Hvs.IsValid("1") {
+		t.Fatal("Received messages should have been fabricated")
+	}
+	// the messages are fabricated from the other validators, not from made up processes
+	validators := otherValidators(utils.GetHvsForDefaultConfig1(), "1")
+	if senders := otherValidators(packet.Hvs, "1"); !reflect.DeepEqual(senders, validators) {
+		t.Fatalf("Messages fabricated from %v instead of the validators %v", senders, validators)
+	}
+
+	// different logs on each request
+	connClient = startByzantineValidator(t, "validator-byzantine-inconsistent", &ByzantineBehaviour{Mode: inconsistentMode})
+	responses := make([]*connection.Packet, 3)
+	for i := range responses {
+		responses[i], err = requestWithTimeout(connClient)
+		if err != nil {
+			t.Fatalf("Failed to receive response: %s", err)
+		}
+	}
+	if reflect.DeepEqual(responses[0].Hvs, responses[1].Hvs) || reflect.DeepEqual(responses[1].Hvs, responses[2].Hvs) {
+		t.Fatal("Validator in inconsistent mode should send different message logs on each request")
+	}
+
+	// corrupted packets
+	for mode, expected := range map[string]string{
+		oversizedMode:  "exceeds the maximum frame size",
+		malformedMode:  "deserializing",
+		disconnectMode: "unexpected EOF",
+	} {
+		connClient := startByzantineValidator(t, "validator-byzantine-"+mode, &ByzantineBehaviour{Mode: mode})
+		if _, err := requestWithTimeout(connClient); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Validator in %s mode should send a corrupted packet: %s", mode, err)
+		}
+	}
+
+	// unknown modes are rejected
+	validatorTest := NewValidator()
+	validatorTest.Byzantine = &ByzantineBehaviour{Mode: "lying"}
+	if _, err := validatorTest.start(0); err == nil {
+		t.Fatal("Unknown byzantine mode should be rejected")
+	}
+}
@@ -0,0 +1,278 @@
+package cometbft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+const samplesDirectory = "/cometbft/_samples/"
+
+// address of the validator not in the ids file, used as id
+const unknownAddress = "404142434445464748494A4B4C4D4E4F50515253"
+
+func loadSampleValidators(t *testing.T) *ValidatorSet {
+	validators := &ValidatorSet{}
+	err := utils.ParseConfigFile(samplesDirectory+"ids.yaml", validators)
+	if err != nil {
+		t.Fatalf("Failed to parse ids file: %s", err)
+	}
+	return validators
+}
+
+func newSampleImporter(t *testing.T) *Importer {
+	return NewImporter(loadSampleValidators(t).IDs)
+}
+
+func importSample(t *testing.T, file string) *Importer {
+	path, err := utils.GetProjectFilePath(samplesDirectory + file)
+	if err != nil {
+		t.Fatalf("Failed to get sample path: %s", err)
+	}
+
+	importer := newSampleImporter(t)
+	err = importer.ImportFile(path)
+	if err != nil {
+		t.Fatalf("Failed to import %s: %s", file, err)
+	}
+	return importer
+}
+
+// message logs of the node in the sample files
+func expectedSampleLogs() map[uint64]*common.HeightVoteSet {
+	hash, _ := hex.DecodeString("0A1B2C3D4E5F60718293A4B5C6D7E8F90A1B2C3D4E5F60718293A4B5C6D7E8F9")
+	value := ValueFromBlockID(hash)
+
+	hvs1 := common.NewHeightVoteSet()
+	hvs1.AddMessage(common.NewMessage(common.Prevote, "1", 0, value, nil))
+	hvs1.AddMessage(common.NewMessage(common.Precommit, "1", 0, value, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Prevote, "1", 0, value, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Prevote, "2", 0, value, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Prevote, "3", 0, value, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Prevote, unknownAddress, 0, nil, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Precommit, "1", 0, value, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Precommit, "2", 0, value, nil))
+	hvs1.AddReceivedMessage(common.NewMessage(common.Precommit, "3", 0, value, nil))
+
+	hvs2 := common.NewHeightVoteSet()
+	hvs2.AddReceivedMessage(common.NewMessage(common.Prevote, "2", 0, value, nil))
+	hvs2.AddMessage(common.NewMessage(common.Prevote, "1", 1, nil, nil))
+	hvs2.AddReceivedMessage(common.NewMessage(common.Prevote, "1", 1, nil, nil))
+
+	return map[uint64]*common.HeightVoteSet{1: hvs1, 2: hvs2}
+}
+
+// check the message logs imported from the sample files
+func checkSampleLogs(t *testing.T, importer *Importer) {
+	if importer.OwnerID() != "1" {
+		t.Fatalf("Wrong owner id: %s", importer.OwnerID())
+	}
+
+	if !reflect.DeepEqual(importer.Heights(), []uint64{1, 2}) {
+		t.Fatalf("Wrong heights imported: %v", importer.Heights())
+	}
+
+	for height, expected := range expectedSampleLogs() {
+		hvs := importer.HeightVoteSet(height)
+		if !reflect.DeepEqual(hvs, expected) {
+			t.Fatalf("Wrong message logs for height %d:\n%s\nexpected:\n%s", height, hvs, expected)
+		}
+
+		if !hvs.IsValid(importer.OwnerID()) {
+			t.Fatalf("Invalid message logs for height %d", height)
+		}
+	}
+}
+
+func TestImporter_WAL(t *testing.T) {
+	checkSampleLogs(t, importSample(t, "node1.wal"))
+}
+
+func TestImporter_JSON(t *testing.T) {
+	checkSampleLogs(t, importSample(t, "node1.json"))
+}
+
+func TestImporter_SignedVotes(t *testing.T) {
+	fromWAL := importSample(t, "node1.wal").SignedVotes()
+	fromJSON := importSample(t, "node1.json").SignedVotes()
+
+	if len(fromWAL) != 9 || !reflect.DeepEqual(fromWAL, fromJSON) {
+		t.Fatalf("Signed votes imported from WAL and JSON dump differ:\n%v\n%v", fromWAL, fromJSON)
+	}
+
+	first := fromWAL[0]
+	if first.ValidatorIndex != 0 || string(first.Signature) != "signature" || first.PartSetTotal != 1 || first.Timestamp.Nanosecond() != 500 {
+		t.Fatalf("Wrong signed vote imported: %+v", first)
+	}
+}
+
+func TestImporter_CorruptedWAL(t *testing.T) {
+	path, err := utils.GetProjectFilePath(samplesDirectory + "node1.wal")
+	if err != nil {
+		t.Fatalf("Failed to get sample path: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read sample: %s", err)
+	}
+
+	// flip a bit in the data of the first record
+	data[walHeaderSize] ^= 1
+
+	err = newSampleImporter(t).ImportWAL(bytes.NewReader(data))
+	if err == nil {
+		t.Fatal("Importing a corrupted WAL should fail")
+	}
+}
+
+func TestImporter_VotesOfSeveralNodes(t *testing.T) {
+	importer := NewImporter(nil)
+
+	err := importer.addVote(&vote{SignedVote: SignedVote{Type: prevoteType, Height: 1, ValidatorAddress: []byte{1}}})
+	if err != nil {
+		t.Fatalf("Failed to add vote: %s", err)
+	}
+
+	err = importer.addVote(&vote{SignedVote: SignedVote{Type: prevoteType, Height: 1, ValidatorAddress: []byte{2}}})
+	if err == nil {
+		t.Fatal("Importing votes cast by different nodes should fail")
+	}
+}
+
+func TestValueFromBlockID(t *testing.T) {
+	if ValueFromBlockID(nil) != nil {
+		t.Fatal("Votes for no block should have no value")
+	}
+
+	if ValueFromBlockID([]byte{1}).Data != 1<<56 {
+		t.Fatalf("Wrong value for short hash: %s", ValueFromBlockID([]byte{1}))
+	}
+
+	if !ValueFromBlockID([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}).Equal(ValueFromBlockID([]byte{1, 2, 3, 4, 5, 6, 7, 8, 10})) {
+		t.Fatal("Value should depend only on the first 8 bytes of the hash")
+	}
+}
+
+// validator set of the default scenario, with ids from 1 to 4
+func defaultValidatorSet() *ValidatorSet {
+	return &ValidatorSet{
+		IDs: map[string]string{
+			"0101010101010101010101010101010101010101": "1",
+			"0202020202020202020202020202020202020202": "2",
+			"0303030303030303030303030303030303030303": "3",
+			"0404040404040404040404040404040404040404": "4",
+		},
+		Powers:  map[string]int64{"3": 10, "4": 20},
+		PubKeys: map[string]string{"4": "BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ="},
+	}
+}
+
+// run the accountability algorithm on the default scenario, where 3 and 4 sent two prevotes in round 3
+func runDefaultScenario() *accountability.Accountability {
+	acc := accountability.NewAccountability()
+	acc.Init(4, false)
+
+	acc.StoreHvs("1", utils.GetHvsForDefaultConfig1WithNoJustifications())
+	acc.StoreHvs("2", utils.GetHvsForDefaultConfig2WithNoJustifications())
+	acc.StoreHvs("3", utils.GetHvsForDefaultConfig3WithNoJustifications())
+	acc.StoreHvs("4", utils.GetHvsForDefaultConfig4WithNoJustifications())
+
+	acc.Run(3, 4)
+	return acc
+}
+
+func TestExporter_Export(t *testing.T) {
+	exporter := NewExporter(defaultValidatorSet())
+
+	blockTime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	address, _ := hex.DecodeString("0303030303030303030303030303030303030303")
+
+	// only the votes of 3 are signed
+	signedVote := func(blockHash []byte, signature string) *SignedVote {
+		return &SignedVote{
+			Type:             prevoteType,
+			Height:           1,
+			Round:            3,
+			BlockHash:        blockHash,
+			PartSetTotal:     1,
+			PartSetHash:      []byte{4, 5, 6},
+			Timestamp:        blockTime.Add(-time.Second),
+			ValidatorAddress: address,
+			ValidatorIndex:   1,
+			Signature:        []byte(signature),
+		}
+	}
+	exporter.AddSignedVotes([]*SignedVote{
+		signedVote([]byte{0, 0, 0, 0, 0, 0, 0, 20, 1, 2, 3}, "second"),
+		signedVote([]byte{0, 0, 0, 0, 0, 0, 0, 10, 1, 2, 3}, "first"),
+	})
+
+	// the time of the block is required
+	if _, err := exporter.Export(1, runDefaultScenario()); err == nil {
+		t.Fatal("Exporting evidence without the time of the block should fail")
+	}
+
+	exporter.BlockTime = blockTime
+	evidenceList, err := exporter.Export(1, runDefaultScenario())
+	if err != nil {
+		t.Fatalf("Failed to export evidence: %s", err)
+	}
+
+	// the equivocation of 4 can't be verified without its signed votes
+	if len(evidenceList) != 2 || evidenceList[0].Type != DuplicateVoteEvidenceType || evidenceList[1].Type != LightClientAttackEvidenceType {
+		t.Fatalf("Wrong evidence exported: %v", evidenceList)
+	}
+	if len(exporter.Skipped) != 1 || exporter.Skipped[0].ProcessID != "4" {
+		t.Fatalf("Equivocation of 4 should have been skipped: %v", exporter.Skipped)
+	}
+
+	// votes are ordered by block id and have the original hash and signature
+	duplicate := evidenceList[0].Value.(*DuplicateVoteEvidence)
+	if duplicate.VoteA.BlockID.Hash != "000000000000000A010203" || string(duplicate.VoteA.Signature) != "first" {
+		t.Fatalf("Wrong first vote: %+v", duplicate.VoteA)
+	}
+	if duplicate.VoteB.BlockID.Hash != "0000000000000014010203" || string(duplicate.VoteB.Signature) != "second" || duplicate.VoteB.ValidatorIndex != 1 {
+		t.Fatalf("Wrong second vote: %+v", duplicate.VoteB)
+	}
+	if duplicate.VoteA.ValidatorAddress != "0303030303030303030303030303030303030303" || duplicate.ValidatorPower != 10 || duplicate.TotalVotingPower != 32 || !duplicate.Timestamp.Equal(blockTime) {
+		t.Fatalf("Wrong duplicate vote evidence: %+v", duplicate)
+	}
+
+	// validators are sorted by voting power
+	attack := evidenceList[1].Value.(*LightClientAttackEvidence)
+	if attack.CommonHeight != 1 || len(attack.ByzantineValidators) != 2 || attack.TotalVotingPower != 32 {
+		t.Fatalf("Wrong light client attack evidence: %+v", attack)
+	}
+	if attack.ByzantineValidators[0].Address != "0404040404040404040404040404040404040404" || attack.ByzantineValidators[0].PubKey == nil || attack.ByzantineValidators[1].PubKey != nil {
+		t.Fatalf("Wrong byzantine validators: %+v %+v", attack.ByzantineValidators[0], attack.ByzantineValidators[1])
+	}
+
+	// integers are encoded as strings as in CometBFT
+	encoded, err := json.Marshal(evidenceList[0])
+	if err != nil {
+		t.Fatalf("Failed to encode evidence: %s", err)
+	}
+	if !strings.Contains(string(encoded), `"height":"1"`) || !strings.Contains(string(encoded), `"TotalVotingPower":"32"`) {
+		t.Fatalf("Wrong JSON encoding of evidence: %s", encoded)
+	}
+}
+
+func TestExporter_UnknownValidator(t *testing.T) {
+	exporter := NewExporter(&ValidatorSet{})
+	exporter.BlockTime = time.Now()
+
+	_, err := exporter.Export(1, runDefaultScenario())
+	if err == nil {
+		t.Fatal("Exporting evidence for validators without address should fail")
+	}
+}
@@ -0,0 +1,288 @@
+package cometbft
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/common"
+)
+
+// types of the evidence of CometBFT
+const (
+	DuplicateVoteEvidenceType     = "tendermint/DuplicateVoteEvidence"
+	LightClientAttackEvidenceType = "tendermint/LightClientAttackEvidence"
+
+	ed25519PubKeyType = "tendermint/PubKeyEd25519"
+)
+
+// Evidence is a piece of evidence in the JSON encoding of CometBFT, that can be submitted to the chain (e.g. with the broadcast_evidence endpoint)
+type Evidence struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DuplicateVoteEvidence proves that a validator signed two conflicting votes in the same round
+type DuplicateVoteEvidence struct {
+	VoteA            *EvidenceVote `json:"vote_a"`
+	VoteB            *EvidenceVote `json:"vote_b"`
+	TotalVotingPower int64         `json:"TotalVotingPower,string"`
+	ValidatorPower   int64         `json:"ValidatorPower,string"`
+	Timestamp        time.Time     `json:"Timestamp"`
+}
+
+// LightClientAttackEvidence describes the fork as a whole, with the validators responsible for it
+// the conflicting block is not known to the monitor and must be added before submitting the evidence
+type LightClientAttackEvidence struct {
+	ConflictingBlock    interface{}           `json:"conflicting_block"`
+	CommonHeight        int64                 `json:"common_height,string"`
+	ByzantineValidators []*ByzantineValidator `json:"byzantine_validators"`
+	TotalVotingPower    int64                 `json:"total_voting_power,string"`
+	Timestamp           time.Time             `json:"timestamp"`
+}
+
+// EvidenceVote is a vote in the JSON encoding of CometBFT
+type EvidenceVote struct {
+	Type             uint64       `json:"type"`
+	Height           int64        `json:"height,string"`
+	Round            int32        `json:"round"`
+	BlockID          *BlockIDJSON `json:"block_id"`
+	Timestamp        time.Time    `json:"timestamp"`
+	ValidatorAddress string       `json:"validator_address"`
+	ValidatorIndex   int32        `json:"validator_index"`
+	Signature        []byte       `json:"signature"`
+}
+
+// BlockIDJSON is a block id in the JSON encoding of CometBFT
+type BlockIDJSON struct {
+	Hash  string `json:"hash"`
+	Parts struct {
+		Total uint32 `json:"total"`
+		Hash  string `json:"hash"`
+	} `json:"parts"`
+}
+
+// ByzantineValidator is a validator responsible for the fork, in the JSON encoding of CometBFT
+type ByzantineValidator struct {
+	Address          string      `json:"address"`
+	PubKey           *PubKeyJSON `json:"pub_key"`
+	VotingPower      int64       `json:"voting_power,string"`
+	ProposerPriority int64       `json:"proposer_priority,string"`
+}
+
+// PubKeyJSON is a public key in the JSON encoding of CometBFT
+type PubKeyJSON struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Exporter converts the faults detected by the accountability algorithm into CometBFT evidence
+// votes are taken from the signed votes recorded by the nodes, so that the evidence can be verified by the chain
+type Exporter struct {
+	Validators *ValidatorSet
+
+	// time of the block at the height of the fork, required by the chain to accept the evidence
+	BlockTime time.Time
+
+	// equivocations skipped in the last export because the signed votes are missing
+	Skipped []*accountability.Equivocation
+
+	// signed votes indexed by height, address, type, round and value
+	signedVotes map[string]*SignedVote
+}
+
+// MissingSignedVoteError is returned when the signed vote of a message is not known, so the vote can't be put in the evidence
+type MissingSignedVoteError struct {
+	ProcessID string
+	Type      common.MessageType
+	Round     uint64
+	Value     *common.Value
+}
+
+func (err *MissingSignedVoteError) Error() string {
+	return fmt.Sprintf("error while exporting evidence: signed %s of %s for value %s in round %d not found", err.Type, err.ProcessID, err.Value, err.Round)
+}
+
+// NewExporter creates a new Exporter for the given validator set
+func NewExporter(validators *ValidatorSet) *Exporter {
+	return &Exporter{
+		Validators:  validators,
+		signedVotes: make(map[string]*SignedVote),
+	}
+}
+
+// AddSignedVotes adds the votes recorded by a node, so that evidence contains the original signatures
+func (exp *Exporter) AddSignedVotes(votes []*SignedVote) {
+	for _, v := range votes {
+		exp.signedVotes[signedVoteKey(v.Height, v.ValidatorAddress, v.Type, v.Round, ValueFromBlockID(v.BlockHash))] = v
+	}
+}
+
+// Export returns a DuplicateVoteEvidence for each equivocation detected in the last run of the algorithm, followed by a LightClientAttackEvidence for the fork, if faulty processes have been detected
+// equivocations without the signed votes can't be verified by the chain, so they are skipped and added to Skipped
+func (exp *Exporter) Export(height uint64, acc *accountability.Accountability) ([]*Evidence, error) {
+	if exp.BlockTime.IsZero() {
+		return nil, fmt.Errorf("error while exporting evidence: the time of the block at height %d is required", height)
+	}
+
+	evidenceList := make([]*Evidence, 0)
+	exp.Skipped = make([]*accountability.Equivocation, 0)
+
+	for _, equivocation := range acc.GetEquivocations() {
+		evidence, err := exp.DuplicateVoteEvidence(height, equivocation)
+		if _, missing := err.(*MissingSignedVoteError); missing {
+			exp.Skipped = append(exp.Skipped, equivocation)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		evidenceList = append(evidenceList, evidence)
+	}
+
+	faulty := acc.GetFaultyProcesses()
+	if len(faulty) > 0 {
+		evidence, err := exp.LightClientAttackEvidence(height, faulty)
+		if err != nil {
+			return nil, err
+		}
+		evidenceList = append(evidenceList, evidence)
+	}
+
+	return evidenceList, nil
+}
+
+// DuplicateVoteEvidence converts an equivocation into a DuplicateVoteEvidence with the first two conflicting votes, ordered by block id as in CometBFT
+// a MissingSignedVoteError is returned if the signed votes are not known
+func (exp *Exporter) DuplicateVoteEvidence(height uint64, equivocation *accountability.Equivocation) (*Evidence, error) {
+	if len(equivocation.Messages) < 2 {
+		return nil, fmt.Errorf("error while exporting evidence: equivocation of %s in round %d without conflicting votes", equivocation.ProcessID, equivocation.Round)
+	}
+
+	votes := make([]*EvidenceVote, 0, 2)
+	for _, message := range equivocation.Messages[:2] {
+		v, err := exp.evidenceVote(height, message)
+		if err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+
+	sort.Slice(votes, func(i, j int) bool {
+		return compareBlockIDs(votes[i].BlockID, votes[j].BlockID) < 0
+	})
+
+	return &Evidence{
+		Type: DuplicateVoteEvidenceType,
+		Value: &DuplicateVoteEvidence{
+			VoteA:            votes[0],
+			VoteB:            votes[1],
+			TotalVotingPower: exp.Validators.totalPower(),
+			ValidatorPower:   exp.Validators.power(equivocation.ProcessID),
+			Timestamp:        exp.BlockTime,
+		},
+	}, nil
+}
+
+// LightClientAttackEvidence describes the fork at the given height with the validators responsible for it
+// the common height is the height of the fork, as for equivocation and amnesia attacks in CometBFT
+func (exp *Exporter) LightClientAttackEvidence(height uint64, faulty []string) (*Evidence, error) {
+	addresses := make(map[string][]byte)
+	ids := make([]string, 0, len(faulty))
+
+	for _, id := range faulty {
+		address, err := exp.Validators.address(id)
+		if err != nil {
+			return nil, fmt.Errorf("error while exporting evidence: %s", err)
+		}
+		addresses[id] = address
+		ids = append(ids, id)
+	}
+
+	// validators are sorted by voting power as in CometBFT
+	exp.Validators.sort(ids, addresses)
+
+	byzantine := make([]*ByzantineValidator, 0, len(ids))
+	for _, id := range ids {
+		validator := &ByzantineValidator{
+			Address:     encodeHex(addresses[id]),
+			VotingPower: exp.Validators.power(id),
+		}
+
+		if pubKey, loaded := exp.Validators.PubKeys[id]; loaded {
+			if _, err := base64.StdEncoding.DecodeString(pubKey); err != nil {
+				return nil, fmt.Errorf("error while exporting evidence: invalid public key of validator %s: %s", id, err)
+			}
+			validator.PubKey = &PubKeyJSON{Type: ed25519PubKeyType, Value: pubKey}
+		}
+
+		byzantine = append(byzantine, validator)
+	}
+
+	return &Evidence{
+		Type: LightClientAttackEvidenceType,
+		Value: &LightClientAttackEvidence{
+			CommonHeight:        int64(height),
+			ByzantineValidators: byzantine,
+			TotalVotingPower:    exp.Validators.totalPower(),
+			Timestamp:           exp.BlockTime,
+		},
+	}, nil
+}
+
+// convert a message into a vote, with the original signature
+func (exp *Exporter) evidenceVote(height uint64, message *common.Message) (*EvidenceVote, error) {
+	address, err := exp.Validators.address(message.SenderID)
+	if err != nil {
+		return nil, fmt.Errorf("error while exporting evidence: %s", err)
+	}
+
+	voteType := uint64(prevoteType)
+	if message.Type == common.Precommit {
+		voteType = precommitType
+	}
+
+	signed, loaded := exp.signedVotes[signedVoteKey(height, address, voteType, message.Round, message.Value)]
+	if !loaded {
+		return nil, &MissingSignedVoteError{ProcessID: message.SenderID, Type: message.Type, Round: message.Round, Value: message.Value}
+	}
+
+	v := &EvidenceVote{
+		Type:             voteType,
+		Height:           int64(height),
+		Round:            int32(message.Round),
+		BlockID:          &BlockIDJSON{},
+		Timestamp:        signed.Timestamp,
+		ValidatorAddress: encodeHex(address),
+		ValidatorIndex:   int32(signed.ValidatorIndex),
+		Signature:        signed.Signature,
+	}
+
+	v.BlockID.Hash = encodeHex(signed.BlockHash)
+	v.BlockID.Parts.Total = uint32(signed.PartSetTotal)
+	v.BlockID.Parts.Hash = encodeHex(signed.PartSetHash)
+
+	return v, nil
+}
+
+// compare two block ids by hash and then by part set header, as in CometBFT (hashes of the same length compare as their hex encoding)
+func compareBlockIDs(first, second *BlockIDJSON) int {
+	if first.Hash != second.Hash {
+		return bytes.Compare([]byte(first.Hash), []byte(second.Hash))
+	}
+	if first.Parts.Total != second.Parts.Total {
+		if first.Parts.Total < second.Parts.Total {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare([]byte(first.Parts.Hash), []byte(second.Parts.Hash))
+}
+
+// get the key of a signed vote
+func signedVoteKey(height uint64, address []byte, voteType uint64, round uint64, value *common.Value) string {
+	return fmt.Sprintf("%d/%s/%d/%d/%s", height, encodeHex(address), voteType, round, value)
+}
@@ -0,0 +1,166 @@
+package cometbft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+)
+
+// types of the signed messages of CometBFT
+const (
+	prevoteType   = 1
+	precommitType = 2
+)
+
+// SignedVote is a vote signed by a CometBFT validator, as recorded by a node
+// it contains the fields needed to build evidence that can be verified by the chain
+type SignedVote struct {
+	Type             uint64
+	Height           uint64
+	Round            uint64
+	BlockHash        []byte
+	PartSetTotal     uint64
+	PartSetHash      []byte
+	Timestamp        time.Time
+	ValidatorAddress []byte
+	ValidatorIndex   int64
+	Signature        []byte
+}
+
+// vote read from a CometBFT node, with the peer it has been received from (empty if it has been sent by the node)
+type vote struct {
+	SignedVote
+	PeerID string
+}
+
+// Importer converts the votes recorded by a CometBFT node into the message logs of the validator running it
+// votes cast by the node are its sent messages, votes received from peers are its received messages
+// votes cast by the node are also received messages, since CometBFT counts them in its quorums
+type Importer struct {
+	// ids of the validators indexed by their hex-encoded address (case insensitive), the address is used if not found
+	IDs map[string]string
+
+	// id of the validator running the node, from its own votes
+	ownerID string
+	logs    map[uint64]*common.HeightVoteSet
+	// votes imported, including the signatures
+	signedVotes []*SignedVote
+}
+
+// NewImporter creates a new Importer with the given ids of the validators, indexed by their hex-encoded address
+func NewImporter(ids map[string]string) *Importer {
+	normalized := make(map[string]string)
+	for address, id := range ids {
+		normalized[strings.ToUpper(address)] = id
+	}
+
+	return &Importer{
+		IDs:  normalized,
+		logs: make(map[uint64]*common.HeightVoteSet),
+	}
+}
+
+// ImportFile reads the votes from a file: a JSON dump (one message per line, as written by the wal2json tool of CometBFT) if the extension is .json, .jsonl or .ndjson, a consensus WAL otherwise
+func (imp *Importer) ImportFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error while opening file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl", ".ndjson":
+		return imp.ImportJSON(file)
+	}
+	return imp.ImportWAL(file)
+}
+
+// OwnerID returns the id of the validator running the node, empty if no vote of the node has been imported
+func (imp *Importer) OwnerID() string {
+	return imp.ownerID
+}
+
+// Heights returns the heights with votes imported, sorted
+func (imp *Importer) Heights() []uint64 {
+	heights := make([]uint64, 0, len(imp.logs))
+	for height := range imp.logs {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights
+}
+
+// HeightVoteSet returns the message logs of the given height, nil if no vote has been imported for it
+func (imp *Importer) HeightVoteSet(height uint64) *common.HeightVoteSet {
+	return imp.logs[height]
+}
+
+// SignedVotes returns the prevotes and precommits imported, in the order they have been recorded
+func (imp *Importer) SignedVotes() []*SignedVote {
+	return append([]*SignedVote{}, imp.signedVotes...)
+}
+
+// ValueFromBlockID converts the hash of a block into a value, nil for the votes for no block
+// the value is made of the first 8 bytes of the hash, so that it's the same for all the nodes
+func ValueFromBlockID(hash []byte) *common.Value {
+	if len(hash) == 0 {
+		return nil
+	}
+
+	padded := make([]byte, 8)
+	copy(padded, hash)
+	return common.NewValue(int64(binary.BigEndian.Uint64(padded)))
+}
+
+// get the id of the validator with the given address
+func (imp *Importer) getID(address []byte) string {
+	encoded := encodeHex(address)
+	if id, loaded := imp.IDs[encoded]; loaded {
+		return id
+	}
+	return encoded
+}
+
+// add a vote to the message logs, ignoring the other signed messages (e.g. proposals)
+func (imp *Importer) addVote(v *vote) error {
+	var messageType common.MessageType
+	switch v.Type {
+	case prevoteType:
+		messageType = common.Prevote
+	case precommitType:
+		messageType = common.Precommit
+	default:
+		return nil
+	}
+
+	signedVote := v.SignedVote
+	imp.signedVotes = append(imp.signedVotes, &signedVote)
+
+	senderID := imp.getID(v.ValidatorAddress)
+	message := common.NewMessage(messageType, senderID, v.Round, ValueFromBlockID(v.BlockHash), nil)
+
+	hvs, loaded := imp.logs[v.Height]
+	if !loaded {
+		hvs = common.NewHeightVoteSet()
+		imp.logs[v.Height] = hvs
+	}
+
+	// votes cast by the node have no peer
+	if v.PeerID == "" {
+		if imp.ownerID != "" && imp.ownerID != senderID {
+			return fmt.Errorf("error while importing vote: votes cast by both %s and %s", imp.ownerID, senderID)
+		}
+		imp.ownerID = senderID
+		hvs.AddMessage(message)
+	}
+
+	hvs.AddReceivedMessage(message)
+
+	return nil
+}
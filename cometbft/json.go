@@ -0,0 +1,182 @@
+package cometbft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// types of the messages of the JSON dump containing votes
+const (
+	msgInfoJSONType = "tendermint/wal/MsgInfo"
+	voteJSONType    = "tendermint/Vote"
+)
+
+// maximum size of a line of the JSON dump
+const maxJSONLineSize = 1 << 20
+
+// message of the JSON dump, with its type
+type typedJSON struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// TimedWALMessage in the JSON dump
+type timedJSON struct {
+	Msg typedJSON `json:"msg"`
+}
+
+// MsgInfo in the JSON dump
+type msgInfoJSON struct {
+	Msg    typedJSON `json:"msg"`
+	PeerID string    `json:"peer_key"`
+}
+
+// VoteMessage in the JSON dump
+type voteMessageJSON struct {
+	Vote *voteJSON `json:"Vote"`
+}
+
+// Vote in the JSON dump, integers can be encoded as numbers or strings
+type voteJSON struct {
+	Type    jsonUint `json:"type"`
+	Height  jsonUint `json:"height"`
+	Round   jsonUint `json:"round"`
+	BlockID struct {
+		Hash  string `json:"hash"`
+		Parts struct {
+			Total jsonUint `json:"total"`
+			Hash  string   `json:"hash"`
+		} `json:"parts"`
+	} `json:"block_id"`
+	Timestamp        time.Time `json:"timestamp"`
+	ValidatorAddress string    `json:"validator_address"`
+	ValidatorIndex   int64     `json:"validator_index"`
+	Signature        []byte    `json:"signature"`
+}
+
+// unsigned integer encoded as a JSON number or string
+type jsonUint uint64
+
+// UnmarshalJSON decodes an unsigned integer from a JSON number or string
+func (u *jsonUint) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", data)
+	}
+	*u = jsonUint(value)
+	return nil
+}
+
+// ImportJSON reads the votes from a JSON dump of a consensus WAL, one message per line as written by the wal2json tool of CometBFT
+// lines that are not JSON messages (e.g. the end of a height) are ignored
+func (imp *Importer) ImportJSON(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+
+		v, err := decodeJSONVote(line)
+		if err != nil {
+			return fmt.Errorf("error while reading JSON dump at line %d: %s", lineNumber, err)
+		}
+
+		if v != nil {
+			err = imp.addVote(v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error while reading JSON dump: %s", err)
+	}
+
+	return nil
+}
+
+// decode the vote in a line of the JSON dump, nil if the message doesn't contain a vote
+func decodeJSONVote(line []byte) (*vote, error) {
+	var timed timedJSON
+	err := json.Unmarshal(line, &timed)
+	if err != nil {
+		return nil, err
+	}
+
+	if timed.Msg.Type != msgInfoJSONType {
+		return nil, nil
+	}
+
+	var msgInfo msgInfoJSON
+	err = json.Unmarshal(timed.Msg.Value, &msgInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if msgInfo.Msg.Type != voteJSONType {
+		return nil, nil
+	}
+
+	var voteMessage voteMessageJSON
+	err = json.Unmarshal(msgInfo.Msg.Value, &voteMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if voteMessage.Vote == nil {
+		return nil, fmt.Errorf("vote message without vote")
+	}
+
+	hash, err := decodeHex(voteMessage.Vote.BlockID.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block hash: %s", err)
+	}
+
+	partSetHash, err := decodeHex(voteMessage.Vote.BlockID.Parts.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid part set hash: %s", err)
+	}
+
+	address, err := decodeHex(voteMessage.Vote.ValidatorAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validator address: %s", err)
+	}
+
+	return &vote{
+		SignedVote: SignedVote{
+			Type:             uint64(voteMessage.Vote.Type),
+			Height:           uint64(voteMessage.Vote.Height),
+			Round:            uint64(voteMessage.Vote.Round),
+			BlockHash:        hash,
+			PartSetTotal:     uint64(voteMessage.Vote.BlockID.Parts.Total),
+			PartSetHash:      partSetHash,
+			Timestamp:        voteMessage.Vote.Timestamp.UTC(),
+			ValidatorAddress: address,
+			ValidatorIndex:   voteMessage.Vote.ValidatorIndex,
+			Signature:        voteMessage.Vote.Signature,
+		},
+		PeerID: msgInfo.PeerID,
+	}, nil
+}
+
+// decode a hex-encoded field, nil if empty
+func decodeHex(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(encoded)
+}
@@ -0,0 +1,95 @@
+package cometbft
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// protobuf wire types
+const (
+	varintType  = 0
+	fixed64Type = 1
+	bytesType   = 2
+	fixed32Type = 5
+)
+
+// field of a protobuf message, only the fields needed to read votes are decoded
+type field struct {
+	number   uint64
+	wireType uint64
+	varint   uint64
+	bytes    []byte
+}
+
+// decode the fields of a protobuf message, without knowing its schema
+func decodeFields(data []byte) ([]*field, error) {
+	fields := make([]*field, 0)
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("error while decoding protobuf message: invalid field key")
+		}
+		data = data[n:]
+
+		f := &field{number: key >> 3, wireType: key & 7}
+
+		switch f.wireType {
+		case varintType:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("error while decoding protobuf message: invalid varint in field %d", f.number)
+			}
+			data = data[n:]
+
+		case fixed64Type:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("error while decoding protobuf message: truncated field %d", f.number)
+			}
+			f.varint = binary.LittleEndian.Uint64(data[:8])
+			data = data[8:]
+
+		case bytesType:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, fmt.Errorf("error while decoding protobuf message: truncated field %d", f.number)
+			}
+			f.bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+
+		case fixed32Type:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("error while decoding protobuf message: truncated field %d", f.number)
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(data[:4]))
+			data = data[4:]
+
+		default:
+			return nil, fmt.Errorf("error while decoding protobuf message: unsupported wire type %d in field %d", f.wireType, f.number)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// get the last occurrence of the field with the given number, nil if not present
+func getField(fields []*field, number uint64) *field {
+	var found *field
+	for _, f := range fields {
+		if f.number == number {
+			found = f
+		}
+	}
+	return found
+}
+
+// decode the embedded message in the field with the given number, nil if not present
+func getMessage(fields []*field, number uint64) ([]*field, error) {
+	f := getField(fields, number)
+	if f == nil || f.wireType != bytesType {
+		return nil, nil
+	}
+	return decodeFields(f.bytes)
+}
@@ -0,0 +1,74 @@
+package cometbft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// default voting power of the validators without power given
+const defaultVotingPower = 1
+
+// ValidatorSet describes the validators of a CometBFT chain
+type ValidatorSet struct {
+	// ids of the validators indexed by their hex-encoded address
+	IDs map[string]string `yaml:"validators"`
+	// voting power of the validators indexed by their id, default value used if not given
+	Powers map[string]int64 `yaml:"powers"`
+	// base64-encoded ed25519 public keys of the validators indexed by their id, optional
+	PubKeys map[string]string `yaml:"pubKeys"`
+}
+
+// get the address of the validator with the given id
+func (vs *ValidatorSet) address(id string) ([]byte, error) {
+	for address, validatorID := range vs.IDs {
+		if validatorID == id {
+			decoded, err := hex.DecodeString(address)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %s of validator %s: %s", address, id, err)
+			}
+			return decoded, nil
+		}
+	}
+
+	// ids are addresses when they're not given
+	decoded, err := hex.DecodeString(id)
+	if err != nil || len(id) == 0 {
+		return nil, fmt.Errorf("no address for validator %s", id)
+	}
+	return decoded, nil
+}
+
+// get the voting power of the validator with the given id
+func (vs *ValidatorSet) power(id string) int64 {
+	if power, loaded := vs.Powers[id]; loaded {
+		return power
+	}
+	return defaultVotingPower
+}
+
+// get the total voting power of the validators
+func (vs *ValidatorSet) totalPower() int64 {
+	total := int64(0)
+	for _, id := range vs.IDs {
+		total += vs.power(id)
+	}
+	return total
+}
+
+// sort the ids of the validators by decreasing voting power and then by address
+func (vs *ValidatorSet) sort(ids []string, addresses map[string][]byte) {
+	sort.Slice(ids, func(i, j int) bool {
+		if vs.power(ids[i]) != vs.power(ids[j]) {
+			return vs.power(ids[i]) > vs.power(ids[j])
+		}
+		return bytes.Compare(addresses[ids[i]], addresses[ids[j]]) < 0
+	})
+}
+
+// hex encoding of addresses and hashes used by CometBFT
+func encodeHex(address []byte) string {
+	return strings.ToUpper(hex.EncodeToString(address))
+}
@@ -0,0 +1,196 @@
+package cometbft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// each record of the consensus WAL is stored as: crc32c checksum of the data (4 bytes) | length (4 bytes) | protobuf-encoded TimedWALMessage
+const (
+	walHeaderSize = 8
+
+	// records larger than this are considered corrupted, as in CometBFT
+	maxWALMessageSize = 1 << 20
+)
+
+// field numbers of the protobuf messages of CometBFT needed to read the votes
+const (
+	// TimedWALMessage
+	timedMsgField = 2
+	// WALMessage
+	walMsgInfoField = 2
+	// MsgInfo
+	msgInfoMsgField    = 1
+	msgInfoPeerIDField = 2
+	// Message
+	messageVoteField = 6
+	// VoteMessage
+	voteMessageVoteField = 1
+	// Vote
+	voteTypeField             = 1
+	voteHeightField           = 2
+	voteRoundField            = 3
+	voteBlockIDField          = 4
+	voteTimestampField        = 5
+	voteValidatorAddressField = 6
+	voteValidatorIndexField   = 7
+	voteSignatureField        = 8
+	// BlockID
+	blockIDHashField          = 1
+	blockIDPartSetHeaderField = 2
+	// PartSetHeader
+	partSetTotalField = 1
+	partSetHashField  = 2
+	// Timestamp
+	timestampSecondsField = 1
+	timestampNanosField   = 2
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ImportWAL reads the votes from a CometBFT consensus WAL
+// an incomplete record at the end of the WAL (e.g. because the node crashed while writing it) is ignored
+func (imp *Importer) ImportWAL(reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	header := make([]byte, walHeaderSize)
+
+	for {
+		_, err := io.ReadFull(buffered, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading WAL: %s", err)
+		}
+
+		checksum := binary.BigEndian.Uint32(header[0:4])
+		length := binary.BigEndian.Uint32(header[4:8])
+		if length > maxWALMessageSize {
+			return fmt.Errorf("error while reading WAL: record of %d bytes exceeds the maximum size", length)
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(buffered, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading WAL: %s", err)
+		}
+
+		if crc32.Checksum(data, crc32c) != checksum {
+			return fmt.Errorf("error while reading WAL: checksum mismatch")
+		}
+
+		v, err := decodeWALVote(data)
+		if err != nil {
+			return err
+		}
+
+		if v != nil {
+			err = imp.addVote(v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// decode the vote in a TimedWALMessage, nil if the message doesn't contain a vote
+func decodeWALVote(data []byte) (*vote, error) {
+	timed, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// TimedWALMessage -> WALMessage -> MsgInfo
+	walMessage, err := getMessage(timed, timedMsgField)
+	if err != nil || walMessage == nil {
+		return nil, err
+	}
+
+	msgInfo, err := getMessage(walMessage, walMsgInfoField)
+	if err != nil || msgInfo == nil {
+		return nil, err
+	}
+
+	// MsgInfo -> Message -> VoteMessage -> Vote
+	message, err := getMessage(msgInfo, msgInfoMsgField)
+	if err != nil || message == nil {
+		return nil, err
+	}
+
+	voteMessage, err := getMessage(message, messageVoteField)
+	if err != nil || voteMessage == nil {
+		return nil, err
+	}
+
+	voteFields, err := getMessage(voteMessage, voteMessageVoteField)
+	if err != nil || voteFields == nil {
+		return nil, err
+	}
+
+	v := &vote{}
+
+	if f := getField(msgInfo, msgInfoPeerIDField); f != nil {
+		v.PeerID = string(f.bytes)
+	}
+	if f := getField(voteFields, voteTypeField); f != nil {
+		v.Type = f.varint
+	}
+	if f := getField(voteFields, voteHeightField); f != nil {
+		v.Height = f.varint
+	}
+	if f := getField(voteFields, voteRoundField); f != nil {
+		v.Round = f.varint
+	}
+	if f := getField(voteFields, voteValidatorAddressField); f != nil {
+		v.ValidatorAddress = f.bytes
+	}
+	if f := getField(voteFields, voteValidatorIndexField); f != nil {
+		v.ValidatorIndex = int64(int32(f.varint))
+	}
+	if f := getField(voteFields, voteSignatureField); f != nil {
+		v.Signature = f.bytes
+	}
+
+	timestamp, err := getMessage(voteFields, voteTimestampField)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp != nil {
+		var seconds, nanos uint64
+		if f := getField(timestamp, timestampSecondsField); f != nil {
+			seconds = f.varint
+		}
+		if f := getField(timestamp, timestampNanosField); f != nil {
+			nanos = f.varint
+		}
+		v.Timestamp = time.Unix(int64(seconds), int64(int32(nanos))).UTC()
+	}
+
+	blockID, err := getMessage(voteFields, voteBlockIDField)
+	if err != nil {
+		return nil, err
+	}
+	if f := getField(blockID, blockIDHashField); f != nil {
+		v.BlockHash = f.bytes
+	}
+
+	partSetHeader, err := getMessage(blockID, blockIDPartSetHeaderField)
+	if err != nil {
+		return nil, err
+	}
+	if f := getField(partSetHeader, partSetTotalField); f != nil {
+		v.PartSetTotal = f.varint
+	}
+	if f := getField(partSetHeader, partSetHashField); f != nil {
+		v.PartSetHash = f.bytes
+	}
+
+	return v, nil
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.dedis.ch/protobuf"
+	"gopkg.in/yaml.v2"
+)
+
+// Format represents the serialization format of a HeightVoteSet stored in a file
+type Format string
+
+const (
+	// YAML format, same structure used in the validator config files
+	YAML Format = "yaml"
+	// JSON format
+	JSON Format = "json"
+	// Protobuf format, same encoding used in the packets exchanged by monitor and validators
+	Protobuf Format = "protobuf"
+)
+
+// FormatFromPath returns the format of a file given its extension
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML, nil
+	case ".json":
+		return JSON, nil
+	case ".pb", ".protobuf":
+		return Protobuf, nil
+	}
+
+	return "", fmt.Errorf("unknown format for file %s", path)
+}
+
+// EncodeHeightVoteSet serializes a HeightVoteSet in the given format
+func EncodeHeightVoteSet(hvs *HeightVoteSet, format Format) ([]byte, error) {
+	switch format {
+	case YAML:
+		return yaml.Marshal(hvs)
+	case JSON:
+		return json.MarshalIndent(hvs, "", "  ")
+	case Protobuf:
+		return protobuf.Encode(hvs)
+	}
+
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+// DecodeHeightVoteSet deserializes a HeightVoteSet from the given format
+func DecodeHeightVoteSet(data []byte, format Format) (*HeightVoteSet, error) {
+	hvs := NewHeightVoteSet()
+
+	var err error
+	switch format {
+	case YAML:
+		err = yaml.Unmarshal(data, hvs)
+	case JSON:
+		err = json.Unmarshal(data, hvs)
+	case Protobuf:
+		err = protobuf.Decode(data, hvs)
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error while deserializing height vote set: %s", err)
+	}
+
+	if hvs.VoteSetMap == nil {
+		hvs.VoteSetMap = make(map[uint64]*VoteSet)
+	}
+
+	return hvs, nil
+}
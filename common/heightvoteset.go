@@ -7,7 +7,7 @@ import (
 
 // HeightVoteSet contains all messages for all the rounds of a specific height
 type HeightVoteSet struct {
-	VoteSetMap map[uint64]*VoteSet `yaml:"heightvoteset"`
+	VoteSetMap map[uint64]*VoteSet `yaml:"heightvoteset" json:"heightvoteset"`
 }
 
 // NewHeightVoteSet creates a new HeightVoteSet structure
@@ -29,6 +29,60 @@ func (hvs *HeightVoteSet) AddMessage(mes *Message) {
 	vs.addSentMessage(mes)
 }
 
+// AddReceivedMessage adds a given message received from another process to the right voteSet
+func (hvs *HeightVoteSet) AddReceivedMessage(mes *Message) {
+
+	vs, loaded := hvs.VoteSetMap[mes.Round]
+	if vs == nil || !loaded {
+		vs = NewVoteSet()
+		hvs.VoteSetMap[mes.Round] = vs
+	}
+
+	vs.addReceivedMessage(mes)
+}
+
+// Merge adds all the messages of another height vote set, skipping the ones already present
+func (hvs *HeightVoteSet) Merge(other *HeightVoteSet) {
+	for _, vs := range other.VoteSetMap {
+		if vs == nil {
+			continue
+		}
+
+		for _, mes := range vs.SentPrevoteMessages {
+			hvs.AddMessage(mes)
+		}
+		for _, mes := range vs.SentPrecommitMessages {
+			hvs.AddMessage(mes)
+		}
+		for _, mes := range vs.ReceivedPrevoteMessages {
+			hvs.AddReceivedMessage(mes)
+		}
+		for _, mes := range vs.ReceivedPrecommitMessages {
+			hvs.AddReceivedMessage(mes)
+		}
+	}
+}
+
+// Copy returns a copy of the height vote set that can be changed without affecting the original one, the messages are shared
+func (hvs *HeightVoteSet) Copy() *HeightVoteSet {
+	copied := NewHeightVoteSet()
+
+	for round, vs := range hvs.VoteSetMap {
+		if vs == nil {
+			continue
+		}
+
+		copied.VoteSetMap[round] = &VoteSet{
+			ReceivedPrevoteMessages:   append([]*Message{}, vs.ReceivedPrevoteMessages...),
+			ReceivedPrecommitMessages: append([]*Message{}, vs.ReceivedPrecommitMessages...),
+			SentPrevoteMessages:       append([]*Message{}, vs.SentPrevoteMessages...),
+			SentPrecommitMessages:     append([]*Message{}, vs.SentPrecommitMessages...),
+		}
+	}
+
+	return copied
+}
+
 // String representation of a hvs
 func (hvs *HeightVoteSet) String() string {
 	var sb strings.Builder
@@ -74,3 +128,17 @@ func (hvs *HeightVoteSet) IsValid(ID string) bool {
 
 	return true
 }
+
+// Filter returns a height vote set with only the rounds between fromRound and toRound (included) and the messages of the given types
+// messages of all types are kept if no type is given
+func (hvs *HeightVoteSet) Filter(fromRound, toRound uint64, types []MessageType) *HeightVoteSet {
+	filtered := NewHeightVoteSet()
+
+	for round, vs := range hvs.VoteSetMap {
+		if vs != nil && round >= fromRound && round <= toRound {
+			filtered.VoteSetMap[round] = vs.filter(types)
+		}
+	}
+
+	return filtered
+}
@@ -20,11 +20,11 @@ const (
 
 // Message struct
 type Message struct {
-	Type           MessageType `yaml:"type"`
-	SenderID       string      `yaml:"sender"`
-	Round          uint64      `yaml:"round"`
-	Value          *Value      `yaml:"value"`
-	Justifications []*Message  `yaml:"justifications"`
+	Type           MessageType `yaml:"type" json:"type"`
+	SenderID       string      `yaml:"sender" json:"sender"`
+	Round          uint64      `yaml:"round" json:"round"`
+	Value          *Value      `yaml:"value" json:"value"`
+	Justifications []*Message  `yaml:"justifications,omitempty" json:"justifications,omitempty"`
 }
 
 // NewMessage creates a new message
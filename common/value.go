@@ -7,7 +7,7 @@ import (
 
 // Value represents a value of a message, it can contain other information if desired
 type Value struct {
-	Data int64 `yaml:"data"`
+	Data int64 `yaml:"data" json:"data"`
 }
 
 // NewValue creates a new value
@@ -22,7 +22,10 @@ func (value *Value) Equal(other *Value) bool {
 	return reflect.DeepEqual(value, other)
 }
 
-// String representation of a value
+// String representation of a value, nil for the votes for no value
 func (value *Value) String() string {
+	if value == nil {
+		return "nil"
+	}
 	return strconv.FormatInt(value.Data, 10)
 }
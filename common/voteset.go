@@ -6,10 +6,10 @@ import (
 
 // VoteSet contains all messages of a process for a specific round
 type VoteSet struct {
-	ReceivedPrevoteMessages   []*Message `yaml:"received_prevote"`
-	ReceivedPrecommitMessages []*Message `yaml:"received_precommit"`
-	SentPrevoteMessages       []*Message `yaml:"sent_prevote"`
-	SentPrecommitMessages     []*Message `yaml:"sent_precommit"`
+	ReceivedPrevoteMessages   []*Message `yaml:"received_prevote" json:"received_prevote"`
+	ReceivedPrecommitMessages []*Message `yaml:"received_precommit" json:"received_precommit"`
+	SentPrevoteMessages       []*Message `yaml:"sent_prevote" json:"sent_prevote"`
+	SentPrecommitMessages     []*Message `yaml:"sent_precommit" json:"sent_precommit"`
 }
 
 // NewVoteSet creates a new VoteSet structure
@@ -38,6 +38,22 @@ func (vs *VoteSet) addSentMessage(mes *Message) {
 	}
 }
 
+// add a given message to the correct set of received messages based on the type
+func (vs *VoteSet) addReceivedMessage(mes *Message) {
+
+	switch mes.Type {
+	case Prevote:
+		if !contains(vs.ReceivedPrevoteMessages, mes) {
+			vs.ReceivedPrevoteMessages = append(vs.ReceivedPrevoteMessages, mes)
+		}
+
+	case Precommit:
+		if !contains(vs.ReceivedPrecommitMessages, mes) {
+			vs.ReceivedPrecommitMessages = append(vs.ReceivedPrecommitMessages, mes)
+		}
+	}
+}
+
 // contains utility for list of messages
 func contains(messages []*Message, message *Message) bool {
 	contains := false
@@ -83,3 +99,24 @@ func messagesToString(description string, messageSet []*Message) string {
 	}
 	return sb.String()
 }
+
+// return a vote set with only the messages of the given types, all of them if no type is given
+func (vs *VoteSet) filter(types []MessageType) *VoteSet {
+	if len(types) == 0 {
+		return vs
+	}
+
+	filtered := NewVoteSet()
+	for _, t := range types {
+		switch t {
+		case Prevote:
+			filtered.ReceivedPrevoteMessages = vs.ReceivedPrevoteMessages
+			filtered.SentPrevoteMessages = vs.SentPrevoteMessages
+		case Precommit:
+			filtered.ReceivedPrecommitMessages = vs.ReceivedPrecommitMessages
+			filtered.SentPrecommitMessages = vs.SentPrecommitMessages
+		}
+	}
+
+	return filtered
+}
@@ -8,6 +8,51 @@ type Packet struct {
 	ID     string
 	Height uint64
 	Hvs    *common.HeightVoteSet
+
+	// id chosen by the client for a request and sent back in the response, to match them on a multiplexed connection
+	RequestID uint64
+
+	// signature of the sender over the other fields, optional
+	Signature []byte
+
+	// true if the height vote set continues in the next packet
+	More bool
+
+	// sent when the connection is established (Hello code)
+	Hello *HelloMessage
+	// reason why the request can't be served (Error code), with a description
+	Reason       uint32
+	ErrorMessage string
+
+	// rounds and message types requested (HvsRequest code) or contained in the response (HvsResponse code), the whole height vote set if nil
+	Filter *Filter
+}
+
+// Filter restricts the message logs to a range of rounds and to some message types
+type Filter struct {
+	FromRound uint64
+	ToRound   uint64
+	// all message types if empty
+	Types []common.MessageType
+}
+
+// Equal returns true if the filters select the same rounds and message types (in the same order), nil filters are equal
+func (filter *Filter) Equal(other *Filter) bool {
+	if filter == nil || other == nil {
+		return filter == other
+	}
+
+	if filter.FromRound != other.FromRound || filter.ToRound != other.ToRound || len(filter.Types) != len(other.Types) {
+		return false
+	}
+
+	for i := range filter.Types {
+		if filter.Types[i] != other.Types[i] {
+			return false
+		}
+	}
+
+	return true
 }
 
 // main whisper protocol parameters, from official specs
@@ -17,12 +62,26 @@ const (
 	HvsRequest  = 0
 	HvsResponse = 1
 	HvsMissing  = 3
+	Hello       = 4
+	Error       = 5
+	// messages sent and received by a consensus node, recorded by its validator and acknowledged with the same code
+	HvsRecord = 6
 
 	// lengths in bytes
-	maxBufferSize = 60000
+	frameHeaderSize     = 4
+	defaultMaxFrameSize = 4 * 1024 * 1024
+	mapEntryOverhead    = 32
+
+	// default bounds on a packet streamed in several frames: size of the packet (in frames of the maximum size) and number of frames
+	maxPacketFrames = 16
+	maxPacketChunks = 4096
 
 	maxChannelSize = 100
 
 	readDeadline  = 20
 	writeDeadline = 20
+
+	// connections refused at the same time, the other ones are closed without telling the reason, and time (in seconds) to refuse one
+	maxRefusing    = 16
+	refuseDeadline = 1
 )
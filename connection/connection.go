@@ -1,87 +1,202 @@
 package connection
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"go.dedis.ch/protobuf"
 )
 
 // Connection is a wrapper for a net.Conn
+// packets are sent in frames prefixed by their length, height vote sets too large for a single frame are streamed in several frames
 type Connection struct {
 	Conn net.Conn
+
+	// maximum size (in bytes) of a frame sent or received, default value used if 0
+	MaxFrameSize uint32
+	// maximum size (in bytes) of a packet streamed in several frames, i.e. of the largest response to a request, 16 frames if 0
+	MaxPacketSize uint64
+
+	// prevent concurrent senders from interleaving their frames
+	sendMutex sync.Mutex
+
+	// role of the other side and features negotiated during the handshake
+	peerRole string
+	features map[string]bool
+
+	// connection injecting network faults in the frames, below the TLS connection if used
+	faults *faultyConn
 }
 
 // Send sends a packet to a given connection
 func (c *Connection) Send(packet *Packet) error {
 
-	// encode message
-	messageEncoded, err := protobuf.Encode(packet)
-
+	// split the packet in chunks if it's too large
+	chunks, err := c.splitPacket(packet)
 	if err != nil {
-		return fmt.Errorf("error while serializing the packet to send: %s", err)
+		return err
 	}
 
-	err = c.Conn.SetWriteDeadline(time.Now().Add(time.Duration(writeDeadline) * time.Second))
-	if err != nil {
-		return fmt.Errorf("error while setting write deadline: %s", err)
-	}
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
 
-	// send message
-	_, err = c.Conn.Write(messageEncoded)
-	if err != nil {
-		return fmt.Errorf("error while sending packet to %s: %s", c.Conn.RemoteAddr(), err)
+	for _, chunk := range chunks {
+		err = c.Conn.SetWriteDeadline(time.Now().Add(time.Duration(writeDeadline) * time.Second))
+		if err != nil {
+			return fmt.Errorf("error while setting write deadline: %s", err)
+		}
+
+		// send message
+		err = c.writeFrame(chunk)
+		if err != nil {
+			return fmt.Errorf("error while sending packet to %s: %s", c.Conn.RemoteAddr(), err)
+		}
 	}
 
+	packetsSent.Inc()
+
 	return nil
 }
 
 // Receive receives a packet from a given connection
 func (c *Connection) Receive() (*Packet, error) {
+	return c.receive(func() time.Time {
+		// the deadline is extended for every frame of the packet
+		return time.Now().Add(time.Duration(readDeadline) * time.Second)
+	})
+}
 
-	packet := &Packet{}
-	packetBytes := make([]byte, maxBufferSize)
-
-	err := c.Conn.SetReadDeadline(time.Now().Add(time.Duration(readDeadline) * time.Second))
-	if err != nil {
-		return nil, fmt.Errorf("error while setting read deadline: %s", err)
+// receive a packet, waiting at most the idle timeout for the first frame (default read deadline if 0)
+func (c *Connection) receiveWithIdleTimeout(idleTimeout time.Duration) (*Packet, error) {
+	if idleTimeout == 0 {
+		return c.Receive()
 	}
 
-	n, err := c.Conn.Read(packetBytes)
+	first := true
+	return c.receive(func() time.Time {
+		if first {
+			first = false
+			return time.Now().Add(idleTimeout)
+		}
+		return time.Now().Add(time.Duration(readDeadline) * time.Second)
+	})
+}
 
-	if err != nil {
-		if err == io.EOF {
-			return nil, err
+// ReceiveWithDeadline receives a packet from a given connection, waiting at most until the given deadline
+func (c *Connection) ReceiveWithDeadline(deadline time.Time) (*Packet, error) {
+	return c.receive(func() time.Time {
+		return deadline
+	})
+}
+
+// receive all the frames of a packet, merging the chunks of the height vote set
+// frames are decoded one at a time, the packet is rejected if it has too many frames or if it's too large
+func (c *Connection) receive(getDeadline func() time.Time) (*Packet, error) {
+
+	var packet *Packet
+	numChunks := 0
+	size := uint64(0)
+
+	for {
+		err := c.Conn.SetReadDeadline(getDeadline())
+		if err != nil {
+			return nil, fmt.Errorf("error while setting read deadline: %s", err)
 		}
-		return nil, fmt.Errorf("error while reading from socket: %s", err)
-	}
 
-	if n > maxBufferSize {
-		return nil, fmt.Errorf("error while reading from socket: message size too large")
-	}
+		frame, err := c.readFrame()
+		if err != nil {
+			if err == io.EOF && packet == nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("error while reading from socket: %s", err)
+		}
 
-	// decode message
-	err = protobuf.Decode(packetBytes[:n], packet)
+		numChunks++
+		size += uint64(len(frame))
+		if numChunks > c.maxPacketChunks() {
+			return nil, fmt.Errorf("error while receiving packet: more than %d frames", c.maxPacketChunks())
+		}
+		if size > c.maxPacketSize() {
+			return nil, fmt.Errorf("error while receiving packet: packet size exceeds the maximum packet size %d", c.maxPacketSize())
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("error while deserializing packet received: %s", err)
+		// decode message
+		chunk := &Packet{}
+		err = protobuf.Decode(frame, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("error while deserializing packet received: %s", err)
+		}
+
+		if packet == nil {
+			packet = chunk
+		} else {
+			err = mergeChunk(packet, chunk)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !chunk.More {
+			break
+		}
 	}
 
+	packetsReceived.Inc()
+
 	return packet, nil
 }
 
 // Connect tried to establish connection given an address
+// clients are monitors and perform the handshake with the validator before returning the connection
 func Connect(address string) (*Connection, error) {
-	connClient, err := net.Dial("tcp", address)
+	return Dial(&NetTransport{Network: TCP}, address, nil)
+}
+
+// Dial establishes a connection to the given address with the given transport, using TLS if a configuration is given
+func Dial(transport Transport, address string, config *tls.Config) (*Connection, error) {
+	return DialAs(transport, address, config, RoleMonitor)
+}
+
+// DialAs establishes a connection like Dial, declaring the given role in the handshake
+func DialAs(transport Transport, address string, config *tls.Config, role string) (*Connection, error) {
+	connClient, err := transport.Dial(address)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to address %s: %s", address, err)
 	}
 
-	return &Connection{Conn: connClient}, nil
+	faults, _ := connClient.(*faultyConn)
+
+	if config != nil {
+		connClient, err = newTLSClient(connClient, address, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to address %s: %s", address, err)
+		}
+	}
+
+	return newClientConnection(connClient, faults, address, role)
+}
+
+// create a connection and perform the handshake on it
+func newClientConnection(conn net.Conn, faults *faultyConn, address string, role string) (*Connection, error) {
+	c := &Connection{Conn: conn, faults: faults}
+
+	err := c.handshake(role)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("handshake with address %s failed: %s", address, err)
+	}
+
+	if debug {
+		log.Printf("Handshake with %s %s completed, features: %v", c.peerRole, address, c.negotiatedFeatures())
+	}
+
+	return c, nil
 }
 
 // Close tries to close a given connection
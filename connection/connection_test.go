@@ -1,65 +1,79 @@
 package connection
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/metrics"
 	"github.com/mikanikos/Fork-Accountability/utils"
+	"go.dedis.ch/protobuf"
 )
 
 // run tests individually because of persistent connections between tests
 
-func Test_ServerInitialization(t *testing.T) {
+// start a server on a free address and stop it at the end of the test
+func startTestServer(t *testing.T) (*Server, string) {
 
 	address, err := utils.GetFreeAddress()
 	if err != nil {
 		t.Fatal("Failed when retrieving free address")
 	}
 
-	// server
-	go func() {
-		err := NewServer().Listen(address)
-		if err != nil {
-			t.Fatalf("Failed while start listening: %s", err)
-		}
-	}()
-	time.Sleep(time.Duration(2) * time.Second)
-}
+	server := NewServer()
 
-func Test_ServerWrongAddressForListen(t *testing.T) {
+	err = server.Start(address)
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
 
-	// server
-	go func() {
-		err := NewServer().Listen("")
-		if err == nil {
-			t.Fatalf("Should have failed listening: %s", err)
-		}
-	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
 
-	time.Sleep(time.Duration(2) * time.Second)
+	return server, address
 }
 
-func Test_ClientInitialization(t *testing.T) {
+func Test_ServerInitialization(t *testing.T) {
+	startTestServer(t)
+}
 
-	address, err := utils.GetFreeAddress()
-	if err != nil {
-		t.Fatal("Failed when retrieving free address")
+func Test_ServerWrongAddressForListen(t *testing.T) {
+
+	err := NewServer().Start("wrong:address:0")
+	if err == nil {
+		t.Fatal("Should have failed listening")
 	}
+}
 
-	// server
-	go func() {
-		err := NewServer().Listen(address)
-		if err != nil {
-			t.Fatalf("Failed while start listening: %s", err)
-		}
-	}()
+func Test_ClientInitialization(t *testing.T) {
 
-	time.Sleep(time.Duration(2) * time.Second)
+	_, address := startTestServer(t)
 
-	_, err = Connect(address)
+	connClient, err := Connect(address)
 	if err != nil {
 		t.Fatalf("Failed to connect to server: %s", err)
 	}
+	connClient.Close()
 }
 
 func Test_ClientFailingToConnect(t *testing.T) {
@@ -72,20 +86,7 @@ func Test_ClientFailingToConnect(t *testing.T) {
 
 func Test_ClientSendsMessage(t *testing.T) {
 
-	address, err := utils.GetFreeAddress()
-	if err != nil {
-		t.Fatal("Failed when retrieving free address")
-	}
-
-	// server
-	go func() {
-		err := NewServer().Listen(address)
-		if err != nil {
-			t.Fatalf("Failed while start listening: %s", err)
-		}
-	}()
-
-	time.Sleep(time.Duration(2) * time.Second)
+	_, address := startTestServer(t)
 
 	connClient, err := Connect(address)
 	if err != nil {
@@ -102,28 +103,14 @@ func Test_ClientSendsMessage(t *testing.T) {
 
 func Test_ServerClientInteraction(t *testing.T) {
 
-	address, err := utils.GetFreeAddress()
-	if err != nil {
-		t.Fatal("Failed when retrieving free address")
-	}
-
-	server := NewServer()
-
-	// server start listening
-	go func() {
-		err := server.Listen(address)
-		if err != nil {
-			t.Fatalf("Failed while start listening: %s", err)
-		}
-	}()
-
-	time.Sleep(time.Second * time.Duration(2))
+	server, address := startTestServer(t)
 
 	// client connects
 	connClient, err := Connect(address)
 	if err != nil {
 		t.Fatalf("Failed to connect to server: %s", err)
 	}
+	defer connClient.Close()
 
 	// client sends packet
 	err = connClient.Send(&Packet{Code: HvsRequest})
@@ -140,6 +127,7 @@ func Test_ServerClientInteraction(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to send packet on server: %s", err)
 	}
+	packetFromClient.Done()
 
 	// client receives packet
 	packet, err := connClient.Receive()
@@ -155,22 +143,7 @@ func Test_ServerClientInteraction(t *testing.T) {
 
 func Test_ServerClientWithPeriodicSendInteraction(t *testing.T) {
 
-	address, err := utils.GetFreeAddress()
-	if err != nil {
-		t.Fatal("Failed when retrieving free address")
-	}
-
-	server := NewServer()
-
-	// server start listening
-	go func() {
-		err := server.Listen(address)
-		if err != nil {
-			t.Fatalf("Failed while start listening: %s", err)
-		}
-	}()
-
-	time.Sleep(time.Second * time.Duration(2))
+	server, address := startTestServer(t)
 
 	// client connects
 	connClient, err := Connect(address)
@@ -185,10 +158,11 @@ func Test_ServerClientWithPeriodicSendInteraction(t *testing.T) {
 	// server receives packet
 	packetFromClient := <-server.ReceiveChannel
 	packetFromClient.Packet.Code = HvsResponse
+	packetFromClient.Done()
 
 	// wait for second packet repeated
-	<-server.ReceiveChannel
-	packetFromClient.Packet.Code = HvsResponse
+	repeatedPacket := <-server.ReceiveChannel
+	repeatedPacket.Done()
 
 	// server sends packet back with modified code
 	err = packetFromClient.Connection.Send(packetFromClient.Packet)
@@ -209,3 +183,1174 @@ func Test_ServerClientWithPeriodicSendInteraction(t *testing.T) {
 
 	closeChannel <- true
 }
+
+func Test_PacketSignature(t *testing.T) {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %s", err)
+	}
+
+	packet := &Packet{Code: HvsResponse, ID: "1", Height: 1, RequestID: 1, Hvs: utils.GetHvsForDefaultConfig1()}
+
+	err = packet.Sign(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign packet: %s", err)
+	}
+
+	if !packet.VerifySignature(publicKey) {
+		t.Fatal("Signature should be valid")
+	}
+
+	// signature is still valid after serialization
+	encoded, _ := protobuf.Encode(packet)
+	decoded := &Packet{}
+	_ = protobuf.Decode(encoded, decoded)
+	if !decoded.VerifySignature(publicKey) {
+		t.Fatal("Signature should be valid after serialization")
+	}
+
+	// tampering with the packet invalidates the signature
+	packet.Hvs.VoteSetMap[3].SentPrevoteMessages = nil
+	if packet.VerifySignature(publicKey) {
+		t.Fatal("Signature should not be valid after modifying the packet")
+	}
+
+	// the response can't be replayed for another request or filter
+	replayed := *decoded
+	replayed.RequestID = 2
+	if replayed.VerifySignature(publicKey) {
+		t.Fatal("Signature should not be valid for another request")
+	}
+	replayed = *decoded
+	replayed.Filter = &Filter{FromRound: 3, ToRound: 4}
+	if replayed.VerifySignature(publicKey) {
+		t.Fatal("Signature should not be valid for another filter")
+	}
+
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+	decoded.ID = "2"
+	if decoded.VerifySignature(otherPublicKey) || decoded.VerifySignature(publicKey) {
+		t.Fatal("Signature should not be valid")
+	}
+}
+
+// create a height vote set with the given number of rounds and messages received in each round
+func createLargeHvs(numRounds, numMessages int) *common.HeightVoteSet {
+	hvs := common.NewHeightVoteSet()
+	for round := 0; round < numRounds; round++ {
+		vs := common.NewVoteSet()
+		for i := 0; i < numMessages; i++ {
+			vs.ReceivedPrevoteMessages = append(vs.ReceivedPrevoteMessages, common.NewMessage(common.Prevote, strconv.Itoa(i), uint64(round), common.NewValue(int64(i)), nil))
+		}
+		hvs.VoteSetMap[uint64(round)] = vs
+	}
+	return hvs
+}
+
+func Test_StreamLargeHvs(t *testing.T) {
+
+	clientConn, serverConn := net.Pipe()
+	client := &Connection{Conn: clientConn, MaxFrameSize: 4096}
+	server := &Connection{Conn: serverConn, MaxFrameSize: 4096}
+	defer client.Close()
+	defer server.Close()
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	packet := &Packet{Code: HvsResponse, ID: "1", Height: 1, Hvs: createLargeHvs(100, 20)}
+	_ = packet.Sign(privateKey)
+
+	chunks, err := client.splitPacket(packet)
+	if err != nil || len(chunks) < 2 {
+		t.Fatalf("Packet should have been split in several chunks: %s", err)
+	}
+
+	go func() {
+		err := client.Send(packet)
+		if err != nil {
+			log.Printf("Failed to send packet: %s", err)
+		}
+	}()
+
+	received, err := server.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive packet: %s", err)
+	}
+
+	// the signature covers all the rounds of the height vote set, so it's valid only if the packet has been correctly reassembled
+	if received.More || len(received.Hvs.VoteSetMap) != len(packet.Hvs.VoteSetMap) || !received.VerifySignature(publicKey) {
+		t.Fatal("Packet received is different from the one sent")
+	}
+}
+
+func Test_StreamAboveDefaultFrameSize(t *testing.T) {
+
+	clientConn, serverConn := net.Pipe()
+	client := &Connection{Conn: clientConn}
+	server := &Connection{Conn: serverConn}
+	defer client.Close()
+	defer server.Close()
+
+	// height vote set larger than the default frame size, but smaller than the default packet size
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	packet := &Packet{Code: HvsResponse, ID: "1", Height: 1, Hvs: createLargeHvs(200, 1000)}
+	_ = packet.Sign(privateKey)
+	size, _ := encodedSize(packet)
+	if size <= defaultMaxFrameSize || size >= server.maxPacketSize() {
+		t.Fatalf("Wrong size of the packet for the test: %d", size)
+	}
+
+	go func() {
+		err := client.Send(packet)
+		if err != nil {
+			log.Printf("Failed to send packet: %s", err)
+		}
+	}()
+
+	received, err := server.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive packet: %s", err)
+	}
+
+	// the signature covers all the rounds of the height vote set, so it's valid only if the packet has been correctly reassembled
+	if len(received.Hvs.VoteSetMap) != len(packet.Hvs.VoteSetMap) || !received.VerifySignature(publicKey) {
+		t.Fatal("Packet received is different from the one sent")
+	}
+
+	// the maximum packet size is the limit of a response
+	client.MaxPacketSize = size / 2
+	err = client.Send(packet)
+	if err == nil || !strings.Contains(err.Error(), "maximum packet size") {
+		t.Fatalf("Send should have failed because the packet is larger than the maximum packet size: %v", err)
+	}
+
+	client.MaxPacketSize = 0
+	server.MaxPacketSize = size / 2
+	go func() {
+		_ = client.Send(packet)
+	}()
+
+	_, err = server.Receive()
+	if err == nil || !strings.Contains(err.Error(), "maximum packet size") {
+		t.Fatalf("Receive should have failed because the packet is larger than the maximum packet size: %v", err)
+	}
+}
+
+func Test_ReceiveFragmentedFrame(t *testing.T) {
+
+	clientConn, serverConn := net.Pipe()
+	server := &Connection{Conn: serverConn}
+	defer clientConn.Close()
+	defer server.Close()
+
+	encoded, _ := protobuf.Encode(&Packet{Code: HvsRequest, Height: 5})
+	frame := append([]byte{0, 0, 0, byte(len(encoded))}, encoded...)
+
+	// write the frame one byte at a time
+	go func() {
+		for _, b := range frame {
+			_, _ = clientConn.Write([]byte{b})
+		}
+	}()
+
+	packet, err := server.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive packet: %s", err)
+	}
+
+	if packet.Code != HvsRequest || packet.Height != 5 {
+		t.Fatal("Failed to receive correct packet")
+	}
+}
+
+func Test_FrameTooLarge(t *testing.T) {
+
+	clientConn, serverConn := net.Pipe()
+	client := &Connection{Conn: clientConn, MaxFrameSize: 1 << 20}
+	server := &Connection{Conn: serverConn, MaxFrameSize: 64}
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_ = client.Send(&Packet{Code: HvsResponse, ID: strings.Repeat("x", 100)})
+	}()
+
+	_, err := server.Receive()
+	if err == nil {
+		t.Fatal("Receive should have failed because the frame is too large")
+	}
+
+	// a single round larger than the maximum frame size cannot be sent
+	client.MaxFrameSize = 64
+	err = client.Send(&Packet{Code: HvsResponse, Hvs: createLargeHvs(1, 10)})
+	if err == nil {
+		t.Fatal("Send should have failed because a round is too large")
+	}
+}
+
+// send chunks of a packet until the connection is closed
+func sendChunks(c *Connection, chunk func(i int) *Packet) {
+	go func() {
+		for i := 0; ; i++ {
+			if c.writeFrame(chunk(i)) != nil {
+				return
+			}
+		}
+	}()
+}
+
+func Test_StreamLimits(t *testing.T) {
+
+	// too many frames
+	clientConn, serverConn := net.Pipe()
+	client := &Connection{Conn: clientConn}
+	server := &Connection{Conn: serverConn}
+
+	sendChunks(client, func(i int) *Packet {
+		return &Packet{Code: HvsResponse, ID: "1", More: true}
+	})
+
+	_, err := server.Receive()
+	if err == nil || !strings.Contains(err.Error(), "frames") {
+		t.Fatalf("Receive should have failed because the packet has too many frames: %v", err)
+	}
+	client.Close()
+	server.Close()
+
+	// packet too large
+	clientConn, serverConn = net.Pipe()
+	client = &Connection{Conn: clientConn, MaxFrameSize: 4096}
+	server = &Connection{Conn: serverConn, MaxFrameSize: 4096}
+
+	sendChunks(client, func(i int) *Packet {
+		hvs := createLargeHvs(1, 20)
+		hvs.VoteSetMap[uint64(i)] = hvs.VoteSetMap[0]
+		if i > 0 {
+			delete(hvs.VoteSetMap, 0)
+		}
+		return &Packet{Code: HvsResponse, ID: "1", Hvs: hvs, More: true}
+	})
+
+	_, err = server.Receive()
+	if err == nil || !strings.Contains(err.Error(), "maximum packet size") {
+		t.Fatalf("Receive should have failed because the packet is too large: %v", err)
+	}
+	client.Close()
+	server.Close()
+
+	// round sent twice
+	clientConn, serverConn = net.Pipe()
+	client = &Connection{Conn: clientConn}
+	server = &Connection{Conn: serverConn}
+	defer client.Close()
+	defer server.Close()
+
+	sendChunks(client, func(i int) *Packet {
+		return &Packet{Code: HvsResponse, ID: "1", Hvs: createLargeHvs(1, 1), More: i == 0}
+	})
+
+	_, err = server.Receive()
+	if err == nil || !strings.Contains(err.Error(), "received twice") {
+		t.Fatalf("Receive should have failed because a round has been sent twice: %v", err)
+	}
+}
+
+// generate a certificate with the given common name, signed by the given parent (self-signed if nil), and return it with its PEM encoding and key
+func generateCertificate(t *testing.T, commonName string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func Test_MutualTLS(t *testing.T) {
+
+	ca, caKey, caPEM, _ := generateCertificate(t, "ca", true, nil, nil)
+	_, _, serverCert, serverKey := generateCertificate(t, "validator", false, ca, caKey)
+	_, _, monitorCert, monitorKey := generateCertificate(t, "monitor", false, ca, caKey)
+	_, _, intruderCert, intruderKey := generateCertificate(t, "intruder", false, ca, caKey)
+
+	otherCA, otherCAKey, _, _ := generateCertificate(t, "other", true, nil, nil)
+	_, _, untrustedCert, untrustedKey := generateCertificate(t, "monitor", false, otherCA, otherCAKey)
+
+	serverConfig, err := NewServerTLSConfig(serverCert, serverKey, caPEM, []string{"monitor"})
+	if err != nil {
+		t.Fatalf("Failed to create server config: %s", err)
+	}
+
+	address, err := utils.GetFreeAddress()
+	if err != nil {
+		t.Fatal("Failed when retrieving free address")
+	}
+
+	server := NewServer()
+	server.TLSConfig = serverConfig
+
+	err = server.Start(address)
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	// answer back to every request
+	go func() {
+		for data := range server.ReceiveChannel {
+			_ = data.Connection.Send(&Packet{Code: HvsResponse, Height: data.Packet.Height})
+			data.Done()
+		}
+	}()
+
+	// request a packet with the given client certificate and key
+	request := func(certPEM, keyPEM []byte) error {
+		config, err := NewClientTLSConfig(certPEM, keyPEM, caPEM, "")
+		if err != nil {
+			return err
+		}
+
+		conn, err := ConnectTLS(address, config)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		err = conn.Send(&Packet{Code: HvsRequest, Height: 1})
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.ReceiveWithDeadline(time.Now().Add(5 * time.Second))
+		return err
+	}
+
+	if err := request(monitorCert, monitorKey); err != nil {
+		t.Fatalf("Allowed client failed to get a response: %s", err)
+	}
+
+	if err := request(intruderCert, intruderKey); err == nil {
+		t.Fatal("Client with a certificate not allowed should have been rejected")
+	}
+
+	if err := request(untrustedCert, untrustedKey); err == nil {
+		t.Fatal("Client with a certificate signed by another authority should have been rejected")
+	}
+
+	// plain connections are not accepted
+	if _, err := Connect(address); err == nil {
+		t.Fatal("Plain connection should have been rejected")
+	}
+}
+
+func Test_Handshake(t *testing.T) {
+
+	server := NewServer()
+
+	// compatible peers negotiate the features supported by both
+	clientConn, serverConn := net.Pipe()
+	go server.HandleConnection(&Connection{Conn: serverConn})
+
+	client, err := newClientConnection(clientConn, nil, "pipe", RoleMonitor)
+	if err != nil {
+		t.Fatalf("Handshake failed: %s", err)
+	}
+
+	if client.PeerRole() != RoleValidator || !client.HasFeature(FeatureStreaming) || !client.HasFeature(FeatureSignatures) || client.HasFeature("compression") {
+		t.Fatal("Wrong role or features negotiated")
+	}
+	client.Close()
+
+	// incompatible peers receive an explicit error
+	wrongPackets := []*Packet{
+		{Code: Hello, Hello: &HelloMessage{Version: ProtocolVersion + 1, Role: RoleMonitor}},
+		{Code: HvsRequest, Height: 1},
+	}
+
+	for _, wrongPacket := range wrongPackets {
+		clientConn, serverConn := net.Pipe()
+		go server.HandleConnection(&Connection{Conn: serverConn})
+
+		client := &Connection{Conn: clientConn}
+		err := client.Send(wrongPacket)
+		if err != nil {
+			t.Fatalf("Failed to send packet: %s", err)
+		}
+
+		packet, err := client.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive error packet: %s", err)
+		}
+
+		if packet.Code != Error || packet.ErrorMessage == "" {
+			t.Fatal("Incompatible peer should have received an error packet")
+		}
+
+		// the server closes the connection after the error
+		_, err = client.Receive()
+		if err == nil {
+			t.Fatal("Connection should have been closed by the server")
+		}
+		client.Close()
+	}
+}
+
+// send a request from a client to the server and check that the server receives it
+func checkTransport(t *testing.T, transport Transport, address string) {
+
+	listener, err := transport.Listen(address)
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer listener.Close()
+
+	server := NewServer()
+	server.Transport = transport
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	connClient, err := Dial(transport, address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	err = connClient.Send(&Packet{Code: HvsRequest, Height: 7})
+	if err != nil {
+		t.Fatalf("Failed to send packet on client: %s", err)
+	}
+
+	clientData := <-server.ReceiveChannel
+	if clientData.Packet.Height != 7 {
+		t.Fatal("Failed to send/receive correct packet")
+	}
+}
+
+func Test_UnixTransport(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	transport, err := GetTransport(Unix)
+	if err != nil {
+		t.Fatalf("Failed to get transport: %s", err)
+	}
+
+	checkTransport(t, transport, filepath.Join(directory, "validator.sock"))
+}
+
+func Test_MemoryTransport(t *testing.T) {
+
+	transport := NewMemoryTransport()
+
+	checkTransport(t, transport, "validator")
+
+	// the address is free again after the listener has been closed
+	if _, err := Dial(transport, "validator", nil); err == nil {
+		t.Fatal("Connection should have not been successful")
+	}
+
+	listener, err := transport.Listen("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer listener.Close()
+
+	if _, err := transport.Listen("validator"); err == nil {
+		t.Fatal("Should have failed listening on an address already in use")
+	}
+
+	// nobody accepts the connections on the listener
+	transport.DialTimeout = 100 * time.Millisecond
+	if _, err := transport.Dial("validator"); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("Connection should have timed out: %v", err)
+	}
+
+	// the listener is closed while dialing
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = listener.Close()
+	}()
+	transport.DialTimeout = 0
+	start := time.Now()
+	if _, err := transport.Dial("validator"); err == nil || time.Since(start) > time.Second {
+		t.Fatal("Connection should have failed when the listener has been closed")
+	}
+
+	if _, err := GetTransport("udp"); err == nil {
+		t.Fatal("Should have failed with an unknown transport")
+	}
+}
+
+// wait for a packet on the server channel, nil if nothing is received before the timeout
+func receiveWithTimeout(server *Server, timeout time.Duration) *Packet {
+	select {
+	case clientData := <-server.ReceiveChannel:
+		return clientData.Packet
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func Test_FaultInjection(t *testing.T) {
+
+	transport := NewFaultyTransport(NewMemoryTransport())
+
+	listener, err := transport.Listen("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer listener.Close()
+
+	server := NewServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	connClient, err := Dial(transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	request := &Packet{Code: HvsRequest, Height: 7}
+
+	// latency
+	transport.SetRules(&FaultRule{Address: "validator", Latency: 300})
+	start := time.Now()
+	_ = connClient.Send(request)
+	if receiveWithTimeout(server, time.Second) == nil || time.Since(start) < 300*time.Millisecond {
+		t.Fatal("Packet should have been delivered with latency")
+	}
+
+	// drops
+	transport.SetRules(&FaultRule{DropRate: 1})
+	_ = connClient.Send(request)
+	if receiveWithTimeout(server, 200*time.Millisecond) != nil {
+		t.Fatal("Packet should have been dropped")
+	}
+
+	// duplicates
+	transport.SetRules(&FaultRule{Address: "validator", DuplicateRate: 1})
+	_ = connClient.Send(request)
+	if receiveWithTimeout(server, time.Second) == nil || receiveWithTimeout(server, time.Second) == nil {
+		t.Fatal("Packet should have been duplicated")
+	}
+
+	// half-open connection
+	transport.SetRules(&FaultRule{Address: "validator", HalfOpen: true})
+	err = connClient.Send(request)
+	if err != nil || receiveWithTimeout(server, 200*time.Millisecond) != nil {
+		t.Fatal("Packet should have been silently lost")
+	}
+
+	// corruption
+	transport.SetRules(&FaultRule{Address: "validator", CorruptRate: 1})
+	_ = connClient.Send(request)
+	if packet := receiveWithTimeout(server, 200*time.Millisecond); packet != nil && packet.Height == request.Height {
+		t.Fatal("Packet should have been corrupted")
+	}
+
+	// partition
+	transport.Partition("validator")
+	if _, err := Dial(transport, "validator", nil); err == nil {
+		t.Fatal("Connection should have not been successful")
+	}
+
+	transport.Heal("validator")
+	healedClient, err := Dial(transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server after healing the partition: %s", err)
+	}
+	healedClient.Close()
+}
+
+func Test_FaultInjectionWithTLS(t *testing.T) {
+
+	ca, caKey, caPEM, _ := generateCertificate(t, "ca", true, nil, nil)
+	_, _, serverCert, serverKey := generateCertificate(t, "validator", false, ca, caKey)
+	_, _, monitorCert, monitorKey := generateCertificate(t, "monitor", false, ca, caKey)
+
+	serverConfig, err := NewServerTLSConfig(serverCert, serverKey, caPEM, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server config: %s", err)
+	}
+	clientConfig, err := NewClientTLSConfig(monitorCert, monitorKey, caPEM, "")
+	if err != nil {
+		t.Fatalf("Failed to create client config: %s", err)
+	}
+
+	address, err := utils.GetFreeAddress()
+	if err != nil {
+		t.Fatal("Failed when retrieving free address")
+	}
+
+	transport := NewFaultyTransport(&NetTransport{Network: TCP})
+
+	listener, err := transport.Listen(address)
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer listener.Close()
+
+	server := NewServer()
+	server.TLSConfig = serverConfig
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	connClient, err := Dial(transport, address, clientConfig)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	// frames larger than a TLS record are duplicated as a whole, not record by record
+	request := &Packet{Code: HvsRequest, Height: 7, Hvs: createLargeHvs(10, 200)}
+	transport.SetRules(&FaultRule{Address: address, DuplicateRate: 1})
+	err = connClient.Send(request)
+	if err != nil {
+		t.Fatalf("Failed to send packet: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		packet := receiveWithTimeout(server, time.Second)
+		if packet == nil || len(packet.Hvs.VoteSetMap) != len(request.Hvs.VoteSetMap) {
+			t.Fatal("Packet should have been duplicated")
+		}
+	}
+
+	transport.SetRules(&FaultRule{Address: address, DropRate: 1})
+	_ = connClient.Send(request)
+	if receiveWithTimeout(server, 200*time.Millisecond) != nil {
+		t.Fatal("Packet should have been dropped")
+	}
+
+	// the connection is still usable after the faults
+	transport.SetRules()
+	_ = connClient.Send(request)
+	if receiveWithTimeout(server, time.Second) == nil {
+		t.Fatal("Packet should have been delivered")
+	}
+}
+
+// transport dialing from the given local ip
+type localTransport struct {
+	ip string
+}
+
+func (transport *localTransport) Dial(address string) (net.Conn, error) {
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(transport.ip)}, Timeout: time.Second}
+	return dialer.Dial(TCP, address)
+}
+
+func (transport *localTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen(TCP, address)
+}
+
+func Test_FaultInjectionPerPeer(t *testing.T) {
+
+	address, err := utils.GetFreeAddress()
+	if err != nil {
+		t.Fatal("Failed when retrieving free address")
+	}
+
+	// the server isolates only one of its peers
+	transport := NewFaultyTransport(&NetTransport{Network: TCP}, &FaultRule{Address: address, Peer: "127.0.0.2", Partitioned: true})
+
+	listener, err := transport.Listen(address)
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer listener.Close()
+
+	server := NewServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	connClient, err := Dial(&localTransport{ip: "127.0.0.1"}, address, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	connClient.Close()
+
+	if _, err := Dial(&localTransport{ip: "127.0.0.2"}, address, nil); err == nil {
+		t.Fatal("Connection from the partitioned peer should have not been successful")
+	}
+
+	// the rule doesn't apply to the connections dialed
+	connClient, err = Dial(transport, address, nil)
+	if err != nil {
+		t.Fatalf("Rule for a peer should not apply to the connections dialed: %s", err)
+	}
+	connClient.Close()
+}
+
+func Test_ServerShutdown(t *testing.T) {
+
+	server := NewServer()
+	server.Transport = NewMemoryTransport()
+
+	err := server.Start("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+
+	connClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	_ = connClient.Send(&Packet{Code: HvsRequest, Height: 1})
+	request := <-server.ReceiveChannel
+
+	shutdownCompleted := make(chan error)
+	go func() {
+		shutdownCompleted <- server.Shutdown(context.Background())
+	}()
+
+	for !server.isShuttingDown() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// new connections are refused, but the shutdown waits for the request in flight
+	if _, err := Dial(server.Transport, "validator", nil); err == nil {
+		t.Fatal("Connection should have been refused during shutdown")
+	}
+
+	select {
+	case <-shutdownCompleted:
+		t.Fatal("Shutdown should wait for the requests in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the response can still be sent
+	responseReceived := make(chan error)
+	go func() {
+		_, err := connClient.Receive()
+		responseReceived <- err
+	}()
+
+	err = request.Connection.Send(&Packet{Code: HvsResponse, Height: 1})
+	if err != nil {
+		t.Fatalf("Failed to send response during shutdown: %s", err)
+	}
+	request.Done()
+
+	if err := <-responseReceived; err != nil {
+		t.Fatalf("Failed to receive response: %s", err)
+	}
+
+	if err := <-shutdownCompleted; err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+
+	if _, open := <-server.ReceiveChannel; open {
+		t.Fatal("Receive channel should have been closed")
+	}
+
+	// requests never handled don't block the shutdown after the context expires
+	server = NewServer()
+	server.Transport = NewMemoryTransport()
+	_ = server.Start("validator")
+
+	connClient, err = Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	_ = connClient.Send(&Packet{Code: HvsRequest, Height: 1})
+	<-server.ReceiveChannel
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Shutdown should have expired")
+	}
+}
+
+func Test_ServerConnectionLimits(t *testing.T) {
+
+	server := NewServer()
+	server.Transport = NewMemoryTransport()
+	server.MaxConnections = 1
+	server.IdleTimeout = 200 * time.Millisecond
+
+	err := server.Start("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	connClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	// too many connections
+	_, err = Dial(server.Transport, "validator", nil)
+	if err == nil || !strings.Contains(err.Error(), "too many connections") {
+		t.Fatalf("Connection should have been refused because of the limit: %s", err)
+	}
+
+	// the idle connection is closed by the server, then new clients can connect
+	if _, err := connClient.Receive(); err == nil {
+		t.Fatal("Idle connection should have been closed")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	otherClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server after the idle connection was closed: %s", err)
+	}
+	otherClient.Close()
+}
+
+func Test_ServerRefusedConnectionsBounded(t *testing.T) {
+
+	server := NewServer()
+	server.Transport = NewMemoryTransport()
+	server.MaxConnections = 1
+
+	err := server.Start("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+
+	connClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	// clients refused that never send their hello message
+	for i := 0; i < 2*maxRefusing; i++ {
+		conn, err := server.Transport.Dial("validator")
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %s", err)
+		}
+		defer conn.Close()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	server.mutex.Lock()
+	refusing := len(server.refusing)
+	server.mutex.Unlock()
+	if refusing > maxRefusing {
+		t.Fatalf("Too many connections being refused: %d", refusing)
+	}
+
+	// the connections being refused are closed on shutdown, without waiting for their deadline
+	start := time.Now()
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down server: %s", err)
+	}
+
+	if time.Since(start) > refuseDeadline*time.Second/2 {
+		t.Fatal("Shutdown waited for the connections being refused")
+	}
+}
+
+// start a server on a memory transport with the given queue policy and size
+func startQueueTestServer(t *testing.T, policy string, size int) *Server {
+	server := NewServer()
+	server.Transport = NewMemoryTransport()
+	server.QueuePolicy = policy
+	server.QueueSize = size
+
+	err := server.Start("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+
+	return server
+}
+
+// wait until the server has the given number of requests queued
+func waitQueueDepth(t *testing.T, server *Server, depth int) {
+	for i := 0; i < 100; i++ {
+		if server.QueueDepth() == depth {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Queue depth is %d instead of %d", server.QueueDepth(), depth)
+}
+
+func Test_ServerQueuePolicies(t *testing.T) {
+
+	// drop: requests that don't fit in the queue are answered with an error
+	server := startQueueTestServer(t, DropPolicy, 1)
+	server.DroppedCounter = metrics.NewRegistry().NewCounter("dropped", "")
+	server.QueueDepthGauge = metrics.NewRegistry().NewGauge("depth", "")
+
+	connClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	errorReceived := make(chan *Packet)
+	go func() {
+		packet, _ := connClient.Receive()
+		errorReceived <- packet
+	}()
+
+	// one request is held by the dispatcher, one is queued, the others are dropped
+	for height := uint64(1); height <= 3; height++ {
+		_ = connClient.Send(&Packet{Code: HvsRequest, Height: height})
+	}
+
+	packet := <-errorReceived
+	if packet == nil || packet.Code != Error || !strings.Contains(packet.ErrorMessage, "queue full") {
+		t.Fatal("Client should have been notified that the request was dropped")
+	}
+	if server.DroppedCounter.Value() == 0 {
+		t.Fatal("Dropped requests not counted")
+	}
+	if server.QueueDepth() != 1 || server.QueueDepthGauge.Value() != 1 {
+		t.Fatalf("Queue depth is %d (gauge %f) instead of 1", server.QueueDepth(), server.QueueDepthGauge.Value())
+	}
+
+	// block: the client waits until there's space in the queue, nothing is lost
+	server = startQueueTestServer(t, BlockPolicy, 1)
+
+	connClient, err = Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer connClient.Close()
+
+	sent := make(chan struct{})
+	go func() {
+		for height := uint64(1); height <= 5; height++ {
+			_ = connClient.Send(&Packet{Code: HvsRequest, Height: height})
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("Client should be blocked while the queue is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for height := uint64(1); height <= 5; height++ {
+		packet := receiveWithTimeout(server, time.Second)
+		if packet == nil || packet.Height != height {
+			t.Fatalf("Request for height %d not delivered", height)
+		}
+	}
+	<-sent
+
+	// fair: requests of different clients are delivered in round robin
+	server = startQueueTestServer(t, FairPolicy, 2)
+
+	firstClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer firstClient.Close()
+
+	for height := uint64(1); height <= 3; height++ {
+		_ = firstClient.Send(&Packet{Code: HvsRequest, Height: height})
+	}
+	waitQueueDepth(t, server, 2)
+
+	secondClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+	defer secondClient.Close()
+
+	_ = secondClient.Send(&Packet{Code: HvsRequest, Height: 10})
+	waitQueueDepth(t, server, 3)
+
+	for _, height := range []uint64{1, 10, 2, 3} {
+		packet := receiveWithTimeout(server, time.Second)
+		if packet == nil || packet.Height != height {
+			t.Fatalf("Request for height %d not delivered in round robin", height)
+		}
+	}
+}
+
+func Test_Multiplexer(t *testing.T) {
+
+	server := NewServer()
+	server.Transport = NewMemoryTransport()
+
+	err := server.Start("validator")
+	if err != nil {
+		t.Fatalf("Failed while start listening: %s", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	// answer the requests in reverse order, after a delay given by the round requested
+	go func() {
+		for {
+			first, open := <-server.ReceiveChannel
+			if !open {
+				return
+			}
+			second := <-server.ReceiveChannel
+
+			for _, request := range []*ClientData{second, first} {
+				time.Sleep(time.Duration(request.Packet.Filter.FromRound) * time.Millisecond)
+				request.Packet.Code = HvsResponse
+				_ = request.Connection.Send(request.Packet)
+				request.Done()
+			}
+		}
+	}()
+
+	connClient, err := Dial(server.Transport, "validator", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %s", err)
+	}
+
+	mux := NewMultiplexer(connClient)
+	defer mux.Close()
+
+	// concurrent requests on the same connection receive their own response
+	responses := make(chan error, 2)
+	for height := uint64(1); height <= 2; height++ {
+		go func(height uint64) {
+			packet, err := mux.Request(&Packet{Code: HvsRequest, Height: height, Filter: &Filter{}}, time.Now().Add(time.Second))
+			if err == nil && packet.Height != height {
+				err = fmt.Errorf("response for height %d received instead of %d", packet.Height, height)
+			}
+			responses <- err
+		}(height)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-responses; err != nil {
+			t.Fatalf("Failed to receive response: %s", err)
+		}
+	}
+
+	// the response arriving after the deadline is discarded and doesn't answer the next request
+	stale := staleResponses.Value()
+	go func() {
+		_, err := mux.Request(&Packet{Code: HvsRequest, Height: 3, Filter: &Filter{FromRound: 300}}, time.Now().Add(100*time.Millisecond))
+		responses <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	packet, err := mux.Request(&Packet{Code: HvsRequest, Height: 4, Filter: &Filter{}}, time.Now().Add(time.Second))
+	if err != nil || packet.Height != 4 {
+		t.Fatalf("Failed to receive response for height 4: %s", err)
+	}
+
+	if err := <-responses; err == nil {
+		t.Fatal("Request should have expired")
+	}
+
+	if mux.Pending() != 0 {
+		t.Fatal("Expired request still pending")
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if staleResponses.Value() != stale+1 {
+		t.Fatal("Late response should have been discarded")
+	}
+
+	// requests fail after the multiplexer is closed
+	mux.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := mux.Request(&Packet{Code: HvsRequest, Height: 5}, time.Now().Add(time.Second)); err == nil {
+		t.Fatal("Request should fail after the multiplexer is closed")
+	}
+
+	// a response without request id doesn't answer the only request pending
+	clientConn, serverConn := net.Pipe()
+	serverPipe := &Connection{Conn: serverConn}
+	defer serverPipe.Close()
+
+	mux = NewMultiplexer(&Connection{Conn: clientConn})
+	defer mux.Close()
+
+	go func() {
+		request, err := serverPipe.Receive()
+		if err == nil {
+			_ = serverPipe.Send(&Packet{Code: HvsResponse, Height: request.Height})
+		}
+	}()
+
+	stale = staleResponses.Value()
+	if _, err := mux.Request(&Packet{Code: HvsRequest, Height: 6}, time.Now().Add(200*time.Millisecond)); err == nil {
+		t.Fatal("Response without request id should have been discarded")
+	}
+	if staleResponses.Value() != stale+1 {
+		t.Fatal("Response without request id should have been counted as stale")
+	}
+}
+
+func Test_ErrorPacket(t *testing.T) {
+
+	request := &Packet{Code: HvsRequest, Height: 3, RequestID: 7}
+
+	packet := NewErrorPacket(request, ReasonUnknownHeight, "no message logs for height 3")
+	if packet.Code != Error || packet.Height != 3 || packet.RequestID != 7 {
+		t.Fatal("Error response does not refer to the request")
+	}
+
+	if packet.Retryable() || !NewErrorPacket(request, ReasonRateLimited, "").Retryable() {
+		t.Fatal("Wrong retry decision for error reasons")
+	}
+
+	if packet.ErrorString() != "unknown height: no message logs for height 3" || ReasonString(42) != "unknown reason 42" {
+		t.Fatalf("Wrong error description: %s", packet.ErrorString())
+	}
+
+	// the reason is covered by the signature
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	packet.ID = "1"
+	if err := packet.Sign(privateKey); err != nil {
+		t.Fatalf("Failed to sign error packet: %s", err)
+	}
+
+	packet.Reason = ReasonRoundsPruned
+	if packet.VerifySignature(publicKey) {
+		t.Fatal("Signature should not be valid after changing the reason")
+	}
+}
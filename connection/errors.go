@@ -0,0 +1,63 @@
+package connection
+
+import "fmt"
+
+// reasons why a request can't be served, sent in error responses (Error code)
+const (
+	ReasonUnspecified   = 0
+	ReasonUnknownHeight = 1
+	ReasonRoundsPruned  = 2
+	ReasonRateLimited   = 3
+	ReasonUnauthorized  = 4
+	ReasonInternalError = 5
+)
+
+// names of the reasons, used in logs and reports
+var reasonNames = map[uint32]string{
+	ReasonUnspecified:   "unspecified",
+	ReasonUnknownHeight: "unknown height",
+	ReasonRoundsPruned:  "rounds pruned",
+	ReasonRateLimited:   "rate limited",
+	ReasonUnauthorized:  "unauthorized",
+	ReasonInternalError: "internal error",
+}
+
+// NewErrorPacket creates an error response to the given request (nil if the error doesn't refer to a request)
+func NewErrorPacket(request *Packet, reason uint32, message string) *Packet {
+	packet := &Packet{Code: Error, Reason: reason, ErrorMessage: message}
+
+	if request != nil {
+		packet.Height = request.Height
+		packet.RequestID = request.RequestID
+		packet.Filter = request.Filter
+	}
+
+	return packet
+}
+
+// ReasonString returns the name of the reason of an error response
+func ReasonString(reason uint32) string {
+	name, loaded := reasonNames[reason]
+	if !loaded {
+		return fmt.Sprintf("unknown reason %d", reason)
+	}
+	return name
+}
+
+// Retryable returns true if the request that caused the error response may succeed later (e.g. the validator is overloaded)
+// the other errors are final: the validator doesn't have the message logs requested or won't send them
+func (packet *Packet) Retryable() bool {
+	switch packet.Reason {
+	case ReasonUnknownHeight, ReasonRoundsPruned, ReasonUnauthorized:
+		return false
+	}
+	return true
+}
+
+// ErrorString returns the reason and the message of an error response
+func (packet *Packet) ErrorString() string {
+	if packet.ErrorMessage == "" {
+		return ReasonString(packet.Reason)
+	}
+	return ReasonString(packet.Reason) + ": " + packet.ErrorMessage
+}
@@ -0,0 +1,287 @@
+package connection
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// FaultRule describes the faults injected in the connections with a peer
+// faults are applied to every frame written on the connection, from both sides if they use the same faulty transport
+type FaultRule struct {
+	// address of the peer (the one dialed or listened on), the rule applies to all the peers if empty
+	Address string `yaml:"address"`
+	// remote address (host or host:port) of the peers connecting to the address listened on, the rule applies to all of them if empty
+	// it's ignored for the connections dialed, so a rule with a peer only applies to the connections accepted
+	Peer string `yaml:"peer"`
+
+	// delay (in milliseconds) added before sending each frame, plus a random delay up to the jitter
+	Latency uint64 `yaml:"latency"`
+	Jitter  uint64 `yaml:"jitter"`
+
+	// probabilities, between 0 and 1, that a frame is dropped, sent twice or sent with a corrupted byte
+	DropRate      float64 `yaml:"dropRate"`
+	DuplicateRate float64 `yaml:"duplicateRate"`
+	CorruptRate   float64 `yaml:"corruptRate"`
+
+	// connections are established but no data is delivered in either direction
+	HalfOpen bool `yaml:"halfOpen"`
+	// connections can't be established and no data is delivered on the existing ones
+	Partitioned bool `yaml:"partitioned"`
+}
+
+// FaultScenario is a set of fault rules loaded from a yaml file
+type FaultScenario struct {
+	// seed of the random generator, to reproduce the same faults
+	Seed  int64        `yaml:"seed"`
+	Rules []*FaultRule `yaml:"rules"`
+}
+
+// FaultyTransport wraps a transport and injects the faults described by its rules in the connections
+// rules can be changed at any time and apply to the existing connections too
+type FaultyTransport struct {
+	Transport Transport
+
+	rules  []*FaultRule
+	random *rand.Rand
+	mutex  sync.Mutex
+}
+
+// NewFaultyTransport creates a new FaultyTransport on top of the given transport
+func NewFaultyTransport(transport Transport, rules ...*FaultRule) *FaultyTransport {
+	return &FaultyTransport{
+		Transport: transport,
+		rules:     rules,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// LoadFaultScenario creates a new FaultyTransport on top of the given transport with the rules of a scenario file (relative to the project root directory)
+func LoadFaultScenario(scenarioFile string, transport Transport) (*FaultyTransport, error) {
+	scenario := &FaultScenario{}
+	err := utils.ParseConfigFile(scenarioFile, scenario)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading fault scenario: %s", err)
+	}
+
+	faultyTransport := NewFaultyTransport(transport, scenario.Rules...)
+	if scenario.Seed != 0 {
+		faultyTransport.random = rand.New(rand.NewSource(scenario.Seed))
+	}
+
+	return faultyTransport, nil
+}
+
+// SetRules replaces all the rules of the transport
+func (ft *FaultyTransport) SetRules(rules ...*FaultRule) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	ft.rules = rules
+}
+
+// AddRule adds a rule to the transport, it takes precedence over the previous rules for the same address
+func (ft *FaultyTransport) AddRule(rule *FaultRule) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	ft.rules = append(ft.rules, rule)
+}
+
+// Partition isolates the peer at the given address
+func (ft *FaultyTransport) Partition(address string) {
+	ft.AddRule(&FaultRule{Address: address, Partitioned: true})
+}
+
+// Heal removes all the rules for the peer at the given address
+func (ft *FaultyTransport) Heal(address string) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	rules := make([]*FaultRule, 0, len(ft.rules))
+	for _, rule := range ft.rules {
+		if rule.Address != address {
+			rules = append(rules, rule)
+		}
+	}
+	ft.rules = rules
+}
+
+// Dial connects to the given address, unless the peer is partitioned
+func (ft *FaultyTransport) Dial(address string) (net.Conn, error) {
+	rule := ft.getRule(address, "")
+	if rule != nil && rule.Partitioned {
+		return nil, fmt.Errorf("network partition with %s", address)
+	}
+
+	conn, err := ft.Transport.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultyConn{Conn: conn, transport: ft, address: address}, nil
+}
+
+// Listen starts listening on the given address, faults are injected in the connections accepted
+func (ft *FaultyTransport) Listen(address string) (net.Listener, error) {
+	listener, err := ft.Transport.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultyListener{Listener: listener, transport: ft, address: address}, nil
+}
+
+// get the rule for the given address and remote peer (empty for the connections dialed)
+// it's the last one given for the address or, if none, the last one for all addresses
+func (ft *FaultyTransport) getRule(address, peer string) *FaultRule {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	var generic *FaultRule
+	for i := len(ft.rules) - 1; i >= 0; i-- {
+		rule := ft.rules[i]
+		if !rule.matchesPeer(peer) {
+			continue
+		}
+		if rule.Address == address {
+			return rule
+		}
+		if rule.Address == "" && generic == nil {
+			generic = rule
+		}
+	}
+
+	return generic
+}
+
+// check if the rule applies to the given remote peer, either by its whole address or by its host
+func (rule *FaultRule) matchesPeer(peer string) bool {
+	if rule.Peer == "" || rule.Peer == peer {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(peer)
+	return err == nil && rule.Peer == host
+}
+
+// return true with the given probability
+func (ft *FaultyTransport) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return ft.random.Float64() < probability
+}
+
+// get a random number in [0, n)
+func (ft *FaultyTransport) randomInt(n int) int {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return ft.random.Intn(n)
+}
+
+// listener that wraps the connections accepted
+type faultyListener struct {
+	net.Listener
+	transport *FaultyTransport
+	address   string
+}
+
+// Accept waits for the next connection, connections from partitioned peers are closed immediately
+func (listener *faultyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		peer := conn.RemoteAddr().String()
+
+		rule := listener.transport.getRule(listener.address, peer)
+		if rule != nil && rule.Partitioned {
+			_ = conn.Close()
+			continue
+		}
+
+		return &faultyConn{Conn: conn, transport: listener.transport, address: listener.address, peer: peer}, nil
+	}
+}
+
+// connection that discards the data sent and received when isolated
+// the other faults are applied by the connection on top of it to every frame written (see writeFrame), since a write doesn't carry a whole frame under TLS
+type faultyConn struct {
+	net.Conn
+	transport *FaultyTransport
+	address   string
+	peer      string
+}
+
+// check if no data is delivered on the connection
+func (conn *faultyConn) isolated() bool {
+	rule := conn.transport.getRule(conn.address, conn.peer)
+	return rule != nil && (rule.HalfOpen || rule.Partitioned)
+}
+
+// Read reads data from the connection, discarding it while the peer is isolated
+func (conn *faultyConn) Read(b []byte) (int, error) {
+	for {
+		n, err := conn.Conn.Read(b)
+		if err != nil || !conn.isolated() {
+			return n, err
+		}
+	}
+}
+
+// Write writes data on the connection, pretending it has been sent while the peer is isolated
+func (conn *faultyConn) Write(b []byte) (int, error) {
+	if conn.isolated() {
+		return len(b), nil
+	}
+	return conn.Conn.Write(b)
+}
+
+// write a whole frame with the given function, applying the faults of the rule for the peer
+func (conn *faultyConn) writeFrame(frame []byte, write func([]byte) (int, error)) (int, error) {
+	rule := conn.transport.getRule(conn.address, conn.peer)
+	if rule == nil {
+		return write(frame)
+	}
+
+	// pretend the frame has been sent
+	if rule.HalfOpen || rule.Partitioned || conn.transport.chance(rule.DropRate) {
+		return len(frame), nil
+	}
+
+	delay := time.Duration(rule.Latency) * time.Millisecond
+	if rule.Jitter > 0 {
+		delay += time.Duration(conn.transport.randomInt(int(rule.Jitter))) * time.Millisecond
+	}
+	time.Sleep(delay)
+
+	data := frame
+	// corrupt a byte after the length prefix, so that the frame is still read entirely
+	if len(frame) > frameHeaderSize && conn.transport.chance(rule.CorruptRate) {
+		data = make([]byte, len(frame))
+		copy(data, frame)
+		data[frameHeaderSize+conn.transport.randomInt(len(frame)-frameHeaderSize)] ^= 0xFF
+	}
+
+	n, err := write(data)
+	if err != nil {
+		return n, err
+	}
+
+	if conn.transport.chance(rule.DuplicateRate) {
+		_, err = write(data)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
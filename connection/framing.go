@@ -0,0 +1,218 @@
+package connection
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"go.dedis.ch/protobuf"
+)
+
+// get the maximum frame size of the connection
+func (c *Connection) maxFrameSize() uint32 {
+	if c.MaxFrameSize == 0 {
+		return defaultMaxFrameSize
+	}
+	return c.MaxFrameSize
+}
+
+// get the maximum size of a packet streamed in several frames
+func (c *Connection) maxPacketSize() uint64 {
+	if c.MaxPacketSize == 0 {
+		return maxPacketFrames * uint64(c.maxFrameSize())
+	}
+	return c.MaxPacketSize
+}
+
+// get the maximum number of frames of a packet, enough for a packet of the maximum size split in frames half full
+func (c *Connection) maxPacketChunks() int {
+	chunks := 2*c.maxPacketSize()/uint64(c.maxFrameSize()) + 1
+	if chunks < maxPacketChunks {
+		return maxPacketChunks
+	}
+	return int(chunks)
+}
+
+// encode a packet and write it in a single frame, prefixed by its length
+func (c *Connection) writeFrame(packet *Packet) error {
+	messageEncoded, err := protobuf.Encode(packet)
+	if err != nil {
+		return fmt.Errorf("error while serializing the packet to send: %s", err)
+	}
+
+	if uint64(len(messageEncoded)) > uint64(c.maxFrameSize()) {
+		return fmt.Errorf("frame size %d exceeds the maximum frame size %d", len(messageEncoded), c.maxFrameSize())
+	}
+
+	frame := make([]byte, frameHeaderSize+len(messageEncoded))
+	binary.BigEndian.PutUint32(frame[:frameHeaderSize], uint32(len(messageEncoded)))
+	copy(frame[frameHeaderSize:], messageEncoded)
+
+	var n int
+	if faults := c.faultInjector(); faults != nil {
+		n, err = faults.writeFrame(frame, c.Conn.Write)
+	} else {
+		n, err = c.Conn.Write(frame)
+	}
+	bytesSent.Add(uint64(n))
+
+	return err
+}
+
+// get the faulty connection below the connection (and below TLS, if used), nil if faults are not injected
+func (c *Connection) faultInjector() *faultyConn {
+	if c.faults != nil {
+		return c.faults
+	}
+	faults, _ := c.Conn.(*faultyConn)
+	return faults
+}
+
+// read a whole frame, regardless of how the bytes have been fragmented by the network
+func (c *Connection) readFrame() ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+
+	n, err := io.ReadFull(c.Conn, header)
+	bytesReceived.Add(uint64(n))
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > c.maxFrameSize() {
+		return nil, fmt.Errorf("frame size %d exceeds the maximum frame size %d", length, c.maxFrameSize())
+	}
+
+	frame := make([]byte, length)
+
+	n, err = io.ReadFull(c.Conn, frame)
+	bytesReceived.Add(uint64(n))
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// split a packet in several chunks if it doesn't fit in a single frame
+// each chunk contains a subset of the rounds of the height vote set and all the other fields of the packet
+func (c *Connection) splitPacket(packet *Packet) ([]*Packet, error) {
+	size, err := encodedSize(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	// the packet is sent as it is if the peer doesn't support streaming
+	if size <= uint64(c.maxFrameSize()) || packet.Hvs == nil || (c.features != nil && !c.HasFeature(FeatureStreaming)) {
+		return []*Packet{packet}, nil
+	}
+
+	if size > c.maxPacketSize() {
+		return nil, fmt.Errorf("packet size %d exceeds the maximum packet size %d", size, c.maxPacketSize())
+	}
+
+	rounds := make([]uint64, 0, len(packet.Hvs.VoteSetMap))
+	for round := range packet.Hvs.VoteSetMap {
+		rounds = append(rounds, round)
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+
+	chunks := make([]*Packet, 0)
+	chunk := newChunk(packet)
+
+	// size of a chunk without rounds
+	emptySize, err := encodedSize(chunk)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := emptySize
+
+	for _, round := range rounds {
+		vs := packet.Hvs.VoteSetMap[round]
+		if vs == nil {
+			continue
+		}
+
+		roundSize, err := encodedSize(vs)
+		if err != nil {
+			return nil, err
+		}
+		// bound on the size of the map entry (tag, key and length prefix) containing the round
+		roundSize += mapEntryOverhead
+
+		if emptySize+roundSize > uint64(c.maxFrameSize()) {
+			return nil, fmt.Errorf("round %d of the height vote set exceeds the maximum frame size %d", round, c.maxFrameSize())
+		}
+
+		// close the current chunk and move the round to a new one
+		if chunkSize+roundSize > uint64(c.maxFrameSize()) {
+			chunks = append(chunks, chunk)
+			chunk = newChunk(packet)
+			chunkSize = emptySize
+		}
+
+		chunk.Hvs.VoteSetMap[round] = vs
+		chunkSize += roundSize
+	}
+
+	chunk.More = false
+	chunks = append(chunks, chunk)
+
+	if len(chunks) > c.maxPacketChunks() {
+		return nil, fmt.Errorf("packet split in %d frames, more than the maximum %d", len(chunks), c.maxPacketChunks())
+	}
+
+	return chunks, nil
+}
+
+// create a chunk with the same fields of the packet and an empty height vote set
+func newChunk(packet *Packet) *Packet {
+	chunk := *packet
+	chunk.Hvs = common.NewHeightVoteSet()
+	chunk.More = true
+	return &chunk
+}
+
+// add the rounds of the height vote set in a chunk to the packet
+func mergeChunk(packet *Packet, chunk *Packet) error {
+	if chunk.Code != packet.Code || chunk.ID != packet.ID || chunk.Height != packet.Height || chunk.RequestID != packet.RequestID {
+		return fmt.Errorf("error while receiving packet: chunk does not belong to the packet")
+	}
+
+	if chunk.Hvs == nil {
+		return nil
+	}
+
+	if packet.Hvs == nil {
+		packet.Hvs = common.NewHeightVoteSet()
+	}
+
+	// a round can't be sent twice, the second one would silently replace the first one
+	for round := range chunk.Hvs.VoteSetMap {
+		if _, loaded := packet.Hvs.VoteSetMap[round]; loaded {
+			return fmt.Errorf("error while receiving packet: round %d received twice", round)
+		}
+	}
+
+	for round, vs := range chunk.Hvs.VoteSetMap {
+		packet.Hvs.VoteSetMap[round] = vs
+	}
+
+	packet.More = chunk.More
+
+	return nil
+}
+
+// get the size of an encoded structure
+func encodedSize(structure interface{}) (uint64, error) {
+	encoded, err := protobuf.Encode(structure)
+	if err != nil {
+		return 0, fmt.Errorf("error while serializing the packet to send: %s", err)
+	}
+	return uint64(len(encoded)), nil
+}
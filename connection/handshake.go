@@ -0,0 +1,150 @@
+package connection
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ProtocolVersion is the version of the wire protocol, peers with different versions can't communicate
+const ProtocolVersion = 1
+
+// roles of the nodes
+const (
+	RoleMonitor   = "monitor"
+	RoleValidator = "validator"
+	// consensus node recording the messages it sends and receives in its validator
+	RoleNode = "node"
+)
+
+// features that can be negotiated during the handshake
+// responses are signed only if both sides support signatures, height vote sets are streamed only if both sides support streaming
+const (
+	FeatureSignatures = "signatures"
+	FeatureStreaming  = "streaming"
+)
+
+// SupportedFeatures are the features implemented by this version of the library
+var SupportedFeatures = []string{FeatureSignatures, FeatureStreaming}
+
+// HelloMessage is exchanged by the peers when a connection is established
+type HelloMessage struct {
+	Version  uint32
+	Role     string
+	Features []string
+}
+
+// PeerRole returns the role declared by the other side of the connection, empty if the handshake has not been done
+func (c *Connection) PeerRole() string {
+	return c.peerRole
+}
+
+// HasFeature returns true if the feature has been negotiated with the other side of the connection
+func (c *Connection) HasFeature(feature string) bool {
+	return c.features[feature]
+}
+
+// send the hello message and wait for the one of the other side (client side of the handshake)
+func (c *Connection) handshake(role string) error {
+	err := c.Send(newHelloPacket(role))
+	if err != nil {
+		return err
+	}
+
+	packet, err := c.Receive()
+	if err != nil {
+		return err
+	}
+
+	if packet.Code == Error {
+		return fmt.Errorf("rejected by peer: %s", packet.ErrorString())
+	}
+
+	if packet.Code != Hello || packet.Hello == nil {
+		return fmt.Errorf("unexpected packet with code %d instead of hello", packet.Code)
+	}
+
+	if packet.Hello.Version != ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d, expected %d", packet.Hello.Version, ProtocolVersion)
+	}
+
+	c.completeHandshake(packet.Hello)
+
+	return nil
+}
+
+// wait for the hello message of the other side and answer back (server side of the handshake)
+// incompatible peers receive an error packet explaining why the connection is refused
+func (c *Connection) acceptHandshake(role string) error {
+	packet, err := c.Receive()
+	if err != nil {
+		return err
+	}
+
+	var reason string
+	switch {
+	case packet.Code != Hello || packet.Hello == nil:
+		reason = fmt.Sprintf("handshake required, received packet with code %d", packet.Code)
+	case packet.Hello.Version != ProtocolVersion:
+		reason = fmt.Sprintf("unsupported protocol version %d, expected %d", packet.Hello.Version, ProtocolVersion)
+	}
+
+	if reason != "" {
+		_ = c.Send(NewErrorPacket(nil, ReasonUnspecified, reason))
+		return fmt.Errorf("handshake failed: %s", reason)
+	}
+
+	err = c.Send(newHelloPacket(role))
+	if err != nil {
+		return err
+	}
+
+	c.completeHandshake(packet.Hello)
+
+	return nil
+}
+
+// wait for the hello message of the other side and refuse the connection for the given reason, giving up at the deadline
+func (c *Connection) refuseHandshake(reason uint32, message string, deadline time.Time) {
+	_, err := c.ReceiveWithDeadline(deadline)
+	if err != nil {
+		return
+	}
+
+	err = c.Conn.SetWriteDeadline(deadline)
+	if err == nil {
+		_ = c.writeFrame(NewErrorPacket(nil, reason, message))
+	}
+}
+
+// store the role of the peer and the features supported by both sides
+func (c *Connection) completeHandshake(hello *HelloMessage) {
+	c.peerRole = hello.Role
+	c.features = make(map[string]bool)
+
+	for _, feature := range hello.Features {
+		for _, supported := range SupportedFeatures {
+			if feature == supported {
+				c.features[feature] = true
+			}
+		}
+	}
+}
+
+// negotiated features, sorted
+func (c *Connection) negotiatedFeatures() []string {
+	features := make([]string, 0, len(c.features))
+	for feature := range c.features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// create a hello packet for the given role
+func newHelloPacket(role string) *Packet {
+	features := make([]string, len(SupportedFeatures))
+	copy(features, SupportedFeatures)
+
+	return &Packet{Code: Hello, Hello: &HelloMessage{Version: ProtocolVersion, Role: role, Features: features}}
+}
@@ -0,0 +1,12 @@
+package connection
+
+import "github.com/mikanikos/Fork-Accountability/metrics"
+
+// connection statistics, exposed by both the monitor and the validators
+var (
+	bytesSent       = metrics.NewCounter("connection_bytes_sent_total", "Number of bytes sent on all connections")
+	bytesReceived   = metrics.NewCounter("connection_bytes_received_total", "Number of bytes received on all connections")
+	packetsSent     = metrics.NewCounter("connection_packets_sent_total", "Number of packets sent on all connections")
+	packetsReceived = metrics.NewCounter("connection_packets_received_total", "Number of packets received on all connections")
+	staleResponses  = metrics.NewCounter("connection_stale_responses_total", "Number of responses discarded because their request was not pending anymore")
+)
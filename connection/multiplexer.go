@@ -0,0 +1,165 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrMultiplexerClosed is returned for the requests pending or made after the multiplexer has been closed
+var ErrMultiplexerClosed = errors.New("multiplexer closed")
+
+// Multiplexer sends concurrent requests on a single connection and matches the responses with the requests by their request id
+// responses to requests that are not pending anymore (e.g. because their deadline expired) are discarded
+type Multiplexer struct {
+	conn *Connection
+
+	// last request id used, ids start from 1
+	lastID  uint64
+	pending map[uint64]chan *Packet
+	// error that stopped the reception of responses, nil while running
+	err   error
+	mutex sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMultiplexer creates a new Multiplexer on the given connection and starts receiving responses
+// the connection must not be read by anyone else
+func NewMultiplexer(conn *Connection) *Multiplexer {
+	mux := &Multiplexer{
+		conn:    conn,
+		pending: make(map[uint64]chan *Packet),
+		closed:  make(chan struct{}),
+	}
+
+	go mux.receiveResponses()
+
+	return mux
+}
+
+// Request sends a request with a new request id and waits for its response until the deadline
+func (mux *Multiplexer) Request(packet *Packet, deadline time.Time) (*Packet, error) {
+	mux.mutex.Lock()
+	if mux.err != nil {
+		err := mux.err
+		mux.mutex.Unlock()
+		return nil, err
+	}
+
+	mux.lastID++
+	request := *packet
+	request.RequestID = mux.lastID
+
+	response := make(chan *Packet, 1)
+	mux.pending[request.RequestID] = response
+	mux.mutex.Unlock()
+
+	defer mux.forget(request.RequestID)
+
+	err := mux.conn.Send(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case packet, ok := <-response:
+		if !ok {
+			return nil, mux.getError()
+		}
+		return packet, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("deadline expired for request %d", request.RequestID)
+	}
+}
+
+// Close stops receiving responses and closes the connection, pending requests fail
+func (mux *Multiplexer) Close() {
+	mux.closeOnce.Do(func() {
+		close(mux.closed)
+		mux.conn.Close()
+	})
+}
+
+// Pending returns the number of requests waiting for a response
+func (mux *Multiplexer) Pending() int {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	return len(mux.pending)
+}
+
+// receive responses and deliver them to the pending requests, until the connection is closed or fails
+func (mux *Multiplexer) receiveResponses() {
+	for {
+		// wait for responses as long as the connection is open, requests have their own deadlines
+		packet, err := mux.conn.receive(func() time.Time {
+			return time.Time{}
+		})
+
+		if err != nil {
+			select {
+			case <-mux.closed:
+				err = ErrMultiplexerClosed
+			default:
+			}
+			mux.fail(err)
+			return
+		}
+
+		mux.deliver(packet)
+	}
+}
+
+// deliver a response to the request with the same request id
+// a response without request id can't be matched to a request and is discarded, since the handshake guarantees that request ids are sent back
+func (mux *Multiplexer) deliver(packet *Packet) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+
+	requestID := packet.RequestID
+
+	response, loaded := mux.pending[requestID]
+	if !loaded {
+		staleResponses.Inc()
+
+		if debug {
+			log.Printf("Discarding response from %s to request %d, not pending anymore", mux.conn.Conn.RemoteAddr(), packet.RequestID)
+		}
+		return
+	}
+
+	response <- packet
+	delete(mux.pending, requestID)
+}
+
+// make all the pending and future requests fail with the given error
+func (mux *Multiplexer) fail(err error) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+
+	mux.err = err
+	for id, response := range mux.pending {
+		close(response)
+		delete(mux.pending, id)
+	}
+}
+
+// stop waiting for the response to the request
+func (mux *Multiplexer) forget(requestID uint64) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	delete(mux.pending, requestID)
+}
+
+// get the error that stopped the reception of responses
+func (mux *Multiplexer) getError() error {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	return mux.err
+}
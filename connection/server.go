@@ -1,48 +1,278 @@
 package connection
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/metrics"
+)
+
+// ErrServerClosed is returned by Serve and Listen after the server has been shut down
+var ErrServerClosed = errors.New("server closed")
+
+// policies applied when the queue of requests is full
+const (
+	// stop reading from the connection until there's space in the queue
+	BlockPolicy = "block"
+	// drop the request and send an error packet to the client
+	DropPolicy = "drop"
+	// each client has its own queue, blocked when full, and requests are delivered in round robin among clients
+	FairPolicy = "fair"
 )
 
+// queue of requests waiting to be delivered to the receive channel
+type requestQueue struct {
+	requests chan *ClientData
+	// set when no more requests will be added, the queue is removed once empty
+	closed bool
+}
+
 // Server object to handle requests from clients
 type Server struct {
 	ReceiveChannel chan *ClientData
+
+	// maximum size (in bytes) of the frames exchanged with clients, default value used if 0
+	MaxFrameSize uint32
+	// maximum size (in bytes) of a packet streamed in several frames, 16 frames if 0
+	MaxPacketSize uint64
+
+	// configuration used to accept only mutual TLS connections, plain TCP connections if nil
+	TLSConfig *tls.Config
+
+	// role declared in the handshake
+	Role string
+
+	// transport used to listen for incoming connections
+	Transport Transport
+
+	// maximum number of concurrent connections, no limit if 0
+	MaxConnections int
+	// time after which a connection without requests is closed, default read deadline if 0
+	IdleTimeout time.Duration
+
+	// policy applied when the queue is full, block if empty
+	QueuePolicy string
+	// maximum number of requests waiting to be delivered (for each client with the fair policy), default value used if 0
+	QueueSize int
+
+	// statistics updated by the server, optional
+	QueueDepthGauge *metrics.Gauge
+	DroppedCounter  *metrics.Counter
+
+	// listeners and connections to close on shutdown
+	listeners   map[net.Listener]bool
+	connections map[*Connection]bool
+	// connections being refused because of the limit
+	refusing     map[*Connection]bool
+	shuttingDown bool
+	mutex        sync.Mutex
+
+	// queues served in round robin by the dispatcher, the shared one is used by all clients unless the policy is fair
+	queues      []*requestQueue
+	sharedQueue *requestQueue
+	// notified when a request is added to a queue
+	queued         chan struct{}
+	dispatcherOnce sync.Once
+
+	// goroutines handling connections or delivering requests to the receive channel
+	handlers sync.WaitGroup
+	// requests delivered (or being delivered) to the receive channel and not handled yet
+	inFlight sync.WaitGroup
+	// closed on shutdown to stop the delivery of requests
+	quit chan struct{}
 }
 
 // NewServer creates a new Server
 func NewServer() *Server {
-	return &Server{ReceiveChannel: make(chan *ClientData, maxChannelSize)}
+	return &Server{
+		ReceiveChannel: make(chan *ClientData),
+		Role:           RoleValidator,
+		Transport:      &NetTransport{Network: TCP},
+		listeners:      make(map[net.Listener]bool),
+		connections:    make(map[*Connection]bool),
+		refusing:       make(map[*Connection]bool),
+		quit:           make(chan struct{}),
+		queued:         make(chan struct{}, 1),
+	}
 }
 
 // ClientData is the data sent by the client to be delivered to the Listener
 type ClientData struct {
 	Packet     *Packet
 	Connection *Connection
+
+	done func()
+}
+
+// Done notifies the server that the request has been handled, so that it's not waited for on shutdown
+func (data *ClientData) Done() {
+	if data.done != nil {
+		data.done()
+	}
+}
+
+// Start starts listening on the given address and serves the incoming connections in the background
+func (server *Server) Start(address string) error {
+	listener, err := server.Transport.Listen(address)
+
+	if err != nil {
+		return fmt.Errorf("error while trying to listen on given address: %s", err)
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != ErrServerClosed && debug {
+			log.Printf("Server on %s stopped: %s", address, err)
+		}
+	}()
+
+	return nil
 }
 
 // Listen starts listening for incoming connections from the client
+// it returns ErrServerClosed after the server has been shut down
 func (server *Server) Listen(address string) error {
-	listener, err := net.Listen("tcp", address)
+	listener, err := server.Transport.Listen(address)
 
 	if err != nil {
 		return fmt.Errorf("error while trying to listen on given address: %s", err)
 	}
 
+	return server.Serve(listener)
+}
+
+// Serve accepts incoming connections from the client on the given listener
+// it returns ErrServerClosed after the server has been shut down
+func (server *Server) Serve(listener net.Listener) error {
+	server.mutex.Lock()
+	if server.shuttingDown {
+		server.mutex.Unlock()
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	server.listeners[listener] = true
+	server.mutex.Unlock()
+
 	defer listener.Close()
 
+	// deliver the requests queued to the receive channel
+	server.dispatcherOnce.Do(func() {
+		server.handlers.Add(1)
+		go server.dispatch()
+	})
+
 	for {
 		conn, err := listener.Accept()
 
 		if err != nil {
+			if server.isShuttingDown() {
+				return ErrServerClosed
+			}
 			return fmt.Errorf("error while trying to accept incoming connection: %s", err)
 		}
 
+		// the faults are injected below TLS, on the frames sent
+		faults, _ := conn.(*faultyConn)
+		if server.TLSConfig != nil {
+			conn = tls.Server(conn, server.TLSConfig)
+		}
+
+		connection := &Connection{Conn: conn, MaxFrameSize: server.MaxFrameSize, MaxPacketSize: server.MaxPacketSize, faults: faults}
+
+		if !server.trackConnection(connection) {
+			if debug {
+				log.Printf("Refusing connection from %s: too many connections", conn.RemoteAddr())
+			}
+
+			// tell the client why the connection is refused, if not too many are being refused already
+			if !server.trackRefusal(connection) {
+				connection.Close()
+				continue
+			}
+
+			go func() {
+				defer server.handlers.Done()
+				defer server.releaseRefusal(connection)
+
+				connection.refuseHandshake(ReasonRateLimited, "too many connections", time.Now().Add(time.Duration(refuseDeadline)*time.Second))
+			}()
+			continue
+		}
+
 		// handle connection in a separate goroutine
-		go server.HandleConnection(&Connection{Conn: conn})
+		go func() {
+			defer server.handlers.Done()
+			server.HandleConnection(connection)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and requests, waits until the requests received have been handled or the context expires, then closes all the connections and the receive channel
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mutex.Lock()
+	if server.shuttingDown {
+		server.mutex.Unlock()
+		return nil
+	}
+	server.shuttingDown = true
+
+	for listener := range server.listeners {
+		_ = listener.Close()
+	}
+
+	// interrupt the connections waiting for requests, responses can still be sent
+	for connection := range server.connections {
+		_ = connection.Conn.SetReadDeadline(time.Now())
+	}
+	server.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		server.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// stop the requests still being delivered and close the connections
+	close(server.quit)
+
+	server.mutex.Lock()
+	for connection := range server.connections {
+		connection.Close()
+	}
+	server.connections = make(map[*Connection]bool)
+
+	for connection := range server.refusing {
+		connection.Close()
 	}
+	server.mutex.Unlock()
+
+	// nothing can be delivered to the receive channel anymore
+	server.handlers.Wait()
+	close(server.ReceiveChannel)
+
+	// discard the requests that have not been delivered
+	server.mutex.Lock()
+	for _, queue := range server.queues {
+		server.discard(queue)
+	}
+	server.queues = nil
+	server.mutex.Unlock()
+
+	return err
 }
 
 // HandleConnection from the given connection
@@ -52,13 +282,30 @@ func (server *Server) HandleConnection(connection *Connection) {
 		log.Println("Handling client connection from " + connection.Conn.RemoteAddr().String())
 	}
 
+	// release the connection when the client is gone or misbehaves
+	defer server.releaseConnection(connection)
+
+	queue := server.getQueue()
+	defer server.closeQueue(queue)
+
+	// the first packet must be the hello message of the client
+	err := connection.acceptHandshake(server.Role)
+	if err != nil {
+		if debug {
+			log.Printf("error during handshake with %s: %s", connection.Conn.RemoteAddr(), err)
+		}
+		return
+	}
+
 	for {
-		packet, err := connection.Receive()
+		packet, err := connection.receiveWithIdleTimeout(server.IdleTimeout)
 
 		if err != nil {
 			if debug {
 				if err == io.EOF {
 					log.Printf("Client %s closed the connection", connection.Conn.RemoteAddr())
+				} else if server.isShuttingDown() {
+					log.Printf("Closing connection with %s because the server is shutting down", connection.Conn.RemoteAddr())
 				} else {
 
 					log.Printf("error while trying to receive packet from %s: %s", connection.Conn.RemoteAddr(), err)
@@ -67,11 +314,253 @@ func (server *Server) HandleConnection(connection *Connection) {
 			return
 		}
 
-		clientData := &ClientData{Packet: packet, Connection: connection}
+		if !server.enqueue(queue, &ClientData{Packet: packet, Connection: connection}) {
+			return
+		}
+	}
+}
+
+// QueueDepth returns the number of requests waiting to be delivered to the receive channel
+func (server *Server) QueueDepth() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
 
-		// send data to receiving channel without blocking
-		go func(data *ClientData) {
-			server.ReceiveChannel <- data
-		}(clientData)
+	depth := 0
+	for _, queue := range server.queues {
+		depth += len(queue.requests)
 	}
+	return depth
+}
+
+// add a request to the queue according to the queue policy, return false if the server is shutting down
+// the request is in flight until the receiver calls Done or the server stops delivering requests
+func (server *Server) enqueue(queue *requestQueue, data *ClientData) bool {
+	server.mutex.Lock()
+	if server.shuttingDown {
+		server.mutex.Unlock()
+		return false
+	}
+
+	once := &sync.Once{}
+	data.done = func() {
+		once.Do(server.inFlight.Done)
+	}
+	server.inFlight.Add(1)
+	server.mutex.Unlock()
+
+	// count the request before the dispatcher can take it from the queue, so that the gauge never goes below 0
+	server.addQueueDepth(1)
+
+	if server.QueuePolicy == DropPolicy {
+		select {
+		case queue.requests <- data:
+		default:
+			server.addQueueDepth(-1)
+			data.Done()
+			server.drop(data)
+			return true
+		}
+	} else {
+		// block the reader until there's space in the queue
+		select {
+		case queue.requests <- data:
+		case <-server.quit:
+			server.addQueueDepth(-1)
+			data.Done()
+			return false
+		}
+	}
+
+	// wake up the dispatcher
+	select {
+	case server.queued <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// update the gauge of the requests queued, if any
+func (server *Server) addQueueDepth(delta float64) {
+	if server.QueueDepthGauge != nil {
+		server.QueueDepthGauge.Add(delta)
+	}
+}
+
+// tell the client that its request has been dropped
+func (server *Server) drop(data *ClientData) {
+	if server.DroppedCounter != nil {
+		server.DroppedCounter.Inc()
+	}
+
+	if debug {
+		log.Printf("Dropping request from %s: queue full", data.Connection.Conn.RemoteAddr())
+	}
+
+	err := data.Connection.Send(NewErrorPacket(data.Packet, ReasonRateLimited, "request dropped: queue full"))
+	if err != nil && debug {
+		log.Printf("error while sending error packet to %s: %s", data.Connection.Conn.RemoteAddr(), err)
+	}
+}
+
+// deliver the requests queued to the receive channel, taking them from the queues in round robin, until the server is shut down
+func (server *Server) dispatch() {
+	defer server.handlers.Done()
+
+	next := 0
+	for {
+		var data *ClientData
+		data, next = server.nextRequest(next)
+
+		if data == nil {
+			select {
+			case <-server.queued:
+				continue
+			case <-server.quit:
+				return
+			}
+		}
+
+		server.addQueueDepth(-1)
+
+		select {
+		case server.ReceiveChannel <- data:
+		case <-server.quit:
+			data.Done()
+			return
+		}
+	}
+}
+
+// take a request from the queues, starting from the given one, and return it with the index of the queue to start from next time
+// closed queues are removed once empty
+func (server *Server) nextRequest(start int) (*ClientData, int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for i := 0; i < len(server.queues); {
+		index := (start + i) % len(server.queues)
+		queue := server.queues[index]
+
+		select {
+		case data := <-queue.requests:
+			return data, index + 1
+		default:
+		}
+
+		if queue.closed {
+			server.queues = append(server.queues[:index], server.queues[index+1:]...)
+			if index < start {
+				start--
+			}
+			continue
+		}
+		i++
+	}
+
+	return nil, start
+}
+
+// get the queue for a new client, a new one if the policy is fair
+func (server *Server) getQueue() *requestQueue {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	size := server.QueueSize
+	if size <= 0 {
+		size = maxChannelSize
+	}
+
+	if server.QueuePolicy != FairPolicy {
+		if server.sharedQueue == nil {
+			server.sharedQueue = &requestQueue{requests: make(chan *ClientData, size)}
+			server.queues = append(server.queues, server.sharedQueue)
+		}
+		return server.sharedQueue
+	}
+
+	queue := &requestQueue{requests: make(chan *ClientData, size)}
+	server.queues = append(server.queues, queue)
+	return queue
+}
+
+// mark the queue of a client as closed, the requests already queued are still delivered
+func (server *Server) closeQueue(queue *requestQueue) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if queue != server.sharedQueue {
+		queue.closed = true
+	}
+}
+
+// remove all the requests from a queue without delivering them
+func (server *Server) discard(queue *requestQueue) {
+	for {
+		select {
+		case data := <-queue.requests:
+			data.Done()
+			server.addQueueDepth(-1)
+		default:
+			return
+		}
+	}
+}
+
+// register a new connection and its handler, return false if the server is shutting down or has too many connections
+func (server *Server) trackConnection(connection *Connection) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.shuttingDown || (server.MaxConnections > 0 && len(server.connections) >= server.MaxConnections) {
+		return false
+	}
+
+	server.connections[connection] = true
+	server.handlers.Add(1)
+	return true
+}
+
+// keep track of a connection being refused, return false if too many connections are being refused or if the server is shutting down
+func (server *Server) trackRefusal(connection *Connection) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.shuttingDown || len(server.refusing) >= maxRefusing {
+		return false
+	}
+
+	server.refusing[connection] = true
+	server.handlers.Add(1)
+	return true
+}
+
+// close a connection that has been refused
+func (server *Server) releaseRefusal(connection *Connection) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.refusing, connection)
+	connection.Close()
+}
+
+// close a connection that is not used anymore
+func (server *Server) releaseConnection(connection *Connection) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// on shutdown, connections are closed once the requests in flight have been handled
+	if server.shuttingDown {
+		return
+	}
+
+	delete(server.connections, connection)
+	connection.Close()
+}
+
+// check if the server is shutting down
+func (server *Server) isShuttingDown() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.shuttingDown
 }
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"go.dedis.ch/protobuf"
+)
+
+// Sign signs the content of the packet with the given private key
+func (packet *Packet) Sign(privateKey ed25519.PrivateKey) error {
+	data, err := packet.signingBytes()
+	if err != nil {
+		return err
+	}
+
+	packet.Signature = ed25519.Sign(privateKey, data)
+	return nil
+}
+
+// VerifySignature returns true if the packet has been signed with the private key corresponding to the given public key
+func (packet *Packet) VerifySignature(publicKey ed25519.PublicKey) bool {
+	if len(packet.Signature) != ed25519.SignatureSize || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	data, err := packet.signingBytes()
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(publicKey, data, packet.Signature)
+}
+
+// get a deterministic serialization of the signed fields of the packet
+// rounds of the height vote set are sorted because the order of the map iteration is random
+// the request id and the filter are signed too, so that a response can't be replayed as the answer to another request
+func (packet *Packet) signingBytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint32(header[0:4], packet.Code)
+	binary.BigEndian.PutUint64(header[4:12], packet.Height)
+	binary.BigEndian.PutUint64(header[12:20], packet.RequestID)
+	buf.Write(header)
+
+	writeBytes(&buf, []byte(packet.ID))
+
+	// a nil filter (whole height vote set) is distinguished from any filter
+	if packet.Filter == nil {
+		buf.WriteByte(0)
+	} else {
+		filter := make([]byte, 21)
+		filter[0] = 1
+		binary.BigEndian.PutUint64(filter[1:9], packet.Filter.FromRound)
+		binary.BigEndian.PutUint64(filter[9:17], packet.Filter.ToRound)
+		binary.BigEndian.PutUint32(filter[17:21], uint32(len(packet.Filter.Types)))
+		buf.Write(filter)
+
+		for _, messageType := range packet.Filter.Types {
+			writeBytes(&buf, []byte(messageType))
+		}
+	}
+
+	// error responses are signed too, so that a validator can't be made to look like it has no message logs
+	if packet.Code == Error {
+		reason := make([]byte, 4)
+		binary.BigEndian.PutUint32(reason, packet.Reason)
+		buf.Write(reason)
+		writeBytes(&buf, []byte(packet.ErrorMessage))
+	}
+
+	if packet.Hvs != nil {
+		rounds := make([]uint64, 0, len(packet.Hvs.VoteSetMap))
+		for round := range packet.Hvs.VoteSetMap {
+			rounds = append(rounds, round)
+		}
+		sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+
+		for _, round := range rounds {
+			vs := packet.Hvs.VoteSetMap[round]
+			if vs == nil {
+				continue
+			}
+
+			encoded, err := protobuf.Encode(vs)
+			if err != nil {
+				return nil, fmt.Errorf("error while serializing the packet to sign: %s", err)
+			}
+
+			roundBytes := make([]byte, 8)
+			binary.BigEndian.PutUint64(roundBytes, round)
+			buf.Write(roundBytes)
+			writeBytes(&buf, encoded)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// write length-prefixed data
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(data)))
+	buf.Write(length)
+	buf.Write(data)
+}
+
+// ParsePrivateKey parses an hex-encoded ed25519 private key (or its 32 bytes seed)
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding private key: %s", err)
+	}
+
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+
+	return nil, fmt.Errorf("error while decoding private key: invalid length %d", len(key))
+}
+
+// ParsePublicKey parses an hex-encoded ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding public key: %s", err)
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("error while decoding public key: invalid length %d", len(key))
+	}
+
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,167 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// TLSConfig contains the PEM files (relative to the project root directory) used to establish mutual TLS connections
+type TLSConfig struct {
+	// certificate and private key of the process
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// certificate authority used to verify the certificate of the other side
+	CA string `yaml:"ca"`
+
+	// common names of the client certificates allowed to connect (server only), any certificate signed by the CA if empty
+	AllowedClients []string `yaml:"allowedClients"`
+	// name expected in the server certificate (client only), host of the address if empty
+	ServerName string `yaml:"serverName"`
+}
+
+// ClientConfig loads the files of the config and creates the TLS configuration for a client
+func (config *TLSConfig) ClientConfig() (*tls.Config, error) {
+	certPEM, keyPEM, caPEM, err := config.readFiles()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientTLSConfig(certPEM, keyPEM, caPEM, config.ServerName)
+}
+
+// ServerConfig loads the files of the config and creates the TLS configuration for a server
+func (config *TLSConfig) ServerConfig() (*tls.Config, error) {
+	certPEM, keyPEM, caPEM, err := config.readFiles()
+	if err != nil {
+		return nil, err
+	}
+	return NewServerTLSConfig(certPEM, keyPEM, caPEM, config.AllowedClients)
+}
+
+// read certificate, key and certificate authority files
+func (config *TLSConfig) readFiles() ([]byte, []byte, []byte, error) {
+	files := make([][]byte, 0, 3)
+
+	for _, file := range []string{config.Cert, config.Key, config.CA} {
+		if file == "" {
+			return nil, nil, nil, fmt.Errorf("error while loading tls config: cert, key and ca are required")
+		}
+
+		path, err := utils.GetProjectFilePath(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error while loading tls config: %s", err)
+		}
+		files = append(files, data)
+	}
+
+	return files[0], files[1], files[2], nil
+}
+
+// NewClientTLSConfig creates the TLS configuration of a client from PEM encoded certificate, key and certificate authority
+func NewClientTLSConfig(certPEM, keyPEM, caPEM []byte, serverName string) (*tls.Config, error) {
+	cert, pool, err := loadCertificates(certPEM, keyPEM, caPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// NewServerTLSConfig creates the TLS configuration of a server from PEM encoded certificate, key and certificate authority
+// clients must present a certificate signed by the certificate authority and, if allowedClients is not empty, with one of the given common names
+func NewServerTLSConfig(certPEM, keyPEM, caPEM []byte, allowedClients []string) (*tls.Config, error) {
+	cert, pool, err := loadCertificates(certPEM, keyPEM, caPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(allowedClients) > 0 {
+		allowed := make(map[string]bool)
+		for _, name := range allowedClients {
+			allowed[name] = true
+		}
+
+		// called after the chain has been verified against the certificate authority
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				if len(chain) > 0 && allowed[chain[0].Subject.CommonName] {
+					return nil
+				}
+			}
+			return fmt.Errorf("client certificate not allowed")
+		}
+	}
+
+	return config, nil
+}
+
+// parse the key pair and the pool containing the certificate authority
+func loadCertificates(certPEM, keyPEM, caPEM []byte) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("error while loading key pair: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, fmt.Errorf("error while loading certificate authority: no valid certificates found")
+	}
+
+	return cert, pool, nil
+}
+
+// ConnectTLS establishes a tcp connection to the given address, using TLS if a configuration is given
+func ConnectTLS(address string, config *tls.Config) (*Connection, error) {
+	return Dial(&NetTransport{Network: TCP}, address, config)
+}
+
+// run the TLS handshake on the given connection
+// the server name is the host of the address, if not given in the configuration
+func newTLSClient(conn net.Conn, address string, config *tls.Config) (net.Conn, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+
+	err := tlsConn.SetDeadline(time.Now().Add(time.Duration(writeDeadline) * time.Second))
+	if err == nil {
+		err = tlsConn.Handshake()
+	}
+	if err == nil {
+		err = tlsConn.SetDeadline(time.Time{})
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
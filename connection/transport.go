@@ -0,0 +1,185 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// names of the transports that can be selected from config
+const (
+	TCP    = "tcp"
+	Unix   = "unix"
+	Memory = "memory"
+)
+
+// Transport establishes connections with other processes and listens for incoming ones
+type Transport interface {
+	// Dial connects to the given address
+	Dial(address string) (net.Conn, error)
+	// Listen starts listening for incoming connections on the given address
+	Listen(address string) (net.Listener, error)
+}
+
+// DefaultMemoryTransport is the in-memory transport selected from config, shared by all the processes running in the same program
+var DefaultMemoryTransport = NewMemoryTransport()
+
+// GetTransport returns the transport with the given name, tcp if the name is empty
+func GetTransport(name string) (Transport, error) {
+	switch name {
+	case "", TCP:
+		return &NetTransport{Network: TCP}, nil
+	case Unix:
+		return &NetTransport{Network: Unix}, nil
+	case Memory:
+		return DefaultMemoryTransport, nil
+	}
+	return nil, fmt.Errorf("unknown transport %s", name)
+}
+
+// NetTransport is a transport over a network of the net library (tcp or unix domain sockets)
+type NetTransport struct {
+	Network string
+}
+
+// Dial connects to the given address
+func (transport *NetTransport) Dial(address string) (net.Conn, error) {
+	return net.DialTimeout(transport.Network, address, time.Duration(writeDeadline)*time.Second)
+}
+
+// Listen starts listening for incoming connections on the given address
+func (transport *NetTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen(transport.Network, address)
+}
+
+// MemoryTransport connects processes in the same program through in-memory pipes, addresses are arbitrary names
+type MemoryTransport struct {
+	// time to wait for the listener to accept a connection, the write deadline (as the dial timeout of the network transports) if 0
+	DialTimeout time.Duration
+
+	listeners map[string]*memoryListener
+	mutex     sync.Mutex
+}
+
+// NewMemoryTransport creates a new MemoryTransport, with addresses independent from the other memory transports
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		listeners: make(map[string]*memoryListener),
+	}
+}
+
+// Dial connects to the listener on the given address
+func (transport *MemoryTransport) Dial(address string) (net.Conn, error) {
+	transport.mutex.Lock()
+	listener, loaded := transport.listeners[address]
+	transport.mutex.Unlock()
+
+	if !loaded {
+		return nil, fmt.Errorf("no process listening on %s", address)
+	}
+
+	clientConn, serverConn := net.Pipe()
+
+	// wait for the listener to accept the connection
+	timeout := transport.DialTimeout
+	if timeout == 0 {
+		timeout = time.Duration(writeDeadline) * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case listener.connections <- &memoryConn{Conn: serverConn, local: memoryAddr(address), remote: memoryAddr("client:" + address)}:
+		return &memoryConn{Conn: clientConn, local: memoryAddr("client:" + address), remote: memoryAddr(address)}, nil
+	case <-listener.closed:
+		_ = clientConn.Close()
+		return nil, fmt.Errorf("no process listening on %s", address)
+	case <-timer.C:
+		_ = clientConn.Close()
+		return nil, fmt.Errorf("timeout while connecting to %s: connection not accepted", address)
+	}
+}
+
+// Listen starts listening for incoming connections on the given address
+func (transport *MemoryTransport) Listen(address string) (net.Listener, error) {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if _, loaded := transport.listeners[address]; loaded {
+		return nil, fmt.Errorf("address %s already in use", address)
+	}
+
+	listener := &memoryListener{
+		address:     address,
+		transport:   transport,
+		connections: make(chan net.Conn),
+		closed:      make(chan struct{}),
+	}
+	transport.listeners[address] = listener
+
+	return listener, nil
+}
+
+// listener of the memory transport
+type memoryListener struct {
+	address     string
+	transport   *MemoryTransport
+	connections chan net.Conn
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// Accept waits for the next connection to the listener
+func (listener *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.connections:
+		return conn, nil
+	case <-listener.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close stops listening and frees the address
+func (listener *memoryListener) Close() error {
+	listener.closeOnce.Do(func() {
+		listener.transport.mutex.Lock()
+		delete(listener.transport.listeners, listener.address)
+		listener.transport.mutex.Unlock()
+
+		close(listener.closed)
+	})
+	return nil
+}
+
+// Addr returns the address of the listener
+func (listener *memoryListener) Addr() net.Addr {
+	return memoryAddr(listener.address)
+}
+
+// pipe with the addresses of the two sides
+type memoryConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (conn *memoryConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+func (conn *memoryConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+// address of the memory transport
+type memoryAddr string
+
+func (addr memoryAddr) Network() string {
+	return Memory
+}
+
+func (addr memoryAddr) String() string {
+	return string(addr)
+}
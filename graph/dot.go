@@ -0,0 +1,176 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+)
+
+// style of the edges in DOT format, by kind
+var dotEdgeStyles = map[string]string{
+	ReceivedEdge:      `color="#999999", style=dashed, fontcolor="#999999", fontsize=8, constraint=false`,
+	LockEdge:          `color="#e6550d", penwidth=2, fontcolor="#e6550d"`,
+	JustificationEdge: `color="#3182bd", style=dotted, penwidth=2, fontcolor="#3182bd"`,
+}
+
+// colour of the border of the boxes of faulty processes
+const faultyColour = "#de2d26"
+
+// WriteDOT writes the graph in the DOT format of Graphviz: a cluster for each round with a box for each process showing the votes sent, coloured by value
+// the boxes of the faulty processes have a red border, thicker in the rounds where they misbehaved, and the boxes of the processes whose message logs have not been received are dashed
+func (g *Graph) WriteDOT(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+
+	fmt.Fprintf(w, "digraph fork {\n")
+	fmt.Fprintf(w, "  label=%s;\n", quote(fmt.Sprintf("Height %d, decisions in rounds %d and %d, faulty processes: %s", g.Height, g.FirstDecisionRound, g.SecondDecisionRound, g.faultyList())))
+	fmt.Fprintf(w, "  labelloc=t;\n  rankdir=LR;\n  newrank=true;\n  fontname=Helvetica;\n")
+	fmt.Fprintf(w, "  node [shape=box, style=\"rounded,filled\", fontname=Helvetica, fontsize=10];\n")
+	fmt.Fprintf(w, "  edge [fontname=Helvetica, fontsize=9];\n\n")
+
+	// a cluster for each round
+	for _, round := range g.Rounds {
+		fmt.Fprintf(w, "  subgraph %s {\n", quote("cluster_r"+strconv.FormatUint(round, 10)))
+		fmt.Fprintf(w, "    label=%s;\n", quote(g.RoundLabel(round)))
+		fmt.Fprintf(w, "    style=rounded;\n    color=\"#bbbbbb\";\n")
+
+		for _, box := range g.Boxes {
+			if box.Round == round {
+				fmt.Fprintf(w, "    %s [%s];\n", quote(boxID(box.ProcessID, box.Round)), g.dotBoxAttributes(box))
+			}
+		}
+
+		fmt.Fprintf(w, "  }\n\n")
+	}
+
+	// keep the boxes of each process on the same row
+	for _, processID := range g.Processes {
+		var previous *Box
+		for _, box := range g.Boxes {
+			if box.ProcessID != processID {
+				continue
+			}
+			if previous != nil {
+				fmt.Fprintf(w, "  %s -> %s [style=invis, weight=10];\n", quote(boxID(previous.ProcessID, previous.Round)), quote(boxID(box.ProcessID, box.Round)))
+			}
+			previous = box
+		}
+	}
+	fmt.Fprintf(w, "\n")
+
+	for _, edge := range g.Edges {
+		fmt.Fprintf(w, "  %s -> %s [label=%s, %s];\n", quote(boxID(edge.From.ProcessID, edge.From.Round)), quote(boxID(edge.To.ProcessID, edge.To.Round)), quote(edge.Label), dotEdgeStyles[edge.Kind])
+	}
+
+	// legend with the colours of the values and the kinds of edges
+	fmt.Fprintf(w, "\n  subgraph cluster_legend {\n    label=\"Legend\";\n    style=rounded;\n    color=\"#bbbbbb\";\n")
+	for _, value := range append(append([]*common.Value{}, g.Values...), nil) {
+		fmt.Fprintf(w, "    %s [label=%s, fillcolor=%s];\n", quote("value_"+value.String()), quote("value "+value.String()), quote(g.Colour(value)))
+	}
+	fmt.Fprintf(w, "    legend_faulty [label=\"faulty process\", fillcolor=%s, color=%s, penwidth=3];\n", quote(emptyColour), quote(faultyColour))
+	fmt.Fprintf(w, "    legend_inferred [label=\"message logs not received\", fillcolor=%s, style=\"rounded,filled,dashed\"];\n", quote(emptyColour))
+	for _, kind := range []string{LockEdge, JustificationEdge, ReceivedEdge} {
+		fmt.Fprintf(w, "    legend_%s_from [shape=point, style=invis];\n    legend_%s_to [shape=point, style=invis];\n", kind, kind)
+		fmt.Fprintf(w, "    legend_%s_from -> legend_%s_to [label=%s, %s];\n", kind, kind, quote(kind), dotEdgeStyles[kind])
+	}
+	fmt.Fprintf(w, "  }\n")
+
+	fmt.Fprintf(w, "}\n")
+
+	err := w.Flush()
+	if err != nil {
+		return fmt.Errorf("error while writing graph: %s", err)
+	}
+	return nil
+}
+
+// attributes of the node of a box in DOT format
+func (g *Graph) dotBoxAttributes(box *Box) string {
+	lines := []string{"process " + box.ProcessID}
+	for _, value := range box.Prevotes {
+		lines = append(lines, "PV "+value.String())
+	}
+	for _, value := range box.Precommits {
+		lines = append(lines, "PC "+value.String())
+	}
+	if len(box.Faults) > 0 {
+		lines = append(lines, "FAULTY")
+	}
+
+	attributes := []string{
+		"label=" + quote(strings.Join(lines, "\n")),
+		"fillcolor=" + quote(g.boxColour(box)),
+		"tooltip=" + quote(g.tooltip(box)),
+	}
+
+	if g.IsFaulty(box.ProcessID) {
+		penWidth := "1.5"
+		if len(box.Faults) > 0 {
+			penWidth = "3"
+		}
+		attributes = append(attributes, "color="+quote(faultyColour), "penwidth="+penWidth)
+	}
+
+	if !g.Received[box.ProcessID] {
+		attributes = append(attributes, `style="rounded,filled,dashed"`)
+	}
+
+	return strings.Join(attributes, ", ")
+}
+
+// description of a box with the reasons why the process is faulty in the round
+func (g *Graph) tooltip(box *Box) string {
+	lines := []string{fmt.Sprintf("process %s, round %d, %d messages received", box.ProcessID, box.Round, box.Received)}
+	if !g.Received[box.ProcessID] {
+		lines = append(lines, "message logs not received, votes inferred from the messages received by the other processes")
+	}
+	lines = append(lines, box.Faults...)
+	return strings.Join(lines, "\n")
+}
+
+// RoundLabel returns the title of a round, saying if a decision was made in it
+func (g *Graph) RoundLabel(round uint64) string {
+	label := "Round " + strconv.FormatUint(round, 10)
+	if round == g.FirstDecisionRound {
+		label += " (first decision)"
+	}
+	if round == g.SecondDecisionRound {
+		label += " (second decision)"
+	}
+	return label
+}
+
+// faulty processes, comma-separated, with the reasons not specific to a round
+func (g *Graph) faultyList() string {
+	if len(g.Faulty) == 0 {
+		return "none"
+	}
+
+	processes := make([]string, 0, len(g.Faulty))
+	for processID := range g.Faulty {
+		processes = append(processes, processID)
+	}
+	sort.Strings(processes)
+
+	faulty := make([]string, 0, len(processes))
+	for _, processID := range processes {
+		if len(g.Faulty[processID]) > 0 {
+			faulty = append(faulty, processID+" ("+strings.Join(g.Faulty[processID], "; ")+")")
+		} else {
+			faulty = append(faulty, processID)
+		}
+	}
+	return strings.Join(faulty, ", ")
+}
+
+// quote a string as a DOT id, new lines are centered
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
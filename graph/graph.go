@@ -0,0 +1,313 @@
+package graph
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/common"
+)
+
+// kinds of the edges of the graph
+const (
+	// the messages sent by a process in a round have been received by another process
+	ReceivedEdge = "received"
+	// a process prevoted after having precommitted (locked) a value in a previous round
+	LockEdge = "lock"
+	// a prevote of a process in a previous round is given as justification of a prevote
+	JustificationEdge = "justification"
+)
+
+// colours of the values voted, assigned in the order of the values
+var palette = []string{"#8dd3c7", "#ffffb3", "#bebada", "#80b1d3", "#fdb462", "#b3de69", "#fccde5", "#bc80bd", "#ccebc5", "#ffed6f"}
+
+// colour of the votes for no value and of the boxes without votes
+const (
+	nilColour   = "#d9d9d9"
+	emptyColour = "#ffffff"
+)
+
+// Box contains the votes sent by a process in a round
+type Box struct {
+	ProcessID  string
+	Round      uint64
+	Prevotes   []*common.Value
+	Precommits []*common.Value
+	// number of messages received by the process in the round
+	Received int
+	// reasons why the process has been found faulty in the round
+	Faults []string
+}
+
+// Edge connects the boxes of two processes, in the same round or in different rounds
+type Edge struct {
+	From  *Box
+	To    *Box
+	Kind  string
+	Label string
+}
+
+// Graph describes the flow of the votes of a height analyzed by the accountability algorithm, round by round
+type Graph struct {
+	Height              uint64
+	FirstDecisionRound  uint64
+	SecondDecisionRound uint64
+
+	// processes (sorted) and rounds (sorted) with at least a box
+	Processes []string
+	Rounds    []uint64
+
+	Boxes []*Box
+	Edges []*Edge
+
+	// faulty processes with the reasons not specific to a round (e.g. missing message logs)
+	Faulty map[string][]string
+	// processes whose message logs have been received, the others are inferred from the messages received
+	Received map[string]bool
+
+	// values voted (sorted) with their colour
+	Values  []*common.Value
+	colours map[string]string
+
+	boxes map[string]*Box
+}
+
+// New builds the graph of the votes analyzed in the last run of the accountability algorithm
+// received edges are added only if showReceived is true, since there's one for each pair of processes in each round
+func New(acc *accountability.Accountability, height, firstDecisionRound, secondDecisionRound uint64, showReceived bool) *Graph {
+	g := &Graph{
+		Height:              height,
+		FirstDecisionRound:  firstDecisionRound,
+		SecondDecisionRound: secondDecisionRound,
+		Faulty:              make(map[string][]string),
+		Received:            make(map[string]bool),
+		colours:             make(map[string]string),
+		boxes:               make(map[string]*Box),
+	}
+
+	for _, processID := range acc.GetReceivedProcesses() {
+		g.Received[processID] = true
+	}
+
+	logs := acc.GetMessageLogs()
+	processes := make([]string, 0, len(logs))
+	for processID := range logs {
+		processes = append(processes, processID)
+	}
+	sort.Strings(processes)
+
+	// boxes with the votes sent
+	for _, processID := range processes {
+		for round, vs := range logs[processID].VoteSetMap {
+			box := g.box(processID, round)
+			box.Received = len(vs.ReceivedPrevoteMessages) + len(vs.ReceivedPrecommitMessages)
+			for _, mes := range vs.SentPrevoteMessages {
+				box.Prevotes = append(box.Prevotes, mes.Value)
+			}
+			for _, mes := range vs.SentPrecommitMessages {
+				box.Precommits = append(box.Precommits, mes.Value)
+			}
+		}
+	}
+
+	for _, processID := range processes {
+		hvs := logs[processID]
+		rounds := sortedRounds(hvs)
+
+		for _, round := range rounds {
+			vs := hvs.VoteSetMap[round]
+			if showReceived {
+				g.addReceivedEdges(processID, round, vs)
+			}
+			g.addJustificationEdges(processID, vs)
+		}
+
+		g.addLockEdges(processID, hvs, rounds)
+	}
+
+	// faults
+	for _, processID := range acc.GetFaultyProcesses() {
+		g.Faulty[processID] = make([]string, 0)
+		for round, reasons := range acc.GetFaults(processID) {
+			for _, reason := range reasons {
+				if !reason.IsRoundSpecific() {
+					g.Faulty[processID] = append(g.Faulty[processID], reason.FaultinessReason())
+				} else {
+					box := g.box(processID, round)
+					box.Faults = append(box.Faults, reason.FaultinessReason())
+				}
+			}
+		}
+	}
+
+	g.sort()
+
+	return g
+}
+
+// IsFaulty returns true if the process has been found faulty
+func (g *Graph) IsFaulty(processID string) bool {
+	_, faulty := g.Faulty[processID]
+	return faulty
+}
+
+// Colour returns the colour of a value
+func (g *Graph) Colour(value *common.Value) string {
+	if value == nil {
+		return nilColour
+	}
+	return g.colours[value.String()]
+}
+
+// colour of a box: the one of the value precommitted, if any, or of the value prevoted
+func (g *Graph) boxColour(box *Box) string {
+	if len(box.Precommits) > 0 {
+		return g.Colour(box.Precommits[0])
+	}
+	if len(box.Prevotes) > 0 {
+		return g.Colour(box.Prevotes[0])
+	}
+	return emptyColour
+}
+
+// get the box of a process in a round, created if not present
+func (g *Graph) box(processID string, round uint64) *Box {
+	key := boxID(processID, round)
+	box, loaded := g.boxes[key]
+	if !loaded {
+		box = &Box{ProcessID: processID, Round: round, Prevotes: make([]*common.Value, 0), Precommits: make([]*common.Value, 0), Faults: make([]string, 0)}
+		g.boxes[key] = box
+		g.Boxes = append(g.Boxes, box)
+	}
+	return box
+}
+
+// add an edge from the box of each sender to the box of the process, labelled with the types of the messages received
+func (g *Graph) addReceivedEdges(processID string, round uint64, vs *common.VoteSet) {
+	senders := make([]string, 0)
+	types := make(map[string][]string)
+
+	received := append(append([]*common.Message{}, vs.ReceivedPrevoteMessages...), vs.ReceivedPrecommitMessages...)
+	for _, mes := range received {
+		if mes.SenderID == processID {
+			continue
+		}
+		if _, loaded := types[mes.SenderID]; !loaded {
+			senders = append(senders, mes.SenderID)
+		}
+		types[mes.SenderID] = append(types[mes.SenderID], abbreviation(mes))
+	}
+
+	sort.Strings(senders)
+	for _, sender := range senders {
+		g.Edges = append(g.Edges, &Edge{From: g.box(sender, round), To: g.box(processID, round), Kind: ReceivedEdge, Label: strings.Join(types[sender], ", ")})
+	}
+}
+
+// add an edge from the box of the sender of each justification to the box of the process prevoting
+func (g *Graph) addJustificationEdges(processID string, vs *common.VoteSet) {
+	for _, prevote := range vs.SentPrevoteMessages {
+		for _, justification := range prevote.Justifications {
+			g.Edges = append(g.Edges, &Edge{From: g.box(justification.SenderID, justification.Round), To: g.box(processID, prevote.Round), Kind: JustificationEdge, Label: abbreviation(justification)})
+		}
+	}
+}
+
+// add an edge from the box where the process locked a value (precommit) to the boxes of the following prevotes, until the next lock
+func (g *Graph) addLockEdges(processID string, hvs *common.HeightVoteSet, rounds []uint64) {
+	var lock *Box
+	var lockedValue *common.Value
+
+	for _, round := range rounds {
+		vs := hvs.VoteSetMap[round]
+
+		if lock != nil {
+			for _, prevote := range vs.SentPrevoteMessages {
+				if prevote.Value != nil {
+					g.Edges = append(g.Edges, &Edge{From: lock, To: g.box(processID, round), Kind: LockEdge, Label: "locked " + lockedValue.String()})
+					break
+				}
+			}
+		}
+
+		for _, precommit := range vs.SentPrecommitMessages {
+			if precommit.Value != nil {
+				lock = g.box(processID, round)
+				lockedValue = precommit.Value
+			}
+		}
+	}
+}
+
+// sort processes, rounds and boxes and assign the colours to the values
+func (g *Graph) sort() {
+	processes := make(map[string]bool)
+	rounds := make(map[uint64]bool)
+	values := make(map[string]*common.Value)
+
+	for _, box := range g.Boxes {
+		processes[box.ProcessID] = true
+		rounds[box.Round] = true
+		for _, value := range append(append([]*common.Value{}, box.Prevotes...), box.Precommits...) {
+			if value != nil {
+				values[value.String()] = value
+			}
+		}
+		sort.Strings(box.Faults)
+	}
+
+	g.Processes = make([]string, 0, len(processes))
+	for processID := range processes {
+		g.Processes = append(g.Processes, processID)
+	}
+	sort.Strings(g.Processes)
+
+	g.Rounds = make([]uint64, 0, len(rounds))
+	for round := range rounds {
+		g.Rounds = append(g.Rounds, round)
+	}
+	sort.Slice(g.Rounds, func(i, j int) bool { return g.Rounds[i] < g.Rounds[j] })
+
+	g.Values = make([]*common.Value, 0, len(values))
+	for _, value := range values {
+		g.Values = append(g.Values, value)
+	}
+	sort.Slice(g.Values, func(i, j int) bool { return g.Values[i].Data < g.Values[j].Data })
+	for i, value := range g.Values {
+		g.colours[value.String()] = palette[i%len(palette)]
+	}
+
+	sort.SliceStable(g.Boxes, func(i, j int) bool {
+		if g.Boxes[i].Round != g.Boxes[j].Round {
+			return g.Boxes[i].Round < g.Boxes[j].Round
+		}
+		return g.Boxes[i].ProcessID < g.Boxes[j].ProcessID
+	})
+}
+
+// rounds (sorted) of a height vote set
+func sortedRounds(hvs *common.HeightVoteSet) []uint64 {
+	rounds := make([]uint64, 0, len(hvs.VoteSetMap))
+	for round, vs := range hvs.VoteSetMap {
+		if vs != nil {
+			rounds = append(rounds, round)
+		}
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+	return rounds
+}
+
+// id of the box of a process in a round
+func boxID(processID string, round uint64) string {
+	return "r" + strconv.FormatUint(round, 10) + "_" + processID
+}
+
+// short description of a message, e.g. PV 10
+func abbreviation(mes *common.Message) string {
+	prefix := "PV"
+	if mes.Type == common.Precommit {
+		prefix = "PC"
+	}
+	return prefix + " " + mes.Value.String()
+}
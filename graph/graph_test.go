@@ -0,0 +1,172 @@
+package graph
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// run the algorithm in async mode on the default config and build the graph
+func getDefaultGraph(t *testing.T, showReceived bool) *Graph {
+	acc := accountability.NewAccountability()
+	acc.Init(4, true)
+
+	acc.StoreHvs("1", utils.GetHvsForDefaultConfig1())
+	acc.StoreHvs("2", utils.GetHvsForDefaultConfig2())
+	acc.StoreHvs("3", utils.GetHvsForDefaultConfig3())
+	acc.StoreHvs("4", utils.GetHvsForDefaultConfig4())
+
+	acc.Run(3, 4)
+
+	return New(acc, 1, 3, 4, showReceived)
+}
+
+// find the edges of the given kind between two boxes
+func findEdges(g *Graph, kind, from, to string) []*Edge {
+	edges := make([]*Edge, 0)
+	for _, edge := range g.Edges {
+		if edge.Kind == kind && boxID(edge.From.ProcessID, edge.From.Round) == from && boxID(edge.To.ProcessID, edge.To.Round) == to {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+func TestNew(t *testing.T) {
+
+	g := getDefaultGraph(t, true)
+
+	if !reflect.DeepEqual(g.Processes, []string{"1", "2", "3", "4"}) || !reflect.DeepEqual(g.Rounds, []uint64{3, 4}) {
+		t.Fatalf("Wrong processes %v or rounds %v", g.Processes, g.Rounds)
+	}
+
+	if len(g.Boxes) != 7 || len(g.Values) != 2 || g.Colour(g.Values[0]) == g.Colour(g.Values[1]) {
+		t.Fatalf("Wrong boxes %d or values %v", len(g.Boxes), g.Values)
+	}
+
+	if g.IsFaulty("1") || g.IsFaulty("2") || !g.IsFaulty("3") || !g.IsFaulty("4") {
+		t.Fatalf("Wrong faulty processes: %v", g.Faulty)
+	}
+
+	// process 1 sent messages only in round 3, process 3 prevoted both values in round 3
+	box := g.boxes[boxID("3", 3)]
+	if len(box.Prevotes) != 2 || len(box.Faults) != 1 {
+		t.Fatalf("Wrong box for process 3 in round 3: %+v", box)
+	}
+
+	// process 2 locked 10 in round 3 and prevoted 20 in round 4 with the prevotes of round 3 as justifications
+	lock := findEdges(g, LockEdge, boxID("2", 3), boxID("2", 4))
+	if len(lock) != 1 || lock[0].Label != "locked 10" {
+		t.Fatalf("Wrong lock edges: %v", lock)
+	}
+
+	for _, sender := range []string{"1", "3", "4"} {
+		justifications := findEdges(g, JustificationEdge, boxID(sender, 3), boxID("2", 4))
+		if len(justifications) != 1 || justifications[0].Label != "PV 20" {
+			t.Fatalf("Wrong justification edges from process %s: %v", sender, justifications)
+		}
+	}
+
+	received := findEdges(g, ReceivedEdge, boxID("3", 4), boxID("2", 4))
+	if len(received) != 1 || received[0].Label != "PV 20, PC 20" {
+		t.Fatalf("Wrong received edges: %v", received)
+	}
+
+	// no received edges if not requested
+	g = getDefaultGraph(t, false)
+	for _, edge := range g.Edges {
+		if edge.Kind == ReceivedEdge {
+			t.Fatal("Received edges should not be added")
+		}
+	}
+}
+
+func TestNew_FaultsInRoundZero(t *testing.T) {
+	acc := accountability.NewAccountability()
+	acc.Init(4, false)
+
+	// process 1 prevoted two values in round 0, process 3 didn't send its message logs
+	equivocating := common.NewHeightVoteSet()
+	equivocating.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(10), nil))
+	equivocating.AddMessage(common.NewMessage(common.Prevote, "1", 0, common.NewValue(20), nil))
+	correct := common.NewHeightVoteSet()
+	correct.AddMessage(common.NewMessage(common.Prevote, "2", 0, common.NewValue(10), nil))
+	correct.AddReceivedMessage(common.NewMessage(common.Prevote, "3", 0, common.NewValue(10), nil))
+
+	acc.StoreHvs("1", equivocating)
+	acc.StoreHvs("2", correct)
+	acc.Run(0, 1)
+
+	g := New(acc, 1, 0, 1, false)
+
+	// the equivocation is shown in the round, the missing message logs for the whole process
+	box := g.boxes[boxID("1", 0)]
+	if box == nil || len(box.Faults) != 1 || len(g.Faulty["1"]) != 0 {
+		t.Fatalf("Equivocation in round 0 should be shown in the round: %+v %v", box, g.Faulty["1"])
+	}
+	box = g.boxes[boxID("3", 0)]
+	if box == nil || len(box.Faults) != 0 || len(g.Faulty["3"]) != 1 {
+		t.Fatalf("Missing message logs should not be shown in round 0: %+v %v", box, g.Faulty["3"])
+	}
+}
+
+func TestGraph_WriteDOT(t *testing.T) {
+
+	g := getDefaultGraph(t, true)
+
+	var buf bytes.Buffer
+	err := g.WriteDOT(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write graph: %s", err)
+	}
+
+	dot := buf.String()
+	for _, expected := range []string{
+		"digraph fork {",
+		`subgraph "cluster_r3" {`,
+		`label="Round 4 (second decision)"`,
+		`"r3_2" -> "r4_2" [label="locked 10"`,
+		`"r3_1" -> "r4_2" [label="PV 20"`,
+		"faulty processes: 3, 4",
+		`color="#de2d26", penwidth=3`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Fatalf("Graph doesn't contain %s:\n%s", expected, dot)
+		}
+	}
+
+	if strings.Count(dot, "{") != strings.Count(dot, "}") {
+		t.Fatal("Unbalanced braces in graph")
+	}
+}
+
+func TestGraph_WriteHTML(t *testing.T) {
+
+	g := getDefaultGraph(t, true)
+
+	var buf bytes.Buffer
+	err := g.WriteHTML(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write graph: %s", err)
+	}
+
+	html := buf.String()
+	if !strings.Contains(html, "<svg") || strings.Count(html, "<rect") != len(g.Boxes) || !strings.Contains(html, "marker-end=\"url(#arrow-lock)\"") {
+		t.Fatalf("Wrong rendering:\n%s", html)
+	}
+
+	// values rejected by the template escaping
+	if strings.Contains(html, "ZgotmplZ") {
+		t.Fatalf("Unsafe content in rendering:\n%s", html)
+	}
+
+	// no external assets
+	if strings.Contains(html, "src=") || strings.Contains(html, "href=") {
+		t.Fatal("Rendering should be self-contained")
+	}
+}
@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+)
+
+// layout of the SVG rendering, in pixels
+const (
+	svgMarginLeft   = 110
+	svgMarginTop    = 60
+	svgColumnWidth  = 190
+	svgRowHeight    = 95
+	svgBoxWidth     = 140
+	svgBoxHeight    = 75
+	svgLineHeight   = 13
+	svgMarginBottom = 20
+)
+
+// colours of the edges in the SVG rendering, by kind (received edges are not drawn)
+var svgEdgeColours = map[string]string{
+	LockEdge:          "#e6550d",
+	JustificationEdge: "#3182bd",
+}
+
+// page with the SVG rendering of the graph, it doesn't need any external asset
+var htmlTemplate = template.Must(template.New("graph").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 20px; color: #333333; }
+h1 { font-size: 20px; }
+.legend span { display: inline-block; margin-right: 16px; }
+.swatch { display: inline-block; width: 12px; height: 12px; border: 1px solid #999999; vertical-align: middle; margin-right: 4px; }
+svg text { font-size: 11px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Decisions in rounds {{.FirstDecisionRound}} and {{.SecondDecisionRound}}. Faulty processes: {{.Faulty}}.</p>
+<p class="legend">
+{{range .Legend}}<span><span class="swatch" style="background: {{.Colour}}"></span>{{.Label}}</span>
+{{end}}<span><span class="swatch" style="border: 2px solid {{.FaultyColour}}"></span>faulty process</span>
+<span><span class="swatch" style="border: 1px dashed #333333"></span>message logs not received</span>
+{{range .EdgeLegend}}<span style="color: {{.Colour}}">&rarr; {{.Label}}</span>
+{{end}}</p>
+<p>Hover on a box for the messages received and the reasons why the process is faulty in the round.</p>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+<defs>
+{{range .EdgeLegend}}<marker id="arrow-{{.Label}}" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M 0 0 L 10 5 L 0 10 z" fill="{{.Colour}}"/></marker>
+{{end}}</defs>
+{{range .Columns}}<text x="{{.X}}" y="{{.Y}}" text-anchor="middle" font-weight="bold">{{.Text}}</text>
+{{end}}{{range .Rows}}<text x="{{.X}}" y="{{.Y}}" text-anchor="end" font-weight="bold" fill="{{.Colour}}">{{.Text}}</text>
+{{end}}{{range .Boxes}}<g>
+<title>{{.Title}}</title>
+<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" rx="6" ry="6" fill="{{.Fill}}" stroke="{{.Stroke}}" stroke-width="{{.StrokeWidth}}" stroke-dasharray="{{.Dash}}"/>
+{{range .Lines}}<text x="{{.X}}" y="{{.Y}}" text-anchor="middle" fill="{{.Colour}}">{{.Text}}</text>
+{{end}}</g>
+{{end}}{{range .Edges}}<path d="{{.Path}}" fill="none" stroke="{{.Colour}}" stroke-width="2" stroke-dasharray="{{.Dash}}" marker-end="url(#arrow-{{.Kind}})"><title>{{.Label}}</title></path>
+{{end}}</svg>
+</body>
+</html>
+`))
+
+// data of the HTML page
+type htmlPage struct {
+	Title               string
+	FirstDecisionRound  uint64
+	SecondDecisionRound uint64
+	Faulty              string
+	FaultyColour        string
+	Legend              []*svgLegend
+	EdgeLegend          []*svgLegend
+	Width               int
+	Height              int
+	Columns             []*svgText
+	Rows                []*svgText
+	Boxes               []*svgBox
+	Edges               []*svgEdge
+}
+
+// item of the legend
+type svgLegend struct {
+	Label  string
+	Colour string
+}
+
+// text in the SVG rendering
+type svgText struct {
+	X      int
+	Y      int
+	Text   string
+	Colour string
+}
+
+// box in the SVG rendering
+type svgBox struct {
+	X           int
+	Y           int
+	Width       int
+	Height      int
+	Fill        string
+	Stroke      string
+	StrokeWidth string
+	Dash        string
+	Title       string
+	Lines       []*svgText
+}
+
+// edge in the SVG rendering
+type svgEdge struct {
+	Path   string
+	Colour string
+	Dash   string
+	Kind   string
+	Label  string
+}
+
+// WriteHTML writes a self-contained HTML page with an SVG rendering of the graph: a column for each round and a row for each process
+// lock and justification edges are drawn between the boxes, received edges are not (the number of messages received is shown when hovering on a box)
+func (g *Graph) WriteHTML(writer io.Writer) error {
+	columns := make(map[uint64]int)
+	rows := make(map[string]int)
+
+	page := &htmlPage{
+		Title:               fmt.Sprintf("Votes of height %d", g.Height),
+		FirstDecisionRound:  g.FirstDecisionRound,
+		SecondDecisionRound: g.SecondDecisionRound,
+		Faulty:              g.faultyList(),
+		FaultyColour:        faultyColour,
+		Width:               svgMarginLeft + len(g.Rounds)*svgColumnWidth,
+		Height:              svgMarginTop + len(g.Processes)*svgRowHeight + svgMarginBottom,
+	}
+
+	for _, value := range g.Values {
+		page.Legend = append(page.Legend, &svgLegend{Label: "value " + value.String(), Colour: g.Colour(value)})
+	}
+	page.Legend = append(page.Legend, &svgLegend{Label: "value nil", Colour: nilColour})
+
+	for _, kind := range []string{LockEdge, JustificationEdge} {
+		page.EdgeLegend = append(page.EdgeLegend, &svgLegend{Label: kind, Colour: svgEdgeColours[kind]})
+	}
+
+	for i, round := range g.Rounds {
+		columns[round] = i
+		page.Columns = append(page.Columns, &svgText{X: svgMarginLeft + i*svgColumnWidth + svgBoxWidth/2, Y: svgMarginTop - 20, Text: g.RoundLabel(round)})
+	}
+
+	for i, processID := range g.Processes {
+		rows[processID] = i
+		colour := "#333333"
+		if g.IsFaulty(processID) {
+			colour = faultyColour
+		}
+		page.Rows = append(page.Rows, &svgText{X: svgMarginLeft - 15, Y: svgMarginTop + i*svgRowHeight + svgBoxHeight/2, Text: "process " + processID, Colour: colour})
+	}
+
+	for _, box := range g.Boxes {
+		page.Boxes = append(page.Boxes, g.svgBox(box, svgMarginLeft+columns[box.Round]*svgColumnWidth, svgMarginTop+rows[box.ProcessID]*svgRowHeight))
+	}
+
+	for _, edge := range g.Edges {
+		colour, drawn := svgEdgeColours[edge.Kind]
+		if !drawn {
+			continue
+		}
+
+		// from the right side of a box to the left side of the other one
+		x1 := svgMarginLeft + columns[edge.From.Round]*svgColumnWidth + svgBoxWidth
+		y1 := svgMarginTop + rows[edge.From.ProcessID]*svgRowHeight + svgBoxHeight/2
+		x2 := svgMarginLeft + columns[edge.To.Round]*svgColumnWidth
+		y2 := svgMarginTop + rows[edge.To.ProcessID]*svgRowHeight + svgBoxHeight/2
+
+		dash := ""
+		if edge.Kind == JustificationEdge {
+			dash = "4,3"
+		}
+
+		page.Edges = append(page.Edges, &svgEdge{
+			Path:   fmt.Sprintf("M %d %d C %d %d, %d %d, %d %d", x1, y1, x1+40, y1, x2-40, y2, x2, y2),
+			Colour: colour,
+			Dash:   dash,
+			Kind:   edge.Kind,
+			Label:  edge.Label,
+		})
+	}
+
+	err := htmlTemplate.Execute(writer, page)
+	if err != nil {
+		return fmt.Errorf("error while writing graph: %s", err)
+	}
+	return nil
+}
+
+// box of the SVG rendering at the given position
+func (g *Graph) svgBox(box *Box, x, y int) *svgBox {
+	rendered := &svgBox{
+		X:           x,
+		Y:           y,
+		Width:       svgBoxWidth,
+		Height:      svgBoxHeight,
+		Fill:        g.boxColour(box),
+		Stroke:      "#666666",
+		StrokeWidth: "1",
+		Title:       g.tooltip(box),
+	}
+
+	if g.IsFaulty(box.ProcessID) {
+		rendered.Stroke = faultyColour
+		rendered.StrokeWidth = "2"
+		if len(box.Faults) > 0 {
+			rendered.StrokeWidth = "4"
+		}
+	}
+
+	if !g.Received[box.ProcessID] {
+		rendered.Dash = "5,3"
+	}
+
+	lines := make([]string, 0)
+	for _, value := range box.Prevotes {
+		lines = append(lines, "PREVOTE "+value.String())
+	}
+	for _, value := range box.Precommits {
+		lines = append(lines, "PRECOMMIT "+value.String())
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no votes sent")
+	}
+	if len(box.Faults) > 0 {
+		lines = append(lines, strconv.Itoa(len(box.Faults))+" faults")
+	}
+
+	// lines centered vertically
+	top := y + (svgBoxHeight-len(lines)*svgLineHeight)/2 + svgLineHeight - 3
+	for i, line := range lines {
+		colour := "#333333"
+		if i == len(lines)-1 && len(box.Faults) > 0 {
+			colour = faultyColour
+		}
+		rendered.Lines = append(rendered.Lines, &svgText{X: x + svgBoxWidth/2, Y: top + i*svgLineHeight, Text: line, Colour: colour})
+	}
+
+	return rendered
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value uint64
+	name  string
+	help  string
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by the given amount
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits uint64
+	name string
+	help string
+}
+
+// Set sets the gauge to the given value
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Add adds the given (possibly negative) amount to the gauge
+func (g *Gauge) Add(delta float64) {
+	for {
+		oldBits := atomic.LoadUint64(&g.bits)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, oldBits, newBits) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Summary tracks the number and the sum of the observed values (e.g. durations)
+type Summary struct {
+	count uint64
+	sum   float64
+	name  string
+	help  string
+	mutex sync.Mutex
+}
+
+// Observe adds a new observation to the summary
+func (s *Summary) Observe(value float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+	s.sum += value
+}
+
+// Count returns the number of observations made so far
+func (s *Summary) Count() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Sum returns the sum of the observations made so far
+func (s *Summary) Sum() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sum
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+
+	registry := NewRegistry()
+
+	counter := registry.NewCounter("test_packets_total", "Number of packets")
+	gauge := registry.NewGauge("test_faulty", "Number of faulty processes")
+	summary := registry.NewSummary("test_duration_seconds", "Duration of the run")
+
+	counter.Inc()
+	counter.Add(2)
+	gauge.Set(4)
+	gauge.Add(-1.5)
+	summary.Observe(0.5)
+	summary.Observe(1)
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write metrics: %s", err)
+	}
+
+	expected := `# HELP test_packets_total Number of packets
+# TYPE test_packets_total counter
+test_packets_total 3
+# HELP test_faulty Number of faulty processes
+# TYPE test_faulty gauge
+test_faulty 2.5
+# HELP test_duration_seconds Duration of the run
+# TYPE test_duration_seconds summary
+test_duration_seconds_sum 1.5
+test_duration_seconds_count 2
+`
+
+	if buf.String() != expected {
+		t.Fatalf("Metrics were not written correctly, got:\n%s", buf.String())
+	}
+}
+
+func TestRegistry_SameNameReturnsSameMetric(t *testing.T) {
+
+	registry := NewRegistry()
+
+	first := registry.NewCounter("test_total", "Test counter")
+	second := registry.NewCounter("test_total", "Test counter")
+
+	first.Inc()
+
+	if second.Value() != 1 {
+		t.Fatal("Registry should have returned the counter already registered")
+	}
+}
+
+func TestRegistry_SameNameDifferentTypePanics(t *testing.T) {
+
+	registry := NewRegistry()
+	registry.NewCounter("test_total", "Test counter")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Registry should have panicked")
+		}
+	}()
+
+	registry.NewGauge("test_total", "Test gauge")
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+
+	registry := NewRegistry()
+	registry.NewCounter("test_requests_total", "Number of requests").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", metricsPath, nil))
+
+	body, _ := ioutil.ReadAll(recorder.Body)
+	if !strings.Contains(string(body), "test_requests_total 1\n") {
+		t.Fatalf("Metrics endpoint returned unexpected body:\n%s", body)
+	}
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatal("Metrics endpoint returned unexpected content type")
+	}
+}
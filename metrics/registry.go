@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// path where the metrics are exposed
+const metricsPath = "/metrics"
+
+// DefaultRegistry is the registry used by the package-level constructors
+var DefaultRegistry = NewRegistry()
+
+// Registry stores metrics and exposes them in the Prometheus text format
+type Registry struct {
+	metrics []interface{}
+	names   map[string]interface{}
+	mutex   sync.RWMutex
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make([]interface{}, 0),
+		names:   make(map[string]interface{}),
+	}
+}
+
+// NewCounter registers a new counter in the default registry
+func NewCounter(name, help string) *Counter {
+	return DefaultRegistry.NewCounter(name, help)
+}
+
+// NewGauge registers a new gauge in the default registry
+func NewGauge(name, help string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help)
+}
+
+// NewSummary registers a new summary in the default registry
+func NewSummary(name, help string) *Summary {
+	return DefaultRegistry.NewSummary(name, help)
+}
+
+// NewCounter registers a new counter, or returns the one already registered with the same name
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.register(name, &Counter{name: name, help: help}).(*Counter)
+}
+
+// NewGauge registers a new gauge, or returns the one already registered with the same name
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.register(name, &Gauge{name: name, help: help}).(*Gauge)
+}
+
+// NewSummary registers a new summary, or returns the one already registered with the same name
+func (r *Registry) NewSummary(name, help string) *Summary {
+	return r.register(name, &Summary{name: name, help: help}).(*Summary)
+}
+
+// store a metric if its name is new, panic if the name is already used by a metric of another type
+func (r *Registry) register(name string, metric interface{}) interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	registered, loaded := r.names[name]
+	if loaded {
+		if reflect.TypeOf(registered) != reflect.TypeOf(metric) {
+			panic(fmt.Sprintf("metric %s already registered with a different type", name))
+		}
+		return registered
+	}
+
+	r.names[name] = metric
+	r.metrics = append(r.metrics, metric)
+	return metric
+}
+
+// WriteTo writes all the metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var sb strings.Builder
+
+	for _, metric := range r.metrics {
+		switch m := metric.(type) {
+		case *Counter:
+			writeHeader(&sb, m.name, m.help, "counter")
+			writeSample(&sb, m.name, strconv.FormatUint(m.Value(), 10))
+
+		case *Gauge:
+			writeHeader(&sb, m.name, m.help, "gauge")
+			writeSample(&sb, m.name, formatFloat(m.Value()))
+
+		case *Summary:
+			writeHeader(&sb, m.name, m.help, "summary")
+			writeSample(&sb, m.name+"_sum", formatFloat(m.Sum()))
+			writeSample(&sb, m.name+"_count", strconv.FormatUint(m.Count(), 10))
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP writes the metrics as response of an http request
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// Serve exposes the metrics on the /metrics endpoint of the given address, it blocks until the http server fails
+func (r *Registry) Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, r)
+
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		return fmt.Errorf("error while serving metrics on %s: %s", address, err)
+	}
+
+	return nil
+}
+
+func writeHeader(sb *strings.Builder, name, help, metricType string) {
+	sb.WriteString("# HELP ")
+	sb.WriteString(name)
+	sb.WriteString(" ")
+	sb.WriteString(strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(help))
+	sb.WriteString("\n# TYPE ")
+	sb.WriteString(name)
+	sb.WriteString(" ")
+	sb.WriteString(metricType)
+	sb.WriteString("\n")
+}
+
+func writeSample(sb *strings.Builder, name, value string) {
+	sb.WriteString(name)
+	sb.WriteString(" ")
+	sb.WriteString(value)
+	sb.WriteString("\n")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
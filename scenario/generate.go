@@ -0,0 +1,318 @@
+package scenario
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"gopkg.in/yaml.v2"
+)
+
+// names of the files generated
+const (
+	monitorConfigFile = "config.yaml"
+	expectedFile      = "expected.yaml"
+)
+
+// SilentMode is the byzantine mode of the validators that never answer the monitor
+const SilentMode = "silent"
+
+// ValidatorConfig is the config file of a validator
+type ValidatorConfig struct {
+	ID        string                           `yaml:"id"`
+	Address   string                           `yaml:"address"`
+	Messages  map[uint64]*common.HeightVoteSet `yaml:"messages"`
+	Byzantine *ByzantineConfig                 `yaml:"byzantine,omitempty"`
+}
+
+// ByzantineConfig is the misbehaviour of a validator toward the monitor
+type ByzantineConfig struct {
+	Mode string `yaml:"mode"`
+}
+
+// MonitorConfig is the config file of the monitor
+type MonitorConfig struct {
+	Height              uint64   `yaml:"height"`
+	FirstDecisionRound  uint64   `yaml:"firstDecisionRound"`
+	SecondDecisionRound uint64   `yaml:"secondDecisionRound"`
+	Timeout             uint64   `yaml:"timeout"`
+	Validators          []string `yaml:"validators"`
+}
+
+// Expected contains the results of the accountability algorithm (in async mode) expected for the scenario
+type Expected struct {
+	// ids of the faulty validators, sorted
+	Faulty []string `yaml:"faulty"`
+	// true if at least f + 1 faulty validators are detected
+	Completed bool `yaml:"completed"`
+}
+
+// Configs contains the files generated for a scenario
+type Configs struct {
+	// validator configs, in the order of the validators of the scenario
+	Validators []*ValidatorConfig
+	Monitor    *MonitorConfig
+	Expected   *Expected
+
+	name string
+}
+
+// Generate creates the config files of the validators and the monitor for the scenario, with the results expected
+func (s *Scenario) Generate() (*Configs, error) {
+	s.setDefaults()
+
+	err := s.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %s", s.Name, err)
+	}
+
+	configs := &Configs{
+		Validators: make([]*ValidatorConfig, 0, len(s.Validators)),
+		Monitor: &MonitorConfig{
+			Height:              s.Height,
+			FirstDecisionRound:  s.Decisions.First,
+			SecondDecisionRound: s.Decisions.Second,
+			Timeout:             s.Timeout,
+			Validators:          make([]string, 0, len(s.Validators)),
+		},
+		name: s.Name,
+	}
+
+	logs := make(map[string]*common.HeightVoteSet)
+	for i, id := range s.Validators {
+		address := s.Network.Host + ":" + strconv.Itoa(s.Network.BasePort+i)
+		logs[id] = common.NewHeightVoteSet()
+
+		validator := &ValidatorConfig{
+			ID:       id,
+			Address:  address,
+			Messages: map[uint64]*common.HeightVoteSet{s.Height: logs[id]},
+		}
+		if s.Byzantine[id] == SilentStrategy {
+			validator.Byzantine = &ByzantineConfig{Mode: SilentMode}
+		}
+
+		configs.Validators = append(configs.Validators, validator)
+		configs.Monitor.Validators = append(configs.Monitor.Validators, address)
+	}
+
+	delivered := make(map[string]map[string]bool)
+	for _, round := range s.sortedRounds() {
+		s.sendVotes(round, logs, delivered)
+	}
+
+	configs.Expected = s.expected()
+
+	return configs, nil
+}
+
+// add the votes of a round to the message logs of the voters and of the validators receiving them
+// delivered contains the validators that already received each message, so that large scenarios are generated without scanning the messages received
+func (s *Scenario) sendVotes(round *Round, logs map[string]*common.HeightVoteSet, delivered map[string]map[string]bool) {
+	recipients := s.recipients(round)
+
+	types := []common.MessageType{common.Prevote}
+	if len(round.Voters) >= s.quorum() {
+		types = append(types, common.Precommit)
+	}
+
+	for _, messageType := range types {
+		for _, voter := range round.Voters {
+			values := []int64{round.Value}
+			if s.Byzantine[voter] == EquivocationStrategy {
+				values = append(values, s.conflictingValue(round))
+			}
+
+			for _, value := range values {
+				message := common.NewMessage(messageType, voter, round.Round, common.NewValue(value), nil)
+				logs[voter].AddMessage(message)
+
+				key := fmt.Sprintf("%s/%s/%d/%d", messageType, voter, round.Round, value)
+				if delivered[key] == nil {
+					delivered[key] = make(map[string]bool)
+				}
+
+				for _, recipient := range recipients {
+					if !delivered[key][recipient] {
+						delivered[key][recipient] = true
+						receive(logs[recipient], message)
+					}
+				}
+			}
+		}
+	}
+}
+
+// add a message to the messages received, without checking if it has already been received
+func receive(hvs *common.HeightVoteSet, message *common.Message) {
+	vs, loaded := hvs.VoteSetMap[message.Round]
+	if vs == nil || !loaded {
+		vs = common.NewVoteSet()
+		hvs.VoteSetMap[message.Round] = vs
+	}
+
+	if message.Type == common.Prevote {
+		vs.ReceivedPrevoteMessages = append(vs.ReceivedPrevoteMessages, message)
+	} else {
+		vs.ReceivedPrecommitMessages = append(vs.ReceivedPrecommitMessages, message)
+	}
+}
+
+// get the validators receiving the votes of a round, voters included
+func (s *Scenario) recipients(round *Round) []string {
+	if s.Network.Delivery == DeliverAll {
+		return s.Validators
+	}
+	return append(append([]string{}, round.Voters...), round.DeliverTo...)
+}
+
+// check if the votes of a round are received by a validator that sends its message logs to the monitor
+func (s *Scenario) visible(round *Round) bool {
+	for _, recipient := range s.recipients(round) {
+		if s.Byzantine[recipient] != SilentStrategy {
+			return true
+		}
+	}
+	return false
+}
+
+// get the value voted by the equivocating validators besides the value of the round: the value decided in another round, or the next value if all the values decided are the same
+func (s *Scenario) conflictingValue(round *Round) int64 {
+	for _, decision := range append(s.decisions(s.Decisions.First), s.decisions(s.Decisions.Second)...) {
+		if decision.Value != round.Value {
+			return decision.Value
+		}
+	}
+	return round.Value + 1
+}
+
+// get the results expected from the accountability algorithm in async mode, where the votes have no justifications
+// a byzantine validator is detected if the monitor sees its votes for different values in the same round, or if it sends its message logs and prevotes after having precommitted
+func (s *Scenario) expected() *Expected {
+	faulty := make([]string, 0)
+
+	for _, id := range s.Validators {
+		strategy, byzantine := s.Byzantine[id]
+		if !byzantine {
+			continue
+		}
+
+		// values voted in each round between the decision rounds, as seen by the monitor, and first round with a precommit
+		values := make(map[uint64]map[int64]bool)
+		var lockedRound uint64
+		locked := false
+		prevoteAfterLock := false
+
+		for _, round := range s.sortedRounds() {
+			if round.Round < s.Decisions.First || round.Round > s.Decisions.Second || !contains(round.Voters, id) || !s.visible(round) {
+				continue
+			}
+
+			if values[round.Round] == nil {
+				values[round.Round] = make(map[int64]bool)
+			}
+			values[round.Round][round.Value] = true
+			if strategy == EquivocationStrategy {
+				values[round.Round][s.conflictingValue(round)] = true
+			}
+
+			if locked && round.Round > lockedRound {
+				prevoteAfterLock = true
+			}
+			if !locked && len(round.Voters) >= s.quorum() {
+				locked = true
+				lockedRound = round.Round
+			}
+		}
+
+		equivocated := false
+		for _, roundValues := range values {
+			if len(roundValues) > 1 {
+				equivocated = true
+			}
+		}
+
+		if equivocated || (prevoteAfterLock && strategy != SilentStrategy) {
+			faulty = append(faulty, id)
+		}
+	}
+
+	sort.Strings(faulty)
+
+	return &Expected{
+		Faulty:    faulty,
+		Completed: len(faulty) >= s.maxFaulty()+1,
+	}
+}
+
+// Files returns the content of the files generated, indexed by their name
+func (configs *Configs) Files() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	for _, validator := range configs.Validators {
+		data, err := encode(validator, fmt.Sprintf("auto-generated config file for validator %s of scenario %s", validator.ID, configs.name))
+		if err != nil {
+			return nil, err
+		}
+		files["config_"+validator.ID+".yaml"] = data
+	}
+
+	data, err := encode(configs.Monitor, "auto-generated config file for the monitor of scenario "+configs.name)
+	if err != nil {
+		return nil, err
+	}
+	files[monitorConfigFile] = data
+
+	data, err = encode(configs.Expected, "results expected for scenario "+configs.name)
+	if err != nil {
+		return nil, err
+	}
+	files[expectedFile] = data
+
+	return files, nil
+}
+
+// Write writes the files generated in the given directory, created if needed
+func (configs *Configs) Write(directory string) error {
+	files, err := configs.Files()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		return fmt.Errorf("error while creating output directory: %s", err)
+	}
+
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(directory, name), data, 0644)
+		if err != nil {
+			return fmt.Errorf("error while writing %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// encode a config file in yaml, with a comment on top
+func encode(config interface{}, comment string) ([]byte, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding config file: %s", err)
+	}
+	return append([]byte("--- # "+comment+"\n"), data...), nil
+}
+
+// check if a list of ids contains the given id
+func contains(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package scenario
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// values decided in the pattern scenario
+const (
+	patternFirstValue  = 10
+	patternSecondValue = 20
+)
+
+// Pattern creates a scenario with the given number of validators and rounds, used for benchmarks
+// the first 2f validators are byzantine and vote for a value in the first round and for another value in the last round
+// the honest validators are split in two groups, each one voting with the byzantine validators in one of the two rounds
+func Pattern(numValidators, numRounds int) (*Scenario, error) {
+	if numValidators < 4 || numRounds < 1 {
+		return nil, fmt.Errorf("at least 4 validators and 1 round are needed for a fork")
+	}
+
+	faulty := (numValidators - 1) / 3
+	threshold := (numValidators - 2*faulty) / 2
+
+	scenario := &Scenario{
+		Name:       fmt.Sprintf("pattern with %d validators and %d rounds", numValidators, numRounds),
+		Validators: make([]string, 0, numValidators),
+		Byzantine:  make(map[string]string),
+		Decisions:  &Decisions{First: 1, Second: uint64(numRounds)},
+	}
+
+	for i := 1; i <= numValidators; i++ {
+		scenario.Validators = append(scenario.Validators, strconv.Itoa(i))
+	}
+
+	firstRound := &Round{Round: 1, Value: patternFirstValue, Voters: scenario.Validators[:2*faulty+threshold]}
+	secondRound := &Round{Round: uint64(numRounds), Value: patternSecondValue, Voters: append(append([]string{}, scenario.Validators[:2*faulty]...), scenario.Validators[2*faulty+threshold:]...)}
+	scenario.Rounds = []*Round{firstRound, secondRound}
+
+	for _, id := range scenario.Validators[:2*faulty] {
+		scenario.Byzantine[id] = AmnesiaStrategy
+	}
+
+	return scenario, nil
+}
@@ -0,0 +1,240 @@
+package scenario
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+// strategies of the byzantine validators
+const (
+	// vote as described in the rounds, regardless of the values locked before
+	AmnesiaStrategy = "amnesia"
+	// vote as described in the rounds and also for a conflicting value in the same rounds
+	EquivocationStrategy = "equivocation"
+	// vote as described in the rounds and never send the message logs to the monitor
+	SilentStrategy = "silent"
+)
+
+// delivery of the votes to the validators
+const (
+	// votes are delivered to all the validators
+	DeliverAll = "all"
+	// votes are delivered only to the voters of the round and to the validators given in the round
+	DeliverVoters = "voters"
+)
+
+// default values of the scenario settings
+const (
+	defaultHeight   = 1
+	defaultTimeout  = 60
+	defaultHost     = "127.0.0.1"
+	defaultBasePort = 8080
+)
+
+// Scenario describes declaratively a fork: the validators, the votes sent in each round, the decisions and the delivery of the votes
+type Scenario struct {
+	Name   string `yaml:"name"`
+	Height uint64 `yaml:"height"`
+
+	// ids of the validators, all with the same voting power
+	Validators []string `yaml:"validators"`
+	// strategy of the byzantine validators indexed by their id, the other validators are honest
+	Byzantine map[string]string `yaml:"byzantine"`
+
+	Rounds    []*Round   `yaml:"rounds"`
+	Decisions *Decisions `yaml:"decisions"`
+	Network   *Network   `yaml:"network"`
+
+	// time (in seconds) the monitor waits for the message logs, default value used if 0
+	Timeout uint64 `yaml:"timeout"`
+}
+
+// Round describes the votes for a value in a round, there can be more rounds with the same number voting for different values
+// voters send a prevote for the value and also a precommit if they are a quorum
+type Round struct {
+	Round  uint64   `yaml:"round"`
+	Value  int64    `yaml:"value"`
+	Voters []string `yaml:"voters"`
+	// validators receiving the votes besides the voters, relevant only if the votes are not delivered to all the validators
+	DeliverTo []string `yaml:"deliverTo"`
+}
+
+// Decisions are the two rounds where different values have been decided, causing the fork
+type Decisions struct {
+	First  uint64 `yaml:"first"`
+	Second uint64 `yaml:"second"`
+}
+
+// Network describes the addresses of the validators and the delivery of the votes
+type Network struct {
+	// validators listen on consecutive ports starting from the base port, default values used if not given
+	Host     string `yaml:"host"`
+	BasePort int    `yaml:"basePort"`
+	// delivery of the votes (all or voters), all if not given
+	Delivery string `yaml:"delivery"`
+}
+
+// Load parses a scenario file given its path relative to the project root directory
+func Load(scenarioFile string) (*Scenario, error) {
+	scenario := &Scenario{}
+	err := utils.ParseConfigFile(scenarioFile, scenario)
+	if err != nil {
+		return nil, err
+	}
+
+	return scenario, nil
+}
+
+// set the default values of the settings not given
+func (s *Scenario) setDefaults() {
+	if s.Height == 0 {
+		s.Height = defaultHeight
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaultTimeout
+	}
+	if s.Network == nil {
+		s.Network = &Network{}
+	}
+	if s.Network.Host == "" {
+		s.Network.Host = defaultHost
+	}
+	if s.Network.BasePort == 0 {
+		s.Network.BasePort = defaultBasePort
+	}
+	if s.Network.Delivery == "" {
+		s.Network.Delivery = DeliverAll
+	}
+}
+
+// get the minimum number of voters deciding a value (2f + 1)
+func (s *Scenario) quorum() int {
+	n := len(s.Validators)
+	return n - (n-1)/3
+}
+
+// get the maximum number of faulty validators tolerated (f)
+func (s *Scenario) maxFaulty() int {
+	return (len(s.Validators) - 1) / 3
+}
+
+// check that the scenario describes a fork where the honest validators follow the consensus algorithm
+func (s *Scenario) validate() error {
+	if len(s.Validators) == 0 {
+		return fmt.Errorf("no validators given")
+	}
+
+	validators := make(map[string]bool)
+	for _, id := range s.Validators {
+		if validators[id] {
+			return fmt.Errorf("validator %s given more than once", id)
+		}
+		validators[id] = true
+	}
+
+	for id, strategy := range s.Byzantine {
+		if !validators[id] {
+			return fmt.Errorf("byzantine validator %s is not a validator", id)
+		}
+
+		switch strategy {
+		case AmnesiaStrategy, EquivocationStrategy, SilentStrategy:
+		default:
+			return fmt.Errorf("unknown strategy %s of byzantine validator %s", strategy, id)
+		}
+	}
+
+	switch s.Network.Delivery {
+	case DeliverAll, DeliverVoters:
+	default:
+		return fmt.Errorf("unknown delivery %s", s.Network.Delivery)
+	}
+
+	// value and rounds where each honest validator voted and precommitted
+	honestValues := make(map[string]int64)
+	honestRounds := make(map[string]map[uint64]bool)
+	precommitted := make(map[string]uint64)
+
+	for _, round := range s.sortedRounds() {
+		if len(round.Voters) == 0 {
+			return fmt.Errorf("no voters in round %d", round.Round)
+		}
+
+		for _, id := range append(append([]string{}, round.Voters...), round.DeliverTo...) {
+			if !validators[id] {
+				return fmt.Errorf("validator %s in round %d is not a validator", id, round.Round)
+			}
+		}
+
+		for _, id := range round.Voters {
+			if s.isByzantine(id) {
+				continue
+			}
+
+			if value, loaded := honestValues[id]; loaded && value != round.Value {
+				return fmt.Errorf("honest validator %s votes for different values", id)
+			}
+			honestValues[id] = round.Value
+
+			if honestRounds[id] == nil {
+				honestRounds[id] = make(map[uint64]bool)
+			}
+			if honestRounds[id][round.Round] {
+				return fmt.Errorf("honest validator %s votes more than once in round %d", id, round.Round)
+			}
+			honestRounds[id][round.Round] = true
+
+			// votes after a precommit need justifications, which are not generated
+			if lockedRound, locked := precommitted[id]; locked && round.Round > lockedRound {
+				return fmt.Errorf("honest validator %s votes in round %d after precommitting in round %d", id, round.Round, lockedRound)
+			}
+			if len(round.Voters) >= s.quorum() {
+				precommitted[id] = round.Round
+			}
+		}
+	}
+
+	if s.Decisions == nil {
+		return fmt.Errorf("no decisions given")
+	}
+	if s.Decisions.First > s.Decisions.Second {
+		return fmt.Errorf("first decision round %d after second decision round %d", s.Decisions.First, s.Decisions.Second)
+	}
+
+	// the fork requires two decisions for different values
+	for _, first := range s.decisions(s.Decisions.First) {
+		for _, second := range s.decisions(s.Decisions.Second) {
+			if first.Value != second.Value {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no different values decided in rounds %d and %d", s.Decisions.First, s.Decisions.Second)
+}
+
+// get the rounds with the given number where a quorum of voters decided the value
+func (s *Scenario) decisions(roundNumber uint64) []*Round {
+	decisions := make([]*Round, 0)
+	for _, round := range s.Rounds {
+		if round.Round == roundNumber && len(round.Voters) >= s.quorum() {
+			decisions = append(decisions, round)
+		}
+	}
+	return decisions
+}
+
+// get the rounds sorted by number, rounds with the same number keep their order
+func (s *Scenario) sortedRounds() []*Round {
+	rounds := append([]*Round{}, s.Rounds...)
+	sort.SliceStable(rounds, func(i, j int) bool { return rounds[i].Round < rounds[j].Round })
+	return rounds
+}
+
+// check if the validator is byzantine
+func (s *Scenario) isByzantine(id string) bool {
+	_, loaded := s.Byzantine[id]
+	return loaded
+}
@@ -0,0 +1,172 @@
+package scenario
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mikanikos/Fork-Accountability/accountability"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+const scenariosDirectory = "scenario/_scenarios"
+
+// run with -update to write the golden files again after changing the scenarios or the generator
+var update = flag.Bool("update", false, "update the golden files of the scenarios")
+
+// run the accountability algorithm in async mode on the message logs sent to the monitor and check the results expected
+func checkExpectedResults(t *testing.T, configs *Configs) {
+	acc := accountability.NewAccountability()
+	acc.Init(uint64(len(configs.Validators)), true)
+
+	for _, validator := range configs.Validators {
+		if validator.Byzantine != nil && validator.Byzantine.Mode == SilentMode {
+			continue
+		}
+		acc.StoreHvs(validator.ID, validator.Messages[configs.Monitor.Height])
+	}
+
+	acc.Run(configs.Monitor.FirstDecisionRound, configs.Monitor.SecondDecisionRound)
+
+	if !reflect.DeepEqual(acc.GetFaultyProcesses(), configs.Expected.Faulty) {
+		t.Fatalf("Faulty processes detected %v, expected %v", acc.GetFaultyProcesses(), configs.Expected.Faulty)
+	}
+	if acc.IsCompleted() != configs.Expected.Completed {
+		t.Fatalf("Algorithm completion %t, expected %t", acc.IsCompleted(), configs.Expected.Completed)
+	}
+}
+
+// generate the config files of every scenario, compare them with the golden files and check the results of the algorithm
+func TestScenarios_Golden(t *testing.T) {
+	directory, err := utils.GetProjectFilePath(scenariosDirectory)
+	if err != nil {
+		t.Fatalf("Failed to get scenarios directory: %s", err)
+	}
+
+	scenarioFiles, err := filepath.Glob(filepath.Join(directory, "*.yaml"))
+	if err != nil || len(scenarioFiles) == 0 {
+		t.Fatalf("No scenario files found: %v", err)
+	}
+
+	for _, scenarioFile := range scenarioFiles {
+		name := strings.TrimSuffix(filepath.Base(scenarioFile), ".yaml")
+
+		t.Run(name, func(t *testing.T) {
+			s, err := Load(filepath.Join(scenariosDirectory, filepath.Base(scenarioFile)))
+			if err != nil {
+				t.Fatalf("Failed to load scenario: %s", err)
+			}
+
+			configs, err := s.Generate()
+			if err != nil {
+				t.Fatalf("Failed to generate scenario: %s", err)
+			}
+
+			goldenDirectory := filepath.Join(directory, name)
+			if *update {
+				_ = os.RemoveAll(goldenDirectory)
+				if err := configs.Write(goldenDirectory); err != nil {
+					t.Fatalf("Failed to write golden files: %s", err)
+				}
+			}
+
+			files, err := configs.Files()
+			if err != nil {
+				t.Fatalf("Failed to encode files: %s", err)
+			}
+
+			goldenFiles, err := ioutil.ReadDir(goldenDirectory)
+			if err != nil {
+				t.Fatalf("Failed to read golden files: %s", err)
+			}
+			if len(goldenFiles) != len(files) {
+				t.Fatalf("Generated %d files, expected %d", len(files), len(goldenFiles))
+			}
+
+			for fileName, data := range files {
+				golden, err := ioutil.ReadFile(filepath.Join(goldenDirectory, fileName))
+				if err != nil {
+					t.Fatalf("Failed to read golden file %s: %s", fileName, err)
+				}
+				if !bytes.Equal(data, golden) {
+					t.Fatalf("Generated %s differs from golden file:\n%s\nexpected:\n%s", fileName, data, golden)
+				}
+			}
+
+			checkExpectedResults(t, configs)
+		})
+	}
+}
+
+func TestScenarios_Pattern(t *testing.T) {
+	for _, numValidators := range []int{4, 7, 10} {
+		for _, numRounds := range []int{1, 4} {
+			s, err := Pattern(numValidators, numRounds)
+			if err != nil {
+				t.Fatalf("Failed to create pattern: %s", err)
+			}
+
+			configs, err := s.Generate()
+			if err != nil {
+				t.Fatalf("Failed to generate pattern with %d validators and %d rounds: %s", numValidators, numRounds, err)
+			}
+
+			if len(configs.Expected.Faulty) != 2*((numValidators-1)/3) || !configs.Expected.Completed {
+				t.Fatalf("Wrong results expected for pattern with %d validators and %d rounds: %+v", numValidators, numRounds, configs.Expected)
+			}
+
+			checkExpectedResults(t, configs)
+		}
+	}
+
+	// the honest validators can't be split in two quorums with 5 validators
+	s, err := Pattern(5, 2)
+	if err != nil {
+		t.Fatalf("Failed to create pattern: %s", err)
+	}
+	if _, err := s.Generate(); err == nil {
+		t.Fatal("Generating a pattern without fork should fail")
+	}
+}
+
+func TestScenarios_Invalid(t *testing.T) {
+	newScenario := func() *Scenario {
+		return &Scenario{
+			Validators: []string{"1", "2", "3", "4"},
+			Byzantine:  map[string]string{"3": AmnesiaStrategy, "4": AmnesiaStrategy},
+			Rounds: []*Round{
+				{Round: 1, Value: 10, Voters: []string{"1", "3", "4"}},
+				{Round: 2, Value: 20, Voters: []string{"2", "3", "4"}},
+			},
+			Decisions: &Decisions{First: 1, Second: 2},
+		}
+	}
+
+	if _, err := newScenario().Generate(); err != nil {
+		t.Fatalf("Failed to generate valid scenario: %s", err)
+	}
+
+	invalid := map[string]func(s *Scenario){
+		"unknown strategy":            func(s *Scenario) { s.Byzantine["3"] = "unknown" },
+		"unknown validator":           func(s *Scenario) { s.Rounds[0].Voters = append(s.Rounds[0].Voters, "5") },
+		"unknown delivery":            func(s *Scenario) { s.Network = &Network{Delivery: "unknown"} },
+		"honest votes after lock":     func(s *Scenario) { s.Rounds[1].Voters = []string{"1", "3", "4"} },
+		"honest votes for two values": func(s *Scenario) { s.Rounds = append(s.Rounds, &Round{Round: 0, Value: 20, Voters: []string{"1"}}) },
+		"same values decided":         func(s *Scenario) { s.Rounds[1].Value = 10 },
+		"no decisions":                func(s *Scenario) { s.Decisions = nil },
+		"duplicated validator":        func(s *Scenario) { s.Validators = append(s.Validators, "1") },
+	}
+
+	for description, change := range invalid {
+		s := newScenario()
+		change(s)
+		if _, err := s.Generate(); err == nil {
+			t.Fatalf("Generating scenario with %s should fail", description)
+		}
+	}
+}
@@ -16,6 +16,10 @@ go build
 cd ..
 cd monitor
 go build
+
+cd ..
+cd scenario
+go build
 cd ../..
 cd scripts
 
@@ -34,7 +38,7 @@ do
         echo "Generating config files for $n validators and $m rounds
 " >> $benchmark_report
 
-        python3 config_generator.py -N $n -M $m
+        ./../cmd/scenario/scenario -validators=$n -rounds=$m -output="scripts"
         
         for ((i = 1; i <= n; i++))
         do
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/wal"
+	"go.dedis.ch/protobuf"
+)
+
+// kinds of the records of the file store
+const (
+	sentRecord     = 0
+	receivedRecord = 1
+	importRecord   = 2
+	pruneRecord    = 3
+)
+
+// number of heights kept in memory by default
+const defaultCacheSize = 16
+
+// record appended to the file for every change of the message logs
+type record struct {
+	Kind    uint32
+	Height  uint64
+	Message *common.Message
+	Hvs     *common.HeightVoteSet
+	Round   uint64
+}
+
+// FileStore keeps the message logs in an append-only file, every change is on disk before the method returns
+// only the offsets of the records of each height are kept in memory, the message logs of a height are read from the file when requested
+// the message logs of the heights requested most recently are cached in memory
+type FileStore struct {
+	// maximum number of heights whose message logs are cached in memory, default value used if 0
+	CacheSize int
+
+	log *wal.Log
+
+	// offsets in the file of the records with the messages of each height
+	offsets map[uint64][]int64
+	// message logs cached and rounds pruned of every height
+	cache *MemoryStore
+	// heights cached, from the least recently used
+	cached []uint64
+	mutex  sync.Mutex
+}
+
+// OpenFileStore opens (or creates) the file store at the given path and indexes the message logs recorded in it
+func OpenFileStore(path string) (*FileStore, error) {
+	l, err := wal.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{log: l, offsets: make(map[uint64][]int64), cache: NewMemoryStore()}
+
+	err = l.ReplayRecords(func(offset int64, data []byte) error {
+		r, err := decodeRecord(data)
+		if err != nil {
+			return err
+		}
+		return fs.index(offset, r)
+	})
+	if err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// RecordSent records a message sent by the validator at the given height
+func (fs *FileStore) RecordSent(height uint64, message *common.Message) error {
+	if message == nil {
+		return errNilMessage
+	}
+	return fs.append(&record{Kind: sentRecord, Height: height, Message: message})
+}
+
+// RecordReceived records a message received by the validator at the given height
+func (fs *FileStore) RecordReceived(height uint64, message *common.Message) error {
+	if message == nil {
+		return errNilMessage
+	}
+	return fs.append(&record{Kind: receivedRecord, Height: height, Message: message})
+}
+
+// Import adds all the messages of a height vote set to the message logs of the given height, in a single record
+func (fs *FileStore) Import(height uint64, hvs *common.HeightVoteSet) error {
+	if hvs == nil {
+		return errNilMessage
+	}
+	return fs.append(&record{Kind: importRecord, Height: height, Hvs: hvs})
+}
+
+// Get returns a copy of the message logs for the given height, nil if there are none
+// the message logs are read from the file if they are not cached
+func (fs *FileStore) Get(height uint64) (*common.HeightVoteSet, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	offsets, loaded := fs.offsets[height]
+	if !loaded {
+		return nil, nil
+	}
+
+	if !fs.isCached(height) {
+		for _, offset := range offsets {
+			data, err := fs.log.ReadRecord(offset)
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := decodeRecord(data)
+			if err != nil {
+				return nil, err
+			}
+
+			err = fs.apply(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fs.markUsed(height)
+
+	return fs.cache.Get(height)
+}
+
+// Heights returns the heights with message logs, sorted
+func (fs *FileStore) Heights() ([]uint64, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	heights := make([]uint64, 0, len(fs.offsets))
+	for height := range fs.offsets {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	return heights, nil
+}
+
+// Prune discards the messages of the rounds below the given one at the given height, the ones recorded later too
+func (fs *FileStore) Prune(height uint64, belowRound uint64) error {
+	return fs.append(&record{Kind: pruneRecord, Height: height, Round: belowRound})
+}
+
+// PrunedBelow returns the round below which the messages of the given height have been discarded, 0 if none
+func (fs *FileStore) PrunedBelow(height uint64) (uint64, error) {
+	return fs.cache.PrunedBelow(height)
+}
+
+// Close closes the file
+func (fs *FileStore) Close() error {
+	return fs.log.Close()
+}
+
+// write a record on disk, then index it and apply it to the message logs cached
+func (fs *FileStore) append(r *record) error {
+	data, err := protobuf.Encode(r)
+	if err != nil {
+		return fmt.Errorf("error while serializing store record: %s", err)
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	offset, err := fs.log.AppendRecord(data)
+	if err != nil {
+		return err
+	}
+
+	// the message logs not cached are read from the file with this record when requested
+	cached := fs.isCached(r.Height)
+
+	err = fs.index(offset, r)
+	if err != nil || r.Kind == pruneRecord || !cached {
+		return err
+	}
+
+	return fs.apply(r)
+}
+
+// keep the offset of a record with messages, the rounds pruned are applied immediately
+func (fs *FileStore) index(offset int64, r *record) error {
+	switch r.Kind {
+	case sentRecord, receivedRecord, importRecord:
+		fs.offsets[r.Height] = append(fs.offsets[r.Height], offset)
+		return nil
+	case pruneRecord:
+		return fs.cache.Prune(r.Height, r.Round)
+	}
+	return fmt.Errorf("unknown store record kind %d", r.Kind)
+}
+
+// apply a record with messages to the message logs cached
+func (fs *FileStore) apply(r *record) error {
+	switch r.Kind {
+	case sentRecord:
+		return fs.cache.RecordSent(r.Height, r.Message)
+	case receivedRecord:
+		return fs.cache.RecordReceived(r.Height, r.Message)
+	case importRecord:
+		// an empty height vote set may be decoded as nil
+		if r.Hvs == nil {
+			r.Hvs = common.NewHeightVoteSet()
+		}
+		return fs.cache.Import(r.Height, r.Hvs)
+	}
+	return fmt.Errorf("unknown store record kind %d", r.Kind)
+}
+
+// check if the message logs of a height are cached
+func (fs *FileStore) isCached(height uint64) bool {
+	for _, cached := range fs.cached {
+		if cached == height {
+			return true
+		}
+	}
+	return false
+}
+
+// move a height to the end of the heights cached, removing the least recently used ones if the cache is full
+func (fs *FileStore) markUsed(height uint64) {
+	for i, cached := range fs.cached {
+		if cached == height {
+			fs.cached = append(fs.cached[:i], fs.cached[i+1:]...)
+			break
+		}
+	}
+	fs.cached = append(fs.cached, height)
+
+	cacheSize := fs.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+
+	for len(fs.cached) > cacheSize {
+		fs.cache.evict(fs.cached[0])
+		fs.cached = fs.cached[1:]
+	}
+}
+
+// decode a record of the file
+func decodeRecord(data []byte) (*record, error) {
+	r := &record{}
+	err := protobuf.Decode(data, r)
+	if err != nil {
+		return nil, fmt.Errorf("error while deserializing store record: %s", err)
+	}
+	return r, nil
+}
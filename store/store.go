@@ -0,0 +1,169 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+)
+
+// returned when trying to record nothing
+var errNilMessage = errors.New("error while recording message logs: nothing to record")
+
+// Store keeps the message logs of a validator, organized by height
+type Store interface {
+	// RecordSent records a message sent by the validator at the given height
+	RecordSent(height uint64, message *common.Message) error
+	// RecordReceived records a message received by the validator at the given height
+	RecordReceived(height uint64, message *common.Message) error
+	// Import adds all the messages of a height vote set to the message logs of the given height
+	Import(height uint64, hvs *common.HeightVoteSet) error
+	// Get returns a copy of the message logs for the given height, nil if there are none
+	Get(height uint64) (*common.HeightVoteSet, error)
+	// Heights returns the heights with message logs, sorted
+	Heights() ([]uint64, error)
+	// Prune discards the messages of the rounds below the given one at the given height, the ones recorded later too
+	Prune(height uint64, belowRound uint64) error
+	// PrunedBelow returns the round below which the messages of the given height have been discarded, 0 if none
+	PrunedBelow(height uint64) (uint64, error)
+	// Close releases the resources used by the store
+	Close() error
+}
+
+// MemoryStore keeps the message logs in memory, they are lost when the process exits
+type MemoryStore struct {
+	logs map[uint64]*common.HeightVoteSet
+	// round below which the messages of each height have been discarded
+	pruned map[uint64]uint64
+	mutex  sync.RWMutex
+}
+
+// NewMemoryStore creates a new empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		logs:   make(map[uint64]*common.HeightVoteSet),
+		pruned: make(map[uint64]uint64),
+	}
+}
+
+// RecordSent records a message sent by the validator at the given height
+func (ms *MemoryStore) RecordSent(height uint64, message *common.Message) error {
+	if message == nil {
+		return errNilMessage
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.getOrCreate(height).AddMessage(message)
+	ms.discardPruned(height)
+	return nil
+}
+
+// RecordReceived records a message received by the validator at the given height
+func (ms *MemoryStore) RecordReceived(height uint64, message *common.Message) error {
+	if message == nil {
+		return errNilMessage
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.getOrCreate(height).AddReceivedMessage(message)
+	ms.discardPruned(height)
+	return nil
+}
+
+// Import adds all the messages of a height vote set to the message logs of the given height
+func (ms *MemoryStore) Import(height uint64, hvs *common.HeightVoteSet) error {
+	if hvs == nil {
+		return errNilMessage
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.getOrCreate(height).Merge(hvs)
+	ms.discardPruned(height)
+	return nil
+}
+
+// Get returns a copy of the message logs for the given height, nil if there are none
+func (ms *MemoryStore) Get(height uint64) (*common.HeightVoteSet, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	hvs, loaded := ms.logs[height]
+	if !loaded {
+		return nil, nil
+	}
+
+	return hvs.Copy(), nil
+}
+
+// Heights returns the heights with message logs, sorted
+func (ms *MemoryStore) Heights() ([]uint64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	heights := make([]uint64, 0, len(ms.logs))
+	for height := range ms.logs {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	return heights, nil
+}
+
+// Prune discards the messages of the rounds below the given one at the given height, the ones recorded later too
+func (ms *MemoryStore) Prune(height uint64, belowRound uint64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if belowRound > ms.pruned[height] {
+		ms.pruned[height] = belowRound
+		ms.discardPruned(height)
+	}
+	return nil
+}
+
+// PrunedBelow returns the round below which the messages of the given height have been discarded, 0 if none
+func (ms *MemoryStore) PrunedBelow(height uint64) (uint64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.pruned[height], nil
+}
+
+// Close does nothing, the message logs stay in memory
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+// get the message logs of a height, creating them if needed
+func (ms *MemoryStore) getOrCreate(height uint64) *common.HeightVoteSet {
+	hvs, loaded := ms.logs[height]
+	if !loaded {
+		hvs = common.NewHeightVoteSet()
+		ms.logs[height] = hvs
+	}
+	return hvs
+}
+
+// discard the messages of the rounds pruned at the given height
+func (ms *MemoryStore) discardPruned(height uint64) {
+	hvs, loaded := ms.logs[height]
+	if !loaded {
+		return
+	}
+
+	for round := range hvs.VoteSetMap {
+		if round < ms.pruned[height] {
+			delete(hvs.VoteSetMap, round)
+		}
+	}
+}
+
+// remove the message logs of a height from memory, the rounds pruned are kept
+func (ms *MemoryStore) evict(height uint64) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.logs, height)
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/mikanikos/Fork-Accountability/common"
+	"github.com/mikanikos/Fork-Accountability/utils"
+)
+
+func createTestStorePath(t *testing.T) (string, func()) {
+	directory, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	return path.Join(directory, "test.store"), func() { _ = os.RemoveAll(directory) }
+}
+
+// record some messages in the store and check they can be retrieved
+func recordAndCheck(t *testing.T, s Store) {
+	sent := common.NewMessage(common.Prevote, "1", 0, common.NewValue(1), nil)
+	received := common.NewMessage(common.Precommit, "2", 0, common.NewValue(1), nil)
+
+	if err := s.RecordSent(2, sent); err != nil {
+		t.Fatalf("Failed to record sent message: %s", err)
+	}
+	if err := s.RecordReceived(2, received); err != nil {
+		t.Fatalf("Failed to record received message: %s", err)
+	}
+	// duplicates are ignored
+	if err := s.RecordSent(2, sent); err != nil {
+		t.Fatalf("Failed to record sent message: %s", err)
+	}
+	if err := s.Import(1, utils.GetHvsForDefaultConfig1()); err != nil {
+		t.Fatalf("Failed to import message logs: %s", err)
+	}
+
+	if err := s.RecordSent(3, nil); err == nil {
+		t.Fatal("Recording nothing should fail")
+	}
+
+	checkStored(t, s)
+}
+
+// check the messages recorded by recordAndCheck
+func checkStored(t *testing.T, s Store) {
+	heights, err := s.Heights()
+	if err != nil || !reflect.DeepEqual(heights, []uint64{1, 2}) {
+		t.Fatalf("Wrong heights stored: %v", heights)
+	}
+
+	hvs, err := s.Get(2)
+	if err != nil || hvs == nil {
+		t.Fatalf("Failed to get message logs: %s", err)
+	}
+
+	vs := hvs.VoteSetMap[0]
+	if len(vs.SentPrevoteMessages) != 1 || len(vs.ReceivedPrecommitMessages) != 1 || len(vs.ReceivedPrevoteMessages) != 0 {
+		t.Fatal("Messages were not stored correctly")
+	}
+
+	imported, err := s.Get(1)
+	if err != nil || len(imported.VoteSetMap) != len(utils.GetHvsForDefaultConfig1().VoteSetMap) || !imported.IsValid("1") {
+		t.Fatal("Message logs were not imported correctly")
+	}
+
+	if hvs, err := s.Get(3); err != nil || hvs != nil {
+		t.Fatal("No message logs expected for unknown height")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	recordAndCheck(t, s)
+
+	// the message logs returned are copies
+	hvs, _ := s.Get(2)
+	hvs.VoteSetMap[0].SentPrevoteMessages = nil
+	delete(hvs.VoteSetMap, 0)
+
+	checkStored(t, s)
+}
+
+func TestFileStore_RecoverAfterRestart(t *testing.T) {
+
+	storePath, cleanup := createTestStorePath(t)
+	defer cleanup()
+
+	s, err := OpenFileStore(storePath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %s", err)
+	}
+	recordAndCheck(t, s)
+	_ = s.Close()
+
+	s, err = OpenFileStore(storePath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %s", err)
+	}
+	defer s.Close()
+
+	checkStored(t, s)
+
+	// new records are appended after the ones restored
+	_ = s.RecordSent(5, common.NewMessage(common.Precommit, "1", 2, common.NewValue(1), nil))
+	_ = s.Close()
+
+	s, err = OpenFileStore(storePath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %s", err)
+	}
+	defer s.Close()
+
+	if heights, _ := s.Heights(); len(heights) != 3 {
+		t.Fatal("Record appended after restart was not stored")
+	}
+}
+
+// prune the oldest rounds of a height and check they are not stored anymore
+func pruneAndCheck(t *testing.T, s Store) {
+	if err := s.Import(1, utils.GetHvsForDefaultConfig2()); err != nil {
+		t.Fatalf("Failed to import message logs: %s", err)
+	}
+	if err := s.Prune(1, 4); err != nil {
+		t.Fatalf("Failed to prune message logs: %s", err)
+	}
+	// the messages of the rounds pruned recorded later are discarded too
+	if err := s.RecordSent(1, common.NewMessage(common.Prevote, "2", 3, common.NewValue(10), nil)); err != nil {
+		t.Fatalf("Failed to record sent message: %s", err)
+	}
+
+	checkPruned(t, s)
+}
+
+// check the rounds pruned by pruneAndCheck
+func checkPruned(t *testing.T, s Store) {
+	hvs, err := s.Get(1)
+	if err != nil || hvs == nil || len(hvs.VoteSetMap) != 1 || hvs.VoteSetMap[4] == nil {
+		t.Fatal("Rounds were not pruned correctly")
+	}
+
+	if prunedBelow, err := s.PrunedBelow(1); err != nil || prunedBelow != 4 {
+		t.Fatalf("Wrong round pruned: %d", prunedBelow)
+	}
+
+	if prunedBelow, err := s.PrunedBelow(2); err != nil || prunedBelow != 0 {
+		t.Fatal("No rounds expected to be pruned for other heights")
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	pruneAndCheck(t, NewMemoryStore())
+
+	storePath, cleanup := createTestStorePath(t)
+	defer cleanup()
+
+	s, err := OpenFileStore(storePath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %s", err)
+	}
+	pruneAndCheck(t, s)
+	_ = s.Close()
+
+	// the rounds pruned are not restored
+	s, err = OpenFileStore(storePath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %s", err)
+	}
+	defer s.Close()
+
+	checkPruned(t, s)
+}
+
+func TestFileStore_ReadHeightsOnDemand(t *testing.T) {
+
+	storePath, cleanup := createTestStorePath(t)
+	defer cleanup()
+
+	s, err := OpenFileStore(storePath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %s", err)
+	}
+	defer s.Close()
+	s.CacheSize = 1
+
+	recordAndCheck(t, s)
+
+	// only the height requested last is in memory
+	if len(s.cache.logs) != 1 || s.cache.logs[1] == nil {
+		t.Fatal("Too many heights kept in memory")
+	}
+
+	// messages recorded for a height not in memory are read with the others when requested
+	_ = s.RecordReceived(2, common.NewMessage(common.Prevote, "3", 0, common.NewValue(1), nil))
+
+	hvs, err := s.Get(2)
+	if err != nil || hvs == nil || len(hvs.VoteSetMap[0].ReceivedPrevoteMessages) != 1 || len(hvs.VoteSetMap[0].SentPrevoteMessages) != 1 {
+		t.Fatal("Message logs were not read correctly from the file")
+	}
+
+	if len(s.cache.logs) != 1 || s.cache.logs[2] == nil {
+		t.Fatal("Too many heights kept in memory")
+	}
+}
@@ -83,6 +83,17 @@ func OpenFile(localPath string) (*os.File, error) {
 	return os.OpenFile(path.Join(projectPath, localPath), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
+// GetProjectFilePath returns the absolute path of a file given its local path from the project root
+func GetProjectFilePath(localPath string) (string, error) {
+
+	projectPath, err := getProjectRootPath()
+	if err != nil {
+		return "", fmt.Errorf("error getting project root path: %s", err)
+	}
+
+	return path.Join(projectPath, localPath), nil
+}
+
 func getProjectRootPath() (string, error) {
 
 	_, filePath, _, ok := runtime.Caller(0)
@@ -0,0 +1,196 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// each record is stored as: length (4 bytes) | crc32 checksum of the data (4 bytes) | data
+const (
+	headerSize = 8
+
+	// records larger than this are considered corrupted
+	maxRecordSize = 1 << 30
+)
+
+// Log is an append-only file of checksummed records that survives crashes of the process writing it
+type Log struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// Open opens (or creates) the log at the given path
+// if the process crashed while appending a record, the incomplete tail is discarded
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening log file %s: %s", path, err)
+	}
+
+	l := &Log{file: file}
+
+	// find the end of the last complete record
+	validSize, err := l.scan(nil)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	// remove incomplete or corrupted records at the end of the file
+	err = file.Truncate(validSize)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("error while truncating log file %s: %s", path, err)
+	}
+
+	_, err = file.Seek(validSize, io.SeekStart)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("error while seeking log file %s: %s", path, err)
+	}
+
+	return l, nil
+}
+
+// Append writes a new record at the end of the log and returns only after the record is on disk
+func (l *Log) Append(data []byte) error {
+	_, err := l.AppendRecord(data)
+	return err
+}
+
+// AppendRecord writes a new record at the end of the log like Append and returns its offset, used to read it again with ReadRecord
+func (l *Log) AppendRecord(data []byte) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("error while seeking log file: %s", err)
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+
+	_, err = l.file.Write(record)
+	if err != nil {
+		return 0, fmt.Errorf("error while appending record to log: %s", err)
+	}
+
+	err = l.file.Sync()
+	if err != nil {
+		return 0, fmt.Errorf("error while syncing log to disk: %s", err)
+	}
+
+	return offset, nil
+}
+
+// ReadRecord reads the record at the given offset, returned by AppendRecord or ReplayRecords
+func (l *Log) ReadRecord(offset int64) ([]byte, error) {
+	header := make([]byte, headerSize)
+	_, err := l.file.ReadAt(header, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading log record: %s", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("error while reading log record: record too large")
+	}
+
+	data := make([]byte, length)
+	_, err = l.file.ReadAt(data, offset+headerSize)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading log record: %s", err)
+	}
+
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, fmt.Errorf("error while reading log record: corrupted record")
+	}
+
+	return data, nil
+}
+
+// Replay calls the given function on every record of the log, in the order they were appended
+func (l *Log) Replay(apply func(data []byte) error) error {
+	return l.ReplayRecords(func(offset int64, data []byte) error {
+		return apply(data)
+	})
+}
+
+// ReplayRecords calls the given function on every record of the log and its offset, in the order they were appended
+func (l *Log) ReplayRecords(apply func(offset int64, data []byte) error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err := l.scan(apply)
+	if err != nil {
+		return err
+	}
+
+	// go back to the end of the file for the next appends
+	_, err = l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("error while seeking log file: %s", err)
+	}
+
+	return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// read all the complete records from the beginning of the file and return the size of the valid part of the log
+func (l *Log) scan(apply func(offset int64, data []byte) error) (int64, error) {
+	_, err := l.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("error while seeking log file: %s", err)
+	}
+
+	reader := bufio.NewReader(l.file)
+	header := make([]byte, headerSize)
+	validSize := int64(0)
+
+	for {
+		_, err := io.ReadFull(reader, header)
+		if err != nil {
+			// end of file or incomplete header
+			return validSize, nil
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+
+		if length > maxRecordSize {
+			return validSize, nil
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		if err != nil || crc32.ChecksumIEEE(data) != checksum {
+			// incomplete or corrupted record
+			return validSize, nil
+		}
+
+		if apply != nil {
+			err = apply(validSize, data)
+			if err != nil {
+				return validSize, fmt.Errorf("error while replaying log record: %s", err)
+			}
+		}
+
+		validSize += int64(headerSize + len(data))
+	}
+}
@@ -0,0 +1,144 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func createTestLogPath(t *testing.T) (string, func()) {
+	directory, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	return path.Join(directory, "test.wal"), func() { _ = os.RemoveAll(directory) }
+}
+
+func readAll(t *testing.T, l *Log) [][]byte {
+	records := make([][]byte, 0)
+	err := l.Replay(func(data []byte) error {
+		records = append(records, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to replay log: %s", err)
+	}
+	return records
+}
+
+func TestLog_AppendAndReplayAfterReopen(t *testing.T) {
+
+	logPath, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	l, err := Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to open log: %s", err)
+	}
+
+	expected := [][]byte{[]byte("first"), []byte("second"), {}}
+	for _, record := range expected {
+		if err := l.Append(record); err != nil {
+			t.Fatalf("Failed to append record: %s", err)
+		}
+	}
+	_ = l.Close()
+
+	l, err = Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %s", err)
+	}
+	defer l.Close()
+
+	if !reflect.DeepEqual(readAll(t, l), expected) {
+		t.Fatal("Records replayed are different from the ones appended")
+	}
+
+	// appends after a replay go at the end of the log
+	_ = l.Append([]byte("third"))
+	if len(readAll(t, l)) != len(expected)+1 {
+		t.Fatal("Record appended after replay was not stored correctly")
+	}
+}
+
+func TestLog_IncompleteTailIsDiscarded(t *testing.T) {
+
+	logPath, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	l, err := Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to open log: %s", err)
+	}
+	_ = l.Append([]byte("complete"))
+	_ = l.Close()
+
+	// simulate a crash in the middle of an append
+	f, _ := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0666)
+	_, _ = f.Write([]byte{0, 0, 0, 10, 1, 2})
+	_ = f.Close()
+
+	l, err = Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %s", err)
+	}
+	defer l.Close()
+
+	_ = l.Append([]byte("after crash"))
+
+	expected := [][]byte{[]byte("complete"), []byte("after crash")}
+	if !reflect.DeepEqual(readAll(t, l), expected) {
+		t.Fatal("Incomplete record was not discarded")
+	}
+}
+
+func TestLog_ReadRecord(t *testing.T) {
+
+	logPath, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	l, err := Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to open log: %s", err)
+	}
+
+	offsets := make(map[int64]string)
+	for _, record := range []string{"first", "second", "third"} {
+		offset, err := l.AppendRecord([]byte(record))
+		if err != nil {
+			t.Fatalf("Failed to append record: %s", err)
+		}
+		offsets[offset] = record
+	}
+	_ = l.Close()
+
+	l, err = Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %s", err)
+	}
+	defer l.Close()
+
+	// the offsets replayed are the ones returned when appending
+	err = l.ReplayRecords(func(offset int64, data []byte) error {
+		if offsets[offset] != string(data) {
+			t.Fatalf("Wrong offset %d replayed for record %s", offset, data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to replay log: %s", err)
+	}
+
+	for offset, expected := range offsets {
+		data, err := l.ReadRecord(offset)
+		if err != nil || string(data) != expected {
+			t.Fatalf("Wrong record read at offset %d: %s", offset, data)
+		}
+	}
+
+	if _, err := l.ReadRecord(1); err == nil {
+		t.Fatal("Reading at an offset without record should fail")
+	}
+}